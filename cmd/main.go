@@ -4,10 +4,15 @@ import (
 	"flag"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 
 	"github.com/LiveRamp/ae-copilot/config"
 	"github.com/LiveRamp/ae-copilot/pkg/libs/logger"
+	"github.com/LiveRamp/ae-copilot/pkg/libs/storage"
 	"github.com/LiveRamp/ae-copilot/routers"
 	"github.com/LiveRamp/ae-copilot/scan"
 	"github.com/astaxie/beego/logs"
@@ -15,13 +20,54 @@ import (
 
 func main() {
 	runtime.GOMAXPROCS(128)
-	scan.AsyncRunning()
+	storage.OperationTimeout = time.Second * time.Duration(config.Agent.StorageOperationTimeout)
+	storage.TransferTimeout = time.Second * time.Duration(config.Agent.StorageTransferTimeout)
+	storage.TransferPartSize = int64(config.Agent.StorageTransferPartSizeMB) << 20
+	storage.TransferConcurrency = config.Agent.StorageTransferConcurrency
+	storage.TempPrefix = config.Agent.StorageTempPrefix
+	storage.FileVersions = config.Agent.StorageFileVersions
+	storage.FileURLKey = []byte(config.Agent.StorageFileURLKey)
+	storage.FileURLBase = config.Agent.StorageFileURLBase
+	if dir := config.Agent.StorageDownloadCacheDir; dir != "" {
+		cache, err := storage.NewDownloadCache(dir, int64(config.Agent.StorageDownloadCacheSizeMB)<<20)
+		if err != nil {
+			logs.Error("open download cache %s error, downloads are not cached: %v", dir, err)
+		} else {
+			storage.Cache = cache
+		}
+	}
+	for _, t := range storage.StorageTypes() {
+		retry := config.Agent.StorageRetry[t.ToString()]
+		storage.RetryPolicies[t] = storage.RetryPolicy{
+			MaxAttempts:    retry.MaxAttempts,
+			InitialBackoff: time.Millisecond * time.Duration(retry.InitialBackoffMs),
+			MaxBackoff:     time.Millisecond * time.Duration(retry.MaxBackoffMs),
+			Budget:         time.Second * time.Duration(retry.BudgetSeconds),
+		}
+	}
+	stopScanner := scan.AsyncRunning()
 	logger.Initialize(config.Agent.LogType, config.Agent.LogConf, config.Agent.LogLevel, config.Agent.SendgridConf)
 
 	go func() {
 		logs.Error(http.ListenAndServe("0.0.0.0:6060", nil))
 	}()
 
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		logs.Info("received signal %v, shutting down.", <-signals)
+		// cancels the scanner context, in-flight storage calls are interrupted,
+		// and waits for the scan and the hygiene task in flight to return.
+		grace := time.Second * time.Duration(config.Agent.ShutdownGracePeriod)
+		if !stopScanner(grace) {
+			logs.Warn("scanner did not stop within %v, exiting anyway.", grace)
+		}
+		if err := storage.CloseClients(); err != nil {
+			logs.Error("close storage clients error: %v", err)
+		}
+		os.Exit(0)
+	}()
+
 	var HTTPAddr string
 	flag.StringVar(&HTTPAddr, "addr", "", "")
 	flag.Parse()
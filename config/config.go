@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
 	"strings"
 
@@ -37,6 +38,21 @@ func (*localConfig) defaultInt(key string, defaultValue int) int {
 	return beego.AppConfig.DefaultInt(key, defaultValue)
 }
 
+// StorageRetry is the retry policy of a storage backend.
+type StorageRetry struct {
+	MaxAttempts      int
+	InitialBackoffMs int
+	MaxBackoffMs     int
+	BudgetSeconds    int
+}
+
+// TenantEncryption is the encryption of the gcs objects of a tenant, a customer
+// supplied AES-256 key given in base64 or a Cloud KMS key name.
+type TenantEncryption struct {
+	EncryptionKey string
+	KMSKeyName    string
+}
+
 type configData struct {
 	AppName  string
 	HTTPPort string
@@ -47,12 +63,64 @@ type configData struct {
 	SendgridConf string
 
 	ScanIntervalTime int
+	// ShutdownGracePeriod is how long the scan in flight is waited for on shutdown.
+	ShutdownGracePeriod int
+	// ScanSnapshotDir keeps the listings of the rejected files between restarts,
+	// none if empty.
+	ScanSnapshotDir string
+
+	StorageOperationTimeout int
+	StorageTransferTimeout  int
+	// Large gcs objects are transferred in parts of StorageTransferPartSizeMB,
+	// StorageTransferConcurrency at once.
+	StorageTransferPartSizeMB  int
+	StorageTransferConcurrency int
+	// StorageTempPrefix is the folder, at the root of a bucket, where data is
+	// staged until it is verified.
+	StorageTempPrefix string
+	// StorageFileVersions backups of a local file are kept when it is overwritten or removed, none by default.
+	StorageFileVersions int
+	// StorageDownloadCacheDir keeps up to StorageDownloadCacheSizeMB of the
+	// downloaded objects, an empty folder disables the cache.
+	StorageDownloadCacheDir    string
+	StorageDownloadCacheSizeMB int
+	// The signed urls of local files are signed with StorageFileURLKey, none
+	// if empty, and served at StorageFileURLBase.
+	StorageFileURLKey  string
+	StorageFileURLBase string
+	// StorageRetry is keyed by the storage type name: local, aws, gcp, azure, sftp or memory.
+	StorageRetry map[string]StorageRetry
 
 	InPath     string
 	RejectPath string
 
 	GCSCredentials string
 	Tenants        []string
+	// TenantEncryption is keyed by tenant, the tenants not in it are not encrypted.
+	TenantEncryption map[string]TenantEncryption
+}
+
+// GCSCredentialsOf return the gcs credentials carrying the encryption of the tenant.
+func (c *configData) GCSCredentialsOf(tenant string) string {
+	encryption, ok := c.TenantEncryption[tenant]
+	if !ok {
+		return c.GCSCredentials
+	}
+	credentials := map[string]interface{}{}
+	if strings.TrimSpace(c.GCSCredentials) != "" {
+		if err := json.Unmarshal([]byte(c.GCSCredentials), &credentials); err != nil {
+			// left to the storage to report.
+			return c.GCSCredentials
+		}
+	}
+	if encryption.EncryptionKey != "" {
+		credentials["EncryptionKey"] = encryption.EncryptionKey
+	}
+	if encryption.KMSKeyName != "" {
+		credentials["KMSKeyName"] = encryption.KMSKeyName
+	}
+	data, _ := json.Marshal(credentials)
+	return string(data)
 }
 
 func init() {
@@ -73,6 +141,30 @@ func init() {
 	Agent.SendgridConf = config.defaultString("sendgrid.conf", `{"From":"select-core-team@liveramp.com","To":"david.chen@liveramp.com"}`)
 
 	Agent.ScanIntervalTime = config.defaultInt("scan.interval.time.seconds", 10) // Seconds
+	Agent.ScanSnapshotDir = config.defaultString("scan.snapshot.dir", "")
+	Agent.ShutdownGracePeriod = config.defaultInt("shutdown.grace.period.seconds", 30) // Seconds
+
+	Agent.StorageOperationTimeout = config.defaultInt("storage.operation.timeout.seconds", 60) // Seconds, 0 means no deadline
+	Agent.StorageTransferTimeout = config.defaultInt("storage.transfer.timeout.seconds", 0)    // Seconds, 0 means no deadline
+	Agent.StorageTransferPartSizeMB = config.defaultInt("storage.transfer.part.size.mb", 64)
+	Agent.StorageTransferConcurrency = config.defaultInt("storage.transfer.concurrency", 8) // 1 means single stream transfers
+	Agent.StorageTempPrefix = config.defaultString("storage.temp.prefix", "_ae-copilot/tmp/")
+	Agent.StorageFileVersions = config.defaultInt("storage.file.versions", 0) // 0 keeps no backup
+	Agent.StorageDownloadCacheDir = config.defaultString("storage.download.cache.dir", "")
+	Agent.StorageDownloadCacheSizeMB = config.defaultInt("storage.download.cache.size.mb", 1024)
+	Agent.StorageFileURLKey = config.defaultString("storage.file.url.key", "")
+	Agent.StorageFileURLBase = config.defaultString("storage.file.url.base", "http://"+hostname+":"+Agent.HTTPPort+"/v1/files")
+
+	// The remote backends retry transient errors, local and memory storage do not.
+	Agent.StorageRetry = map[string]StorageRetry{}
+	for backend, attempts := range map[string]int{"local": 1, "aws": 5, "gcp": 5, "azure": 5, "sftp": 5, "memory": 1} {
+		Agent.StorageRetry[backend] = StorageRetry{
+			MaxAttempts:      config.defaultInt("storage."+backend+".retry.max.attempts", attempts),
+			InitialBackoffMs: config.defaultInt("storage."+backend+".retry.initial.backoff.ms", 200),
+			MaxBackoffMs:     config.defaultInt("storage."+backend+".retry.max.backoff.ms", 10000),
+			BudgetSeconds:    config.defaultInt("storage."+backend+".retry.budget.seconds", 120), // 0 means no budget
+		}
+	}
 
 	// Agent.GCSCredentials = config.defaultString("gcs.credentials", `{"ProjectID":"datalake-landing-eng-us-prod"}`)
 	// Agent.RejectPath = "gs://lranalytics-au-endpoint-select-vm/%s/REJECT/"
@@ -80,6 +172,16 @@ func init() {
 	Agent.RejectPath = "gs://lr-select-vm-us-qa-temp/%s/%s"
 
 	Agent.Tenants = strings.Split(config.defaultString("tenants", "721211"), ",")
+	Agent.TenantEncryption = map[string]TenantEncryption{}
+	for _, tenant := range Agent.Tenants {
+		encryption := TenantEncryption{
+			EncryptionKey: config.defaultString("tenant."+tenant+".gcs.encryption.key", ""),
+			KMSKeyName:    config.defaultString("tenant."+tenant+".gcs.kms.key.name", ""),
+		}
+		if encryption != (TenantEncryption{}) {
+			Agent.TenantEncryption[tenant] = encryption
+		}
+	}
 
 	Agent.InPath = "%s/%s/%s"
 
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/LiveRamp/ae-copilot/pkg/libs/storage"
+	"github.com/astaxie/beego/logs"
+)
+
+// FileController serves the local files by the urls signed by storage.FileStorage.
+type FileController struct {
+	ResponseController
+}
+
+func (c *FileController) Get(w http.ResponseWriter, r *http.Request) {
+	node, err := storage.VerifyFileURL(r.Method, r.URL.Query())
+	if err != nil {
+		c.respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	file, err := os.Open(node)
+	if errors.Is(err, os.ErrNotExist) {
+		c.respondWithError(w, http.StatusNotFound, "no such file")
+		return
+	}
+	if err != nil {
+		logs.Error("open signed file %s error: %v", node, err)
+		c.respondWithError(w, http.StatusInternalServerError, "can't open file")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		c.respondWithError(w, http.StatusNotFound, "no such file")
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(node)+`"`)
+	http.ServeContent(w, r, filepath.Base(node), info.ModTime(), file)
+}
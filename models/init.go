@@ -4,4 +4,6 @@ type RejectedFileRemediationTask struct {
 	TaskName       string
 	RejectedPrefix string
 	InPrefix       string
+	// Tenant owns the files, its encryption applies to both.
+	Tenant string
 }
@@ -0,0 +1,880 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// StorageOnAzure is the type of the Azure Blob Storage backend.
+const StorageOnAzure StorageType = StorageInMemory + 1
+
+// azureBlockSize is the size of the blocks staged by OpenWriter and Upload, an
+// object smaller than a block is put at once.
+var azureBlockSize = 4 * 1024 * 1024
+
+// azureCopyPollInterval spaces the checks of a copy that the service completes
+// asynchronously.
+var azureCopyPollInterval = 200 * time.Millisecond
+
+// azureResponseHeaderTimeout bounds the wait for the response of a request once
+// it is sent, the calls themselves are bounded by OperationTimeout and TransferTimeout.
+var azureResponseHeaderTimeout = time.Minute
+
+// AzureStorage is remote storage by Azure Blob Storage, its paths are
+// az://container/blob of the account of the client.
+type AzureStorage struct {
+	opContext
+	AccountName string
+	AccountKey  string
+	// SASToken, the query of a shared access signature, authorizes the calls
+	// when there is no AccountKey.
+	SASToken string
+	// Endpoint overrides the blob endpoint of the account, e.g.
+	// http://127.0.0.1:10000/devstoreaccount1 for Azurite.
+	Endpoint string
+	protocol string
+	client   *azblob.Client
+	// clientErr is the error of the credentials, returned by every call.
+	clientErr error
+}
+
+func init() {
+	Register(Backend{
+		Type:          StorageOnAzure,
+		Name:          "azure",
+		Scheme:        "az://",
+		DecodeOptions: JSONOptions("AccountName", "AccountKey", "SASToken", "Endpoint"),
+		New:           func(opts map[string]interface{}) Storage { return NewAzureStorage(opts) },
+	})
+}
+
+// NewAzureStorage return a new Azure Blob Storage client
+func NewAzureStorage(opts map[string]interface{}) *AzureStorage {
+	azureStorage := new(AzureStorage)
+	if AccountName, ok := opts["AccountName"]; ok {
+		azureStorage.AccountName = AccountName.(string)
+	}
+	if AccountKey, ok := opts["AccountKey"]; ok {
+		azureStorage.AccountKey = AccountKey.(string)
+	}
+	if SASToken, ok := opts["SASToken"]; ok {
+		azureStorage.SASToken = strings.TrimPrefix(SASToken.(string), "?")
+	}
+	if Endpoint, ok := opts["Endpoint"]; ok {
+		azureStorage.Endpoint = strings.TrimSuffix(Endpoint.(string), slash)
+	}
+	if azureStorage.AccountName == "" {
+		azureStorage.AccountName = os.Getenv("AZURE_STORAGE_ACCOUNT")
+	}
+	if azureStorage.AccountKey == "" && azureStorage.SASToken == "" {
+		azureStorage.AccountKey = os.Getenv("AZURE_STORAGE_KEY")
+	}
+	if azureStorage.Endpoint == "" {
+		azureStorage.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", azureStorage.AccountName)
+	}
+	azureStorage.protocol = StorageOnAzure.Protocol()
+	azureStorage.client, azureStorage.clientErr = newAzureClient(azureStorage)
+	return azureStorage
+}
+
+// newAzureClient return the client of the account of a, authorized by its
+// account key or else its sas token. The calls are retried by RetryStorage
+// rather than by the client.
+func newAzureClient(a *AzureStorage) (*azblob.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = azureResponseHeaderTimeout
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	options := &azblob.ClientOptions{ClientOptions: azcore.ClientOptions{
+		Transport: &http.Client{Transport: transport},
+		Retry:     policy.RetryOptions{MaxRetries: -1},
+	}}
+	if a.AccountKey != "" {
+		credential, err := azblob.NewSharedKeyCredential(a.AccountName, a.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("storage: malformed credentials: %v", err)
+		}
+		return azblob.NewClientWithSharedKeyCredential(a.Endpoint+slash, credential, options)
+	}
+	serviceURL := a.Endpoint + slash
+	if a.SASToken != "" {
+		serviceURL += "?" + a.SASToken
+	}
+	return azblob.NewClientWithNoCredential(serviceURL, options)
+}
+
+// WithContext return a copy of the client whose calls are bound to ctx
+func (a *AzureStorage) WithContext(ctx context.Context) Storage {
+	c := *a
+	c.ctx = ctx
+	return &c
+}
+
+func (a *AzureStorage) PathJoin(items ...string) string {
+	if len(items) <= 0 {
+		return ""
+	}
+	items[0] = strings.Replace(items[0], a.protocol, "", 1)
+	return a.protocol + path.Join(items...)
+}
+
+// container return the client of the container of the path.
+func (a *AzureStorage) container(name string) (*container.Client, error) {
+	if a.clientErr != nil {
+		return nil, a.clientErr
+	}
+	return a.client.ServiceClient().NewContainerClient(name), nil
+}
+
+// blob return the client of the block blob key of the container.
+func (a *AzureStorage) blob(containerName, key string) (*blockblob.Client, error) {
+	c, err := a.container(containerName)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewBlockBlobClient(key), nil
+}
+
+// GetObject return a data object by node, checked against the md5 of the blob.
+func (a *AzureStorage) GetObject(node string) ([]byte, error) {
+	rc, err := a.OpenReader(node)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// PutObject save a data object via node, the md5 of data is sent along so that
+// the service rejects corrupted data.
+func (a *AzureStorage) PutObject(node string, data []byte, options ...WriteOption) error {
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	o := newWriteOptions(options)
+	conditions, err := o.azureConditions()
+	if err != nil {
+		return err
+	}
+	b, err := a.blob(opts.Bucket, opts.Key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := a.operation()
+	defer cancel()
+	return putAzureBlob(ctx, b, data, o.azureHeaders(node), o.azureMetadata(), conditions)
+}
+
+// RemoveObject remove a data object via node, removals support no precondition.
+func (a *AzureStorage) RemoveObject(node string, options ...WriteOption) error {
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	if newWriteOptions(options).hasPrecondition() {
+		return fmt.Errorf("%w: azure removal with a precondition", ErrNotSupported)
+	}
+	return a.delete(opts.Bucket, opts.Key)
+}
+
+// RemoveDir remove a folder.
+func (a *AzureStorage) RemoveDir(node string) error {
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	return a.delete(opts.Bucket, opts.Prefix)
+}
+
+// RemoveAll remove every object under the folder, failures are reported as a *PrefixError
+func (a *AzureStorage) RemoveAll(dir string) error {
+	objs, _, err := a.ListObjects(dir)
+	if err != nil {
+		return err
+	}
+	return forEachObject(a.context(), "remove", dir, objs, func(obj *Object) error {
+		return a.RemoveObject(obj.FileName)
+	})
+}
+
+// CopyPrefix copy every object under the folder from into the folder to
+func (a *AzureStorage) CopyPrefix(from, to string) error {
+	return bucketPrefixOp(a.context(), a, "copy", from, to, func(src, dst string) error {
+		return a.CopyObject(src, dst)
+	})
+}
+
+// MovePrefix move every object under the folder from into the folder to
+func (a *AzureStorage) MovePrefix(from, to string) error {
+	return bucketPrefixOp(a.context(), a, "move", from, to, a.MoveObject)
+}
+
+// CopyObject backup this object, dst must satisfy the preconditions of options.
+func (a *AzureStorage) CopyObject(src, dst string, options ...WriteOption) error {
+	srcOpts, err := parseObj(src)
+	if err != nil {
+		return err
+	}
+	conditions, err := newWriteOptions(options).azureConditions()
+	if err != nil {
+		return err
+	}
+	dstOpts, err := parseObj(dst)
+	if err != nil {
+		return err
+	}
+	return a.copyBlob(srcOpts.Bucket, srcOpts.Key, dstOpts.Bucket, dstOpts.Key, conditions)
+}
+
+// MoveObject rename this object
+func (a *AzureStorage) MoveObject(src, dst string) error {
+	srcOpts, err := parseObj(src)
+	if err != nil {
+		return err
+	}
+	dstOpts, err := parseObj(dst)
+	if err != nil {
+		return err
+	}
+	if err := a.copyBlob(srcOpts.Bucket, srcOpts.Key, dstOpts.Bucket, dstOpts.Key, nil); err != nil {
+		return err
+	}
+	return a.delete(srcOpts.Bucket, srcOpts.Key)
+}
+
+// IsExist return false if node doesn't exist
+func (a *AzureStorage) IsExist(node string) bool {
+	_, err := a.Stat(node)
+	return err == nil
+}
+
+// Stat return the attributes and the custom metadata of the object, blobs are
+// not numbered by generations.
+func (a *AzureStorage) Stat(node string) (*Object, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	b, err := a.blob(opts.Bucket, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := a.operation()
+	defer cancel()
+	props, err := b.GetProperties(ctx, nil)
+	if err != nil {
+		return nil, azureError(err)
+	}
+	updated := derefTime(props.LastModified)
+	created := derefTime(props.CreationTime)
+	if created.IsZero() {
+		created = updated
+	}
+	metadata := map[string]string{}
+	for k, v := range props.Metadata {
+		if v != nil {
+			metadata[azureMetaKey(strings.ToLower(k))] = *v
+		}
+	}
+	return &Object{
+		FileName:        node,
+		Size:            derefInt64(props.ContentLength),
+		ModTime:         updated.Unix(),
+		Sum:             azureMD5Hex(props.ContentMD5),
+		Created:         created,
+		Updated:         updated,
+		ContentType:     derefString(props.ContentType),
+		ContentEncoding: derefString(props.ContentEncoding),
+		Metadata:        metadata,
+	}, nil
+}
+
+// ListObjects return all files via prefix dir
+func (a *AzureStorage) ListObjects(dir string) ([]*Object, int64, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	return a.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "", ObjectTypeIsObject)
+}
+
+func (a *AzureStorage) ListChildObjects(dir string) ([]*Object, int64, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	return a.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", ObjectTypeIsObject)
+}
+
+// ListDirs return all dirs via prefix dir
+func (a *AzureStorage) ListDirs(dir string) ([]string, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, err
+	}
+	objs, _, err := a.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", ObjectTypeIsDir)
+	return ObjectsToStrings(objs), err
+}
+
+// Glob calls fn for the objects matching pattern, under the folder of the pattern.
+func (a *AzureStorage) Glob(pattern string, fn WalkFunc) error {
+	return globWalk(a, pattern, fn)
+}
+
+// ListVersions fails with ErrNotSupported, the versions of blobs are not numbered by generations.
+func (a *AzureStorage) ListVersions(node string) ([]*Object, error) {
+	return nil, fmt.Errorf("%w: versions of %s", ErrNotSupported, node)
+}
+
+// RestoreVersion fails with ErrNotSupported, see ListVersions.
+func (a *AzureStorage) RestoreVersion(node string, generation int64) error {
+	return fmt.Errorf("%w: versions of %s", ErrNotSupported, node)
+}
+
+// SignURL fails with ErrNotSupported.
+func (a *AzureStorage) SignURL(node, method string, expiry time.Duration) (string, error) {
+	return "", fmt.Errorf("%w: signed url of %s", ErrNotSupported, node)
+}
+
+// Download download file to local, the data is checked against the md5 of the blob.
+func (a *AzureStorage) Download(from, to string, options ...WriteOption) error {
+	opts, err := parseObj(from)
+	if err != nil {
+		return err
+	}
+	b, err := a.blob(opts.Bucket, opts.Key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := a.transfer()
+	defer cancel()
+	resp, err := b.DownloadStream(ctx, nil)
+	if err != nil {
+		return azureError(err)
+	}
+	defer resp.Body.Close()
+
+	file, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sum := newChecksum()
+	p := newProgress(newWriteOptions(options).progress, derefInt64(resp.ContentLength))
+	buf := make([]byte, 5*1024*1024) //5MB
+	if _, err = io.CopyBuffer(&progressWriter{w: io.MultiWriter(file, sum), progress: p}, resp.Body, buf); err == nil {
+		err = sum.verifyMD5(from, azureMD5(resp.ContentMD5))
+	}
+	if errors.Is(err, ErrChecksumMismatch) {
+		file.Close()
+		os.Remove(to)
+	}
+	if err == nil {
+		p.done()
+	}
+	return err
+}
+
+// Upload put file to remote, in blocks for the files larger than a block. The
+// md5 of the file is kept as the md5 of the blob.
+func (a *AzureStorage) Upload(from, to string, options ...WriteOption) error {
+	sum, err := fileChecksum(from)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := newCustomReader(file, newWriteOptions(options).progress)
+	if err != nil {
+		return err
+	}
+	w, err := a.openWriter(to, append(options, WithMD5(sum.MD5())))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	reader.done()
+	return nil
+}
+
+// OpenReader return a stream of the object, the last read fails with
+// ErrChecksumMismatch if the data does not match the md5 of the blob.
+func (a *AzureStorage) OpenReader(node string) (io.ReadCloser, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	b, err := a.blob(opts.Bucket, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := a.transfer()
+	resp, err := b.DownloadStream(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, azureError(err)
+	}
+	want := azureMD5(resp.ContentMD5)
+	body := newVerifyingReader(resp.Body, func(sum *checksum) error {
+		return sum.verifyMD5(node, want)
+	})
+	return &cancelReadCloser{ReadCloser: body, cancel: cancel}, nil
+}
+
+// OpenWriter return a stream writing into the object, data is staged in
+// blocks committed on Close so that only one block is held in memory. The md5
+// of the data is kept as the md5 of the blob, with WithMD5 Close fails with
+// ErrChecksumMismatch and commits nothing if the data does not match it.
+func (a *AzureStorage) OpenWriter(node string, options ...WriteOption) (io.WriteCloser, error) {
+	return a.openWriter(node, options)
+}
+
+func (a *AzureStorage) openWriter(node string, options []WriteOption) (*azureWriter, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	o := newWriteOptions(options)
+	conditions, err := o.azureConditions()
+	if err != nil {
+		return nil, err
+	}
+	b, err := a.blob(opts.Bucket, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := a.transfer()
+	return &azureWriter{
+		node:       node,
+		blob:       b,
+		ctx:        ctx,
+		cancel:     cancel,
+		headers:    o.azureHeaders(node),
+		metadata:   o.azureMetadata(),
+		conditions: conditions,
+		want:       o.md5,
+		written:    newChecksum(),
+	}, nil
+}
+
+type azureWriter struct {
+	node   string
+	blob   *blockblob.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	// headers, metadata and conditions apply to the request creating the blob,
+	// the single put or the block list.
+	headers    *blob.HTTPHeaders
+	metadata   map[string]*string
+	conditions *blob.AccessConditions
+	// want is the md5 of WithMD5, written the md5 of the data so far.
+	want    []byte
+	written *checksum
+	buf     bytes.Buffer
+	blocks  []string
+}
+
+func (w *azureWriter) Write(p []byte) (int, error) {
+	n, _ := w.buf.Write(p)
+	w.written.Write(p)
+	for w.buf.Len() >= azureBlockSize {
+		if err := w.flushBlock(w.buf.Next(azureBlockSize)); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// flushBlock stages a block along with its md5, the ids of the blocks of a
+// blob must have the same length.
+func (w *azureWriter) flushBlock(data []byte) error {
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(w.blocks))))
+	sum := md5.Sum(data)
+	_, err := w.blob.StageBlock(w.ctx, id, streaming.NopCloser(bytes.NewReader(data)), &blockblob.StageBlockOptions{
+		TransactionalValidation: blob.TransferValidationTypeMD5(sum[:]),
+	})
+	if err != nil {
+		return azureError(err)
+	}
+	w.blocks = append(w.blocks, id)
+	return nil
+}
+
+func (w *azureWriter) Close() error {
+	defer w.cancel()
+	if w.want != nil {
+		if err := w.written.verifyMD5(w.node, w.want); err != nil {
+			// the blocks staged are never committed.
+			return err
+		}
+	}
+	if len(w.blocks) == 0 {
+		return putAzureBlob(w.ctx, w.blob, w.buf.Bytes(), w.headers, w.metadata, w.conditions)
+	}
+	if w.buf.Len() > 0 {
+		if err := w.flushBlock(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	headers := *w.headers
+	headers.BlobContentMD5 = w.written.MD5()
+	_, err := w.blob.CommitBlockList(w.ctx, w.blocks, &blockblob.CommitBlockListOptions{
+		HTTPHeaders:      &headers,
+		Metadata:         w.metadata,
+		AccessConditions: w.conditions,
+	})
+	return azureError(err)
+}
+
+// CloseWithError drops the writer, the blocks staged are never committed and
+// the service discards them.
+func (w *azureWriter) CloseWithError(err error) error {
+	w.cancel()
+	return nil
+}
+
+// putAzureBlob writes data into the blob at once, along with its md5 that the
+// service checks and keeps as the md5 of the blob.
+func putAzureBlob(ctx context.Context, b *blockblob.Client, data []byte, headers *blob.HTTPHeaders, metadata map[string]*string, conditions *blob.AccessConditions) error {
+	sum := md5.Sum(data)
+	withMD5 := *headers
+	withMD5.BlobContentMD5 = sum[:]
+	_, err := b.Upload(ctx, streaming.NopCloser(bytes.NewReader(data)), &blockblob.UploadOptions{
+		HTTPHeaders:             &withMD5,
+		Metadata:                metadata,
+		AccessConditions:        conditions,
+		TransactionalValidation: blob.TransferValidationTypeMD5(sum[:]),
+	})
+	return azureError(err)
+}
+
+// copyBlob copies a blob, waiting for the copies the service completes asynchronously.
+func (a *AzureStorage) copyBlob(srcContainer, srcKey, dstContainer, dstKey string, conditions *blob.AccessConditions) error {
+	src, err := a.blob(srcContainer, srcKey)
+	if err != nil {
+		return err
+	}
+	dst, err := a.blob(dstContainer, dstKey)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := a.operation()
+	defer cancel()
+	resp, err := dst.StartCopyFromURL(ctx, src.URL(), &blob.StartCopyFromURLOptions{AccessConditions: conditions})
+	if err != nil {
+		return azureError(err)
+	}
+	for status := resp.CopyStatus; status != nil && *status == blob.CopyStatusTypePending; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(azureCopyPollInterval):
+		}
+		props, err := dst.GetProperties(ctx, nil)
+		if err != nil {
+			return azureError(err)
+		}
+		status = props.CopyStatus
+		if status != nil && *status != blob.CopyStatusTypePending && *status != blob.CopyStatusTypeSuccess {
+			return fmt.Errorf("azure: copy of %s/%s %s: %s", srcContainer, srcKey, *status, derefString(props.CopyStatusDescription))
+		}
+	}
+	return nil
+}
+
+func (a *AzureStorage) delete(containerName, key string) error {
+	b, err := a.blob(containerName, key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := a.operation()
+	defer cancel()
+	_, err = b.Delete(ctx, nil)
+	return azureError(err)
+}
+
+// azureObject return the object of a listed blob.
+func azureObject(containerName string, item *container.BlobItem) *Object {
+	obj := &Object{FileName: fmt.Sprintf("az://%s/%s", containerName, derefString(item.Name))}
+	if p := item.Properties; p != nil {
+		obj.Size = derefInt64(p.ContentLength)
+		obj.Sum = azureMD5Hex(p.ContentMD5)
+		obj.Updated = derefTime(p.LastModified)
+		obj.Created = derefTime(p.CreationTime)
+		if obj.Created.IsZero() {
+			obj.Created = obj.Updated
+		}
+		obj.ModTime = obj.Updated.Unix()
+	}
+	return obj
+}
+
+func (a *AzureStorage) listByPrefix(containerName, prefix, delim string, types ...ObjectType) ([]*Object, int64, error) {
+	var dirType, objectType bool
+	for _, v := range types {
+		if v == ObjectTypeIsDir {
+			dirType = true
+		}
+		if v == ObjectTypeIsObject {
+			objectType = true
+		}
+	}
+	c, err := a.container(containerName)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx, cancel := a.operation()
+	defer cancel()
+	m := make([]*Object, 0)
+	var size int64
+	add := func(items []*container.BlobItem) {
+		for _, item := range items {
+			obj := azureObject(containerName, item)
+			size += obj.Size
+			if objectType {
+				m = append(m, obj)
+			}
+		}
+	}
+	if delim == "" {
+		pager := c.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, 0, azureError(err)
+			}
+			add(page.Segment.BlobItems)
+		}
+		return m, size, nil
+	}
+	pager := c.NewListBlobsHierarchyPager(delim, &container.ListBlobsHierarchyOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, 0, azureError(err)
+		}
+		if dirType {
+			for _, p := range page.Segment.BlobPrefixes {
+				m = append(m, &Object{FileName: fmt.Sprintf("az://%s/%s", containerName, derefString(p.Name))})
+			}
+		}
+		add(page.Segment.BlobItems)
+	}
+	return m, size, nil
+}
+
+// ListPage return a page of the objects under the folder dir, the page token is
+// the marker of the listing of the container.
+func (a *AzureStorage) ListPage(dir, pageToken string, pageSize int) ([]*Object, string, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := a.container(opts.Bucket)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := a.operation()
+	defer cancel()
+	prefix := appendPathSuffix(opts.Key)
+	listOpts := &container.ListBlobsFlatOptions{Prefix: &prefix}
+	if pageToken != "" {
+		listOpts.Marker = &pageToken
+	}
+	if pageSize > 0 {
+		maxResults := int32(pageSize)
+		listOpts.MaxResults = &maxResults
+	}
+	page, err := c.NewListBlobsFlatPager(listOpts).NextPage(ctx)
+	if err != nil {
+		return nil, "", azureError(err)
+	}
+	objs := make([]*Object, 0, len(page.Segment.BlobItems))
+	for _, item := range page.Segment.BlobItems {
+		objs = append(objs, azureObject(opts.Bucket, item))
+	}
+	return objs, derefString(page.NextMarker), nil
+}
+
+// Walk calls fn for every object under the folder dir
+func (a *AzureStorage) Walk(dir string, fn WalkFunc) error {
+	return walk(a.context(), a, dir, fn)
+}
+
+// AzureError is an error response returned by Azure Blob Storage.
+type AzureError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *AzureError) Error() string {
+	return fmt.Sprintf("azure: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is reports an Md5Mismatch error, a Content-MD5 not matching the data, as
+// ErrChecksumMismatch, and a failed If-None-Match as ErrPreconditionFailed.
+func (e *AzureError) Is(target error) bool {
+	switch target {
+	case ErrChecksumMismatch:
+		return e.Code == "Md5Mismatch"
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed || e.Code == "BlobAlreadyExists"
+	}
+	return false
+}
+
+// azureError converts an error response of the sdk into an *AzureError, a
+// missing blob into ErrCodeNoSuchKey.
+func azureError(err error) error {
+	var respErr *azcore.ResponseError
+	if err == nil || !errors.As(err, &respErr) {
+		return err
+	}
+	e := &AzureError{StatusCode: respErr.StatusCode, Code: respErr.ErrorCode}
+	if respErr.RawResponse != nil {
+		e.Message = respErr.RawResponse.Status
+	}
+	if e.StatusCode == http.StatusNotFound && (e.Code == "" || e.Code == "BlobNotFound") {
+		return ErrCodeNoSuchKey
+	}
+	return e
+}
+
+// azureHeaders return the headers setting the content type of the blob.
+func (o *writeOptions) azureHeaders(node string) *blob.HTTPHeaders {
+	contentType := o.contentTypeOf(node)
+	return &blob.HTTPHeaders{BlobContentType: &contentType}
+}
+
+// azureMetadata return the custom metadata, their names encoded by azureMetaName.
+func (o *writeOptions) azureMetadata() map[string]*string {
+	if len(o.metadata) == 0 {
+		return nil
+	}
+	metadata := make(map[string]*string, len(o.metadata))
+	for k, v := range o.metadata {
+		v := v
+		metadata[azureMetaName(k)] = &v
+	}
+	return metadata
+}
+
+// azureMetaName encodes the key of a custom metadata into a name of blob
+// metadata, which must be a C# identifier and whose case is not kept: the bytes
+// other than lower case letters and digits, a leading digit and the escape _
+// itself are written as _ and their hex code, e.g. rule-version as rule_2dversion.
+func azureMetaName(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'a' <= c && c <= 'z' || '0' <= c && c <= '9' && i > 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "_%02x", c)
+	}
+	return b.String()
+}
+
+// azureMetaKey decodes the name of a blob metadata written by azureMetaName,
+// a name not escaped, e.g. set by another writer, is kept.
+func azureMetaKey(name string) string {
+	if !strings.Contains(name, "_") {
+		return name
+	}
+	key := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		if name[i] != '_' {
+			key = append(key, name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return name
+		}
+		c, err := hex.DecodeString(name[i+1 : i+3])
+		if err != nil {
+			return name
+		}
+		key = append(key, c[0])
+		i += 2
+	}
+	return string(key)
+}
+
+// azureConditions return the preconditions of a write, blobs have no
+// generation so that only IfNotExist is supported.
+func (o *writeOptions) azureConditions() (*blob.AccessConditions, error) {
+	if o.ifGeneration != nil {
+		return nil, fmt.Errorf("%w: azure generation precondition", ErrNotSupported)
+	}
+	if !o.ifNotExist {
+		return nil, nil
+	}
+	any := azcore.ETagAny
+	return &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &any}}, nil
+}
+
+// azureMD5 return the md5 of a blob, nil for the blobs without one, e.g.
+// committed as a block list without it.
+func azureMD5(sum []byte) []byte {
+	if len(sum) != md5.Size {
+		return nil
+	}
+	return sum
+}
+
+// azureMD5Hex return the md5 of a blob in hex, as the Sum of the other backends.
+func azureMD5Hex(sum []byte) string {
+	return hex.EncodeToString(azureMD5(sum))
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func derefInt64(i *int64) int64 {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+func derefTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
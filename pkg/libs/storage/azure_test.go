@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/stretchr/testify/assert"
+)
+
+// The well known account of the Azure storage emulators.
+const (
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// newAzurite return a client of a new container of the emulator whose blob
+// endpoint is in AZURITE_BLOB_ENDPOINT, e.g. http://127.0.0.1:10000/devstoreaccount1,
+// or else of an azurite-blob started from the PATH for the test. The test is
+// skipped without either.
+func newAzurite(t *testing.T) (*AzureStorage, string) {
+	endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		endpoint = startAzurite(t)
+	}
+	client := NewAzureStorage(map[string]interface{}{
+		"AccountName": azuriteAccountName,
+		"AccountKey":  azuriteAccountKey,
+		"Endpoint":    endpoint,
+	})
+	name := fmt.Sprintf("copilot-%d", time.Now().UnixNano())
+	c, err := client.container(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Create(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Delete(context.Background(), nil)
+	})
+	return client, "az://" + name
+}
+
+// startAzurite starts an azurite-blob on a free port, stopped at the end of the test.
+func startAzurite(t *testing.T) string {
+	bin, err := exec.LookPath("azurite-blob")
+	if err != nil {
+		t.Skip("AZURITE_BLOB_ENDPOINT is not set and azurite-blob is not installed")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, port, _ := net.SplitHostPort(addr)
+	cmd := exec.Command(bin, "--silent", "--skipApiVersionCheck", "--blobHost", "127.0.0.1", "--blobPort", port, "--location", t.TempDir())
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("azurite-blob is not listening on %s: %v", addr, err)
+		}
+	}
+	return "http://" + addr + "/" + azuriteAccountName
+}
+
+func TestAzureMetaName(t *testing.T) {
+	for key, name := range map[string]string{
+		"source":       "source",
+		"rule-version": "rule_2dversion",
+		"processed-at": "processed_2dat",
+		"Source_Path":  "_53ource_5f_50ath",
+		"2nd.key":      "_32nd_2ekey",
+	} {
+		assert.Equal(t, name, azureMetaName(key))
+		assert.Equal(t, key, azureMetaKey(name))
+	}
+	// the names not escaped are kept.
+	assert.Equal(t, "bad_name", azureMetaKey("bad_name"))
+	assert.Equal(t, "trailing_", azureMetaKey("trailing_"))
+}
+
+func TestAzureError(t *testing.T) {
+	response := func(status int, code string) error {
+		resp := &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    &http.Request{Method: http.MethodGet, URL: &url.URL{Scheme: "https", Host: "account.blob.core.windows.net", Path: "/container/key"}},
+		}
+		if code != "" {
+			resp.Header.Set("x-ms-error-code", code)
+		}
+		return runtime.NewResponseError(resp)
+	}
+	assert.Nil(t, azureError(nil))
+	assert.Equal(t, io.ErrUnexpectedEOF, azureError(io.ErrUnexpectedEOF))
+	assert.Equal(t, ErrCodeNoSuchKey, azureError(response(http.StatusNotFound, "BlobNotFound")))
+	assert.Equal(t, ErrCodeNoSuchKey, azureError(response(http.StatusNotFound, "")))
+
+	err := azureError(response(http.StatusNotFound, "ContainerNotFound"))
+	assert.Equal(t, &AzureError{StatusCode: http.StatusNotFound, Code: "ContainerNotFound", Message: "Not Found"}, err)
+	assert.False(t, IsRetryable(err))
+
+	err = azureError(response(http.StatusServiceUnavailable, "ServerBusy"))
+	assert.True(t, IsRetryable(err))
+	err = azureError(response(http.StatusBadRequest, "Md5Mismatch"))
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	err = azureError(response(http.StatusConflict, "BlobAlreadyExists"))
+	assert.True(t, errors.Is(err, ErrPreconditionFailed))
+	err = azureError(response(http.StatusPreconditionFailed, "ConditionNotMet"))
+	assert.True(t, errors.Is(err, ErrPreconditionFailed))
+
+	// a malformed account key fails every call.
+	client := NewAzureStorage(map[string]interface{}{"AccountName": "account", "AccountKey": "not base64!"})
+	_, err = client.Stat("az://container/key")
+	assert.NotNil(t, err)
+}
+
+func TestNewStorageClient_Azure(t *testing.T) {
+	client, err := NewStorageClient("az://container/key", `{"AccountName":"account","AccountKey":"a2V5"}`)
+	assert.Nil(t, err)
+	backend, err := client.(*routingStorage).backend("az://container/key")
+	assert.Nil(t, err)
+	azureClient, ok := backend.(*AzureStorage)
+	assert.True(t, ok)
+	assert.Equal(t, "account", azureClient.AccountName)
+	assert.Equal(t, "https://account.blob.core.windows.net", azureClient.Endpoint)
+	assert.Equal(t, "azure", StorageOnAzure.ToString())
+
+	_, err = NewStorageClient("az://container/key", `{"AccountKey":1}`)
+	assert.NotNil(t, err)
+}
+
+func TestAzureStorage(t *testing.T) {
+	client, container := newAzurite(t)
+
+	prefix := container + "/audience_1_tenant"
+	for _, v := range mockFiles {
+		assert.Nil(t, client.PutObject(fmt.Sprintf(v, container, 1), []byte(mockContent)))
+	}
+	bitmap := client.PathJoin(prefix, "bitmap")
+
+	data, err := client.GetObject(bitmap)
+	assert.Nil(t, err)
+	assert.Equal(t, mockContent, string(data))
+
+	_, err = client.GetObject(client.PathJoin(prefix, "missing"))
+	assert.Equal(t, ErrCodeNoSuchKey, err)
+
+	obj, err := client.Stat(bitmap)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(mockContent)), obj.Size)
+	assert.Equal(t, "eb733a00c0c9d336e65691a37ab54293", obj.Sum)
+
+	// the keys of the metadata need not be C# identifiers, as the ones of hygiene.
+	metadata := map[string]string{"rule-version": "3", "processed-at": "2022-03-04T05:06:07Z", "Source_Path": "in/a.csv"}
+	remediated := client.PathJoin(prefix, "remediated")
+	assert.Nil(t, client.PutObject(remediated, []byte(mockContent), WithMetadata(metadata)))
+	obj, err = client.Stat(remediated)
+	assert.Nil(t, err)
+	assert.Equal(t, metadata, obj.Metadata)
+	w, err := client.OpenWriter(remediated+".streamed", WithMetadata(metadata))
+	assert.Nil(t, err)
+	fmt.Fprint(w, mockContent)
+	assert.Nil(t, w.Close())
+	obj, err = client.Stat(remediated + ".streamed")
+	assert.Nil(t, err)
+	assert.Equal(t, metadata, obj.Metadata)
+
+	objs, size, err := client.ListObjects(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, 12, len(objs))
+	assert.Equal(t, int64(12*len(mockContent)), size)
+
+	objs, _, err = client.ListChildObjects(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, 9, len(objs))
+
+	dirs, err := client.ListDirs(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{prefix + "/attrs/"}, dirs)
+
+	objs, err = globAll(client, prefix+"/attrs/*-00?")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{prefix + "/attrs/PART-001", prefix + "/attrs/PART-002", prefix + "/attrs/PART-003"}, ObjectsToStrings(objs))
+
+	page, next, err := client.ListPage(prefix, "", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(page))
+	assert.NotEmpty(t, next)
+
+	err = client.PutObject(bitmap, []byte("other"), IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	copied := client.PathJoin(prefix, "copy with space")
+	assert.Nil(t, client.CopyObject(bitmap, copied))
+	assert.True(t, client.IsExist(copied))
+	err = client.CopyObject(bitmap, copied, IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	moved := client.PathJoin(prefix, "moved")
+	assert.Nil(t, client.MoveObject(copied, moved))
+	assert.False(t, client.IsExist(copied))
+	assert.True(t, client.IsExist(moved))
+
+	tempDir, err := ioutil.TempDir("", "azureStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	local := filepath.Join(tempDir, "bitmap")
+	assert.Nil(t, client.Download(bitmap, local))
+	assert.Nil(t, client.Upload(local, client.PathJoin(prefix, "uploaded")))
+	data, err = client.GetObject(client.PathJoin(prefix, "uploaded"))
+	assert.Nil(t, err)
+	assert.Equal(t, mockContent, string(data))
+
+	assert.Nil(t, client.RemoveAll(prefix))
+	objs, _, err = client.ListObjects(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(objs))
+}
+
+func TestAzureStorage_Blocks(t *testing.T) {
+	client, container := newAzurite(t)
+	defer func(size int) { azureBlockSize = size }(azureBlockSize)
+	azureBlockSize = 1000
+
+	node := container + "/in/streamed.csv"
+	w, err := client.OpenWriter(node)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(w, "line,%d\n", i)
+	}
+	assert.Nil(t, w.Close())
+	r, err := client.OpenReader(node)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "line,0\nline,1\n"))
+	assert.True(t, strings.HasSuffix(string(data), "line,499\n"))
+
+	tempDir, err := ioutil.TempDir("", "azureStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	large := bytes.Repeat([]byte("0123456789"), 350)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "large.csv"), large, 0640))
+	assert.Nil(t, client.Upload(filepath.Join(tempDir, "large.csv"), container+"/in/large.csv"))
+	obj, err := client.Stat(container + "/in/large.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "0e29d0ea78cee4d5794bcd5f817966e1", obj.Sum)
+	assert.Nil(t, client.Download(container+"/in/large.csv", filepath.Join(tempDir, "large.out")))
+	data, err = ioutil.ReadFile(filepath.Join(tempDir, "large.out"))
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(large, data))
+
+	w, err = client.OpenWriter(container + "/in/aborted.csv")
+	assert.Nil(t, err)
+	fmt.Fprint(w, strings.Repeat("partial data", 100))
+	assert.Nil(t, AbortWriter(w, io.ErrUnexpectedEOF))
+	assert.False(t, client.IsExist(container+"/in/aborted.csv"))
+
+	// data not matching the md5 is never committed.
+	w, err = client.OpenWriter(container+"/in/corrupted.csv", WithMD5(make([]byte, 16)))
+	assert.Nil(t, err)
+	w.Write(large)
+	assert.True(t, errors.Is(w.Close(), ErrChecksumMismatch))
+	assert.False(t, client.IsExist(container+"/in/corrupted.csv"))
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BulkConcurrency bounds the objects processed in parallel by the prefix operations
+// RemoveAll, CopyPrefix and MovePrefix.
+var BulkConcurrency = 16
+
+// PrefixError reports the objects a prefix operation failed on, every other
+// object of the prefix was processed successfully.
+type PrefixError struct {
+	Op     string
+	Prefix string
+	Total  int
+	Failed map[string]error
+}
+
+func (e *PrefixError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("%s %s: %d of %d objects failed, %s: %v",
+		e.Op, e.Prefix, len(e.Failed), e.Total, names[0], e.Failed[names[0]])
+}
+
+// forEachObject calls fn for every object with at most BulkConcurrency calls in flight.
+// It does not stop at the first failure, every failure is collected into a PrefixError.
+// Objects not started yet when ctx is done fail with the context error.
+func forEachObject(ctx context.Context, op, prefix string, objs []*Object, fn func(obj *Object) error) error {
+	failed := map[string]error{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := BulkConcurrency
+	if limit <= 0 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	for _, obj := range objs {
+		if err := ctx.Err(); err != nil {
+			mu.Lock()
+			failed[obj.FileName] = err
+			mu.Unlock()
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(obj *Object) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(obj); err != nil {
+				mu.Lock()
+				failed[obj.FileName] = err
+				mu.Unlock()
+			}
+		}(obj)
+	}
+	wg.Wait()
+	if len(failed) == 0 {
+		return nil
+	}
+	return &PrefixError{Op: op, Prefix: prefix, Total: len(objs), Failed: failed}
+}
+
+// relativeKey return the key of node below the folder dir, both are bucket paths.
+func relativeKey(dir, node string) (string, error) {
+	dirOpts, err := parseObj(dir)
+	if err != nil {
+		return "", err
+	}
+	nodeOpts, err := parseObj(node)
+	if err != nil {
+		return "", err
+	}
+	prefix := appendPathSuffix(dirOpts.Key)
+	if nodeOpts.Bucket != dirOpts.Bucket || !strings.HasPrefix(nodeOpts.Key, prefix) {
+		return "", fmt.Errorf("%v %s is not under %s", IllegalPath, node, dir)
+	}
+	return strings.TrimPrefix(nodeOpts.Key, prefix), nil
+}
+
+// bucketPrefixOp applies op to every object under the folder from and its
+// counterpart under the folder to, it serves the bucket based backends.
+func bucketPrefixOp(ctx context.Context, s Storage, name, from, to string, op func(src, dst string) error) error {
+	objs, _, err := s.ListObjects(from)
+	if err != nil {
+		return err
+	}
+	return forEachObject(ctx, name, from, objs, func(obj *Object) error {
+		key, err := relativeKey(from, obj.FileName)
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			// the placeholder object of the folder itself.
+			return nil
+		}
+		return op(obj.FileName, s.PathJoin(to, key))
+	})
+}
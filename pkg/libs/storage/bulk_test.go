@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForEachObject(t *testing.T) {
+	objs := make([]*Object, 50)
+	for i := range objs {
+		objs[i] = &Object{FileName: fmt.Sprintf("gs://bucket/%02d", i)}
+	}
+	failure := errors.New("failure")
+	err := forEachObject(context.Background(), "copy", "gs://bucket", objs, func(obj *Object) error {
+		if obj.FileName == "gs://bucket/07" {
+			return failure
+		}
+		return nil
+	})
+	prefixErr, ok := err.(*PrefixError)
+	assert.True(t, ok)
+	assert.Equal(t, 50, prefixErr.Total)
+	assert.Equal(t, map[string]error{"gs://bucket/07": failure}, prefixErr.Failed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = forEachObject(ctx, "copy", "gs://bucket", objs, func(obj *Object) error { return nil })
+	assert.Equal(t, 50, len(err.(*PrefixError).Failed))
+}
+
+func doPrefixTestCases(t *testing.T, client Storage, root string) {
+	rejected := client.PathJoin(root, "721211", "REJECT")
+	archive := client.PathJoin(root, "721211", "ARCHIVE")
+	moved := client.PathJoin(root, "721211", "MOVED")
+	names := []string{"a.csv", "folder/b.csv", "folder/deep/c.csv"}
+	for _, name := range names {
+		assert.Nil(t, client.PutObject(client.PathJoin(rejected, name), []byte(name)))
+	}
+	relative := func(dir string) []string {
+		objs, _, err := client.ListObjects(dir)
+		assert.Nil(t, err)
+		files := make([]string, 0, len(objs))
+		for _, v := range objs {
+			files = append(files, v.FileName[len(dir)+1:])
+		}
+		sort.Strings(files)
+		return files
+	}
+
+	assert.Nil(t, client.CopyPrefix(rejected, archive))
+	assert.Equal(t, names, relative(archive))
+	assert.Equal(t, names, relative(rejected))
+	data, err := client.GetObject(client.PathJoin(archive, "folder/deep/c.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, "folder/deep/c.csv", string(data))
+
+	assert.Nil(t, client.MovePrefix(archive, moved))
+	assert.Equal(t, names, relative(moved))
+	assert.False(t, client.IsExist(client.PathJoin(archive, "a.csv")))
+
+	assert.Nil(t, client.RemoveAll(rejected))
+	assert.Nil(t, client.RemoveAll(moved))
+	objs, _, _ := client.ListObjects(client.PathJoin(root, "721211"))
+	assert.Equal(t, 0, len(objs))
+}
+
+func TestPrefixOperations(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	t.Run("file", func(t *testing.T) {
+		doPrefixTestCases(t, NewFileStorage(nil), tempDir)
+	})
+	t.Run("gcs", func(t *testing.T) {
+		newFakeGCS(t)
+		doPrefixTestCases(t, NewGCSStorage(nil), "gs://bucket")
+	})
+	t.Run("s3", func(t *testing.T) {
+		_, srv := newFakeS3(t)
+		doPrefixTestCases(t, newTestS3Storage(srv.URL), "s3://bucket")
+	})
+	t.Run("mem", func(t *testing.T) {
+		doPrefixTestCases(t, NewMemStorage(nil), "mem://prefix-operations")
+	})
+}
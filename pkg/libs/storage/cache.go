@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache, if set, keeps the downloads of the remote backends on local disk,
+// NewStorage wraps their clients into a CachingStorage.
+var Cache *DownloadCache
+
+// Counts of the downloads served from the cache, of the ones missing it and of
+// the entries evicted.
+var cacheCount = expvar.NewMap("storage_download_cache")
+
+// DownloadCache is a local folder of downloaded objects, each keyed by its path
+// and its md5, so that an object rewritten since it was cached is not served.
+// The least recently used entries are evicted beyond the size of the cache.
+type DownloadCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cacheEntry, the most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// NewDownloadCache return the cache in the folder dir holding up to maxSize
+// bytes. The entries already in dir are kept, ordered by their last use.
+func NewDownloadCache(dir string, maxSize int64) (*DownloadCache, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &DownloadCache{dir: dir, maxSize: maxSize, lru: list.New(), entries: map[string]*list.Element{}}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().After(infos[j].ModTime()) })
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			// left by a store interrupted.
+			os.Remove(filepath.Join(dir, info.Name()))
+			continue
+		}
+		c.entries[info.Name()] = c.lru.PushBack(&cacheEntry{key: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict()
+	return c, nil
+}
+
+// cacheKey names the entry of the object node whose md5 is sum.
+func cacheKey(node string, sum []byte) string {
+	key := sha256.Sum256([]byte(node + "\x00" + hex.EncodeToString(sum)))
+	return hex.EncodeToString(key[:])
+}
+
+func (c *DownloadCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// fetch copies the entry of node and sum to the file to, it returns false if
+// there is none.
+func (c *DownloadCache) fetch(ctx context.Context, node string, sum []byte, to string, fn ProgressFunc) bool {
+	key := cacheKey(node, sum)
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(element)
+	}
+	c.mu.Unlock()
+	if !ok {
+		cacheCount.Add("misses", 1)
+		return false
+	}
+	// the time of the entry keeps its use across restarts.
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	if err := copyFileReporting(ctx, c.path(key), to, fn); err != nil {
+		// evicted meanwhile.
+		cacheCount.Add("misses", 1)
+		return false
+	}
+	cacheCount.Add("hits", 1)
+	return true
+}
+
+// store copies the file from, downloaded from node, into the cache if its md5 is sum.
+func (c *DownloadCache) store(node string, sum []byte, from string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > c.maxSize {
+		return nil
+	}
+	key := cacheKey(node, sum)
+	out, err := ioutil.TempFile(c.dir, "."+key+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	written := newChecksum()
+	if _, err := io.Copy(io.MultiWriter(out, written), in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if !bytes.Equal(written.MD5(), sum) {
+		return fmt.Errorf("%w: %s downloaded is not the object stat", ErrChecksumMismatch, node)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(out.Name(), c.path(key)); err != nil {
+		return err
+	}
+	if element, ok := c.entries[key]; ok {
+		c.size -= element.Value.(*cacheEntry).size
+		c.lru.Remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: info.Size()})
+	c.size += info.Size()
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used entries until the cache fits its size, c.mu is held.
+func (c *DownloadCache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		entry := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+		os.Remove(c.path(entry.key))
+		cacheCount.Add("evictions", 1)
+	}
+}
+
+// CachingStorage serves the downloads of a Storage from a DownloadCache while
+// the md5 of the object still matches the one cached. The objects without an
+// md5, e.g. the s3 multipart uploads, are always downloaded.
+type CachingStorage struct {
+	Storage
+	opContext
+	cache *DownloadCache
+}
+
+// NewCachingStorage return s downloading through cache.
+func NewCachingStorage(s Storage, cache *DownloadCache) *CachingStorage {
+	return &CachingStorage{Storage: s, cache: cache}
+}
+
+// WithContext return a copy of the storage whose calls are bound to ctx
+func (c *CachingStorage) WithContext(ctx context.Context) Storage {
+	return &CachingStorage{Storage: c.Storage.WithContext(ctx), opContext: opContext{ctx}, cache: c.cache}
+}
+
+// Download get remote file to local, from the cache if the object is unchanged.
+// A fresh download is cached once it matches the md5 of the object.
+func (c *CachingStorage) Download(from, to string, opts ...WriteOption) error {
+	obj, err := c.Storage.Stat(from)
+	if err != nil {
+		return err
+	}
+	sum, ok := md5Of(obj)
+	if !ok || obj.ContentEncoding == "gzip" {
+		return c.Storage.Download(from, to, opts...)
+	}
+	ctx, cancel := c.transfer()
+	defer cancel()
+	if c.cache.fetch(ctx, from, sum, to, newWriteOptions(opts).progress) {
+		return nil
+	}
+	if err := c.Storage.Download(from, to, opts...); err != nil {
+		return err
+	}
+	// the object may have changed since its stat, it is then not cached.
+	c.cache.store(from, sum, to)
+	return nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStorage counts the downloads reaching the storage.
+type countingStorage struct {
+	Storage
+	downloads int
+}
+
+func (c *countingStorage) Download(from, to string, opts ...WriteOption) error {
+	c.downloads++
+	return c.Storage.Download(from, to, opts...)
+}
+
+func TestCachingStorage_Download(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "downloadCache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	cache, err := NewDownloadCache(filepath.Join(tempDir, "cache"), 8)
+	assert.Nil(t, err)
+	mem := &countingStorage{Storage: NewMemStorage(nil)}
+	client := NewCachingStorage(mem, cache)
+	to := filepath.Join(tempDir, "a.csv")
+
+	assert.Nil(t, client.PutObject("mem://download-cache/a.csv", []byte("hello")))
+	assert.Nil(t, client.Download("mem://download-cache/a.csv", to))
+	assert.Nil(t, os.Remove(to))
+	var reported int64
+	assert.Nil(t, client.Download("mem://download-cache/a.csv", to, WithProgress(func(p Progress) { reported = p.Transferred })))
+	assert.Equal(t, 1, mem.downloads)
+	assert.Equal(t, int64(5), reported)
+	data, err := ioutil.ReadFile(to)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// rewritten, the md5 no longer matches the entry.
+	assert.Nil(t, client.PutObject("mem://download-cache/a.csv", []byte("world")))
+	assert.Nil(t, client.Download("mem://download-cache/a.csv", to))
+	assert.Equal(t, 2, mem.downloads)
+	data, err = ioutil.ReadFile(to)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(data))
+	assert.Nil(t, client.Download("mem://download-cache/a.csv", to))
+	assert.Equal(t, 2, mem.downloads)
+
+	// the entry of hello was the least recently used.
+	infos, err := ioutil.ReadDir(filepath.Join(tempDir, "cache"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(infos))
+
+	// larger than the cache, never kept.
+	assert.Nil(t, client.PutObject("mem://download-cache/b.csv", []byte("larger than 8")))
+	assert.Nil(t, client.Download("mem://download-cache/b.csv", to))
+	assert.Nil(t, client.Download("mem://download-cache/b.csv", to))
+	assert.Equal(t, 4, mem.downloads)
+
+	assert.Equal(t, ErrCodeNoSuchKey, client.Download("mem://download-cache/missing.csv", to))
+}
+
+func TestNewDownloadCache(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "downloadCache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	cache, err := NewDownloadCache(tempDir, 10)
+	assert.Nil(t, err)
+	from := filepath.Join(tempDir, "..", filepath.Base(tempDir)+".csv")
+	defer os.Remove(from)
+	sumOf := func(content string) []byte {
+		sum := md5.Sum([]byte(content))
+		return sum[:]
+	}
+	for i, content := range []string{"hello", "world"} {
+		assert.Nil(t, ioutil.WriteFile(from, []byte(content), 0640))
+		assert.Nil(t, cache.store("mem://download-cache/"+content, sumOf(content), from))
+		used := time.Now().Add(time.Duration(i-2) * time.Hour)
+		assert.Nil(t, os.Chtimes(cache.path(cacheKey("mem://download-cache/"+content, sumOf(content))), used, used))
+	}
+	err = cache.store("mem://download-cache/other", sumOf("other"), from)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// reopened smaller, the entries are kept by their last use.
+	cache, err = NewDownloadCache(tempDir, 5)
+	assert.Nil(t, err)
+	to := filepath.Join(tempDir, "..", filepath.Base(tempDir)+".out")
+	defer os.Remove(to)
+	assert.False(t, cache.fetch(context.Background(), "mem://download-cache/hello", sumOf("hello"), to, nil))
+	assert.True(t, cache.fetch(context.Background(), "mem://download-cache/world", sumOf("world"), to, nil))
+	data, err := ioutil.ReadFile(to)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(data))
+}
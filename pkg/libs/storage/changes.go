@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ChangeType tells how an object changed between two snapshots of a ChangeFeed.
+type ChangeType int
+
+const (
+	ObjectAdded ChangeType = iota
+	ObjectModified
+	ObjectRemoved
+)
+
+func (c ChangeType) String() string {
+	switch c {
+	case ObjectAdded:
+		return "added"
+	case ObjectModified:
+		return "modified"
+	case ObjectRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is an object added, modified or removed since the last snapshot, a
+// removed object is the one of the last snapshot.
+type Change struct {
+	Type   ChangeType
+	Object *Object
+}
+
+// ChangeFeed lists the objects matching a pattern of Glob and reports the
+// changes since the listing committed last. The snapshot of the committed
+// listing is kept in a local file, if any, so that a restart does not report
+// every object again.
+type ChangeFeed struct {
+	s        Storage
+	pattern  string
+	path     string
+	snapshot map[string]snapshotObject
+	pending  map[string]snapshotObject
+}
+
+// snapshotObject is what a snapshot keeps of an object to tell it changed.
+type snapshotObject struct {
+	Size       int64
+	Sum        string    `json:",omitempty"`
+	Generation int64     `json:",omitempty"`
+	Updated    time.Time `json:",omitempty"`
+}
+
+// snapshotFile is the content of the file of a snapshot.
+type snapshotFile struct {
+	Pattern string
+	Objects map[string]snapshotObject
+}
+
+// NewChangeFeed return the feed of the objects of s matching pattern, its
+// snapshot is loaded from and saved to the local file path, none if empty. A
+// snapshot of another pattern is ignored.
+func NewChangeFeed(s Storage, pattern, path string) (*ChangeFeed, error) {
+	c := &ChangeFeed{s: s, pattern: pattern, path: path, snapshot: map[string]snapshotObject{}}
+	if path == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved snapshotFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	if saved.Pattern == pattern && saved.Objects != nil {
+		c.snapshot = saved.Objects
+	}
+	return c, nil
+}
+
+func snapshotOf(obj *Object) snapshotObject {
+	return snapshotObject{Size: obj.Size, Sum: obj.Sum, Generation: obj.Generation, Updated: obj.Updated.UTC()}
+}
+
+// object return the object name as the snapshot kept it.
+func (o snapshotObject) object(name string) *Object {
+	return &Object{FileName: name, Size: o.Size, Sum: o.Sum, Generation: o.Generation, Updated: o.Updated}
+}
+
+// modified compares by generation where the storage numbers them, by content otherwise.
+func (o snapshotObject) modified(last snapshotObject) bool {
+	if o.Generation != 0 || last.Generation != 0 {
+		return o.Generation != last.Generation
+	}
+	if o.Sum != "" || last.Sum != "" {
+		return o.Sum != last.Sum || o.Size != last.Size
+	}
+	return o.Size != last.Size || !o.Updated.Equal(last.Updated)
+}
+
+// Walk globs the objects and calls fn for every change since the last snapshot
+// as the objects are listed, in lexical order of the names: an object removed
+// is reported once the listing has passed its name. Only the snapshots are
+// held, not the objects listed. The listing becomes the snapshot on Commit if
+// it ran to its end, the changes are reported again until then.
+func (c *ChangeFeed) Walk(ctx context.Context, fn func(change *Change) error) error {
+	names := make([]string, 0, len(c.snapshot))
+	for name := range c.snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.pending = map[string]snapshotObject{}
+	stopped := false
+	report := func(change *Change) error {
+		err := fn(change)
+		if err == ErrStopWalk {
+			stopped = true
+		}
+		return err
+	}
+	// removedBefore reports the objects of the snapshot sorting before name, all
+	// of them if name is empty, that the listing did not return.
+	i := 0
+	removedBefore := func(name string) error {
+		for ; i < len(names) && (name == "" || names[i] < name); i++ {
+			if _, ok := c.pending[names[i]]; ok {
+				continue
+			}
+			if err := report(&Change{Type: ObjectRemoved, Object: c.snapshot[names[i]].object(names[i])}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := c.s.WithContext(ctx).Glob(c.pattern, func(obj *Object) error {
+		if err := removedBefore(obj.FileName); err != nil {
+			return err
+		}
+		current := snapshotOf(obj)
+		c.pending[obj.FileName] = current
+		if last, ok := c.snapshot[obj.FileName]; !ok {
+			return report(&Change{Type: ObjectAdded, Object: obj})
+		} else if current.modified(last) {
+			return report(&Change{Type: ObjectModified, Object: obj})
+		}
+		return nil
+	})
+	if err == nil && !stopped {
+		err = removedBefore("")
+	}
+	if err != nil || stopped {
+		// an incomplete listing is not committed.
+		c.pending = nil
+	}
+	if err == ErrStopWalk {
+		return nil
+	}
+	return err
+}
+
+// Changes return the changes of Walk.
+func (c *ChangeFeed) Changes(ctx context.Context) ([]*Change, error) {
+	changes := make([]*Change, 0)
+	if err := c.Walk(ctx, func(change *Change) error {
+		changes = append(changes, change)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Has return true if the object name was in the last listing of Walk, or in
+// the listing so far during Walk.
+func (c *ChangeFeed) Has(name string) bool {
+	_, ok := c.pending[name]
+	return ok
+}
+
+// Commit makes the last listing of Walk the snapshot and saves it.
+func (c *ChangeFeed) Commit() error {
+	if c.pending == nil {
+		return nil
+	}
+	if c.path != "" {
+		data, err := json.Marshal(&snapshotFile{Pattern: c.pattern, Objects: c.pending})
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(c.path, data); err != nil {
+			return err
+		}
+	}
+	c.snapshot, c.pending = c.pending, nil
+	return nil
+}
+
+// writeFileAtomic writes data into a temporary file renamed to path, so that
+// path is never seen half written.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	out, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err := os.Rename(out.Name(), path); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return nil
+}
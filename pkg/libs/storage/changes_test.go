@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func changesOf(t *testing.T, c *ChangeFeed) map[string]ChangeType {
+	changes, err := c.Changes(context.Background())
+	assert.Nil(t, err)
+	types := map[string]ChangeType{}
+	for _, change := range changes {
+		types[change.Object.FileName] = change.Type
+	}
+	return types
+}
+
+func TestChangeFeed(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "changes")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	snapshot := filepath.Join(tempDir, "snapshots", "reject.json")
+	m := NewMemStorage(nil)
+	assert.Nil(t, m.PutObject("mem://changes/REJECT/f/a.csv", []byte("a")))
+	assert.Nil(t, m.PutObject("mem://changes/REJECT/f/b.csv", []byte("b")))
+	assert.Nil(t, m.PutObject("mem://changes/REJECT/c.csv", []byte("c")))
+
+	feed, err := NewChangeFeed(m, "mem://changes/REJECT/*/*", snapshot)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]ChangeType{
+		"mem://changes/REJECT/f/a.csv": ObjectAdded,
+		"mem://changes/REJECT/f/b.csv": ObjectAdded,
+	}, changesOf(t, feed))
+	assert.True(t, feed.Has("mem://changes/REJECT/f/a.csv"))
+	assert.False(t, feed.Has("mem://changes/REJECT/c.csv"))
+	// reported again until committed.
+	assert.Len(t, changesOf(t, feed), 2)
+	assert.Nil(t, feed.Commit())
+	assert.Empty(t, changesOf(t, feed))
+
+	assert.Nil(t, m.PutObject("mem://changes/REJECT/f/a.csv", []byte("A")))
+	assert.Nil(t, m.RemoveObject("mem://changes/REJECT/f/b.csv"))
+	assert.Nil(t, m.PutObject("mem://changes/REJECT/f/d.csv", []byte("d")))
+	changes, err := feed.Changes(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, ObjectModified, changes[0].Type)
+	assert.Equal(t, ObjectRemoved, changes[1].Type)
+	assert.Equal(t, "mem://changes/REJECT/f/b.csv", changes[1].Object.FileName)
+	assert.Equal(t, int64(1), changes[1].Object.Size)
+	assert.Equal(t, ObjectAdded, changes[2].Type)
+	assert.Nil(t, feed.Commit())
+
+	// restarted from the saved snapshot.
+	assert.Nil(t, m.PutObject("mem://changes/REJECT/f/e.csv", []byte("e")))
+	restarted, err := NewChangeFeed(m, "mem://changes/REJECT/*/*", snapshot)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]ChangeType{"mem://changes/REJECT/f/e.csv": ObjectAdded}, changesOf(t, restarted))
+
+	// the snapshot of another pattern is not reused.
+	other, err := NewChangeFeed(m, "mem://changes/REJECT/f/*", snapshot)
+	assert.Nil(t, err)
+	assert.Len(t, changesOf(t, other), 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = restarted.Changes(ctx)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(snapshot, []byte("{"), 0640))
+	_, err = NewChangeFeed(m, "mem://changes/REJECT/*/*", snapshot)
+	assert.NotNil(t, err)
+}
+
+func TestChangeFeed_Walk(t *testing.T) {
+	m := NewMemStorage(nil)
+	for _, name := range []string{"a.csv", "b.csv", "c.csv", "e.csv"} {
+		assert.Nil(t, m.PutObject("mem://changes-walk/REJECT/f/"+name, []byte(name)))
+	}
+	feed, err := NewChangeFeed(m, "mem://changes-walk/REJECT/*/*", "")
+	assert.Nil(t, err)
+	assert.Len(t, changesOf(t, feed), 4)
+	assert.Nil(t, feed.Commit())
+
+	assert.Nil(t, m.RemoveObject("mem://changes-walk/REJECT/f/b.csv"))
+	assert.Nil(t, m.PutObject("mem://changes-walk/REJECT/f/d.csv", []byte("d")))
+	assert.Nil(t, m.RemoveObject("mem://changes-walk/REJECT/f/e.csv"))
+	// the removed objects are reported in the order of the listing.
+	var walked []string
+	assert.Nil(t, feed.Walk(context.Background(), func(change *Change) error {
+		walked = append(walked, change.Type.String()+" "+filepath.Base(change.Object.FileName))
+		return nil
+	}))
+	assert.Equal(t, []string{"removed b.csv", "added d.csv", "removed e.csv"}, walked)
+
+	// a walk stopped early is not committed.
+	walked = nil
+	assert.Nil(t, feed.Walk(context.Background(), func(change *Change) error {
+		walked = append(walked, filepath.Base(change.Object.FileName))
+		return ErrStopWalk
+	}))
+	assert.Equal(t, []string{"b.csv"}, walked)
+	assert.Nil(t, feed.Commit())
+	assert.Len(t, changesOf(t, feed), 3)
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// ErrChecksumMismatch is returned, wrapped, when the data transferred does not
+// match the checksum of the object, the transfer should be retried.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum hashes the data written to it with md5 and crc32c, the hashes kept by gcs.
+type checksum struct {
+	md5 hash.Hash
+	crc hash.Hash32
+}
+
+func newChecksum() *checksum {
+	return &checksum{md5: md5.New(), crc: crc32.New(castagnoli)}
+}
+
+func (c *checksum) Write(p []byte) (int, error) {
+	c.md5.Write(p)
+	c.crc.Write(p)
+	return len(p), nil
+}
+
+func (c *checksum) MD5() []byte {
+	return c.md5.Sum(nil)
+}
+
+func (c *checksum) CRC32C() uint32 {
+	return c.crc.Sum32()
+}
+
+// verifyMD5 compares the md5 of the data with want, an empty want is not checked.
+func (c *checksum) verifyMD5(node string, want []byte) error {
+	if got := c.MD5(); len(want) > 0 && !bytes.Equal(got, want) {
+		return fmt.Errorf("%w: %s md5 is %x, want %x", ErrChecksumMismatch, node, got, want)
+	}
+	return nil
+}
+
+func (c *checksum) verifyCRC32C(node string, want uint32) error {
+	if got := c.CRC32C(); got != want {
+		return fmt.Errorf("%w: %s crc32c is %08x, want %08x", ErrChecksumMismatch, node, got, want)
+	}
+	return nil
+}
+
+// fileChecksum return the checksum of the local file path.
+func fileChecksum(path string) (*checksum, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sum := newChecksum()
+	if _, err := io.Copy(sum, file); err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// verifyingReader hashes the stream it reads and replaces the final io.EOF with
+// the error of verify when the data does not match.
+type verifyingReader struct {
+	io.ReadCloser
+	sum    *checksum
+	verify func(sum *checksum) error
+}
+
+func newVerifyingReader(rc io.ReadCloser, verify func(sum *checksum) error) *verifyingReader {
+	return &verifyingReader{ReadCloser: rc, sum: newChecksum(), verify: verify}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.sum.Write(p[:n])
+	if err == io.EOF {
+		if verr := r.verify(r.sum); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// doChecksumTestCases runs the transfers of client against a server corrupting
+// the data whenever corrupt is called with true.
+func doChecksumTestCases(t *testing.T, client Storage, root string, corrupt func(bool)) {
+	tempDir, err := ioutil.TempDir("", "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	node := client.PathJoin(root, "721211", "REJECT", "a.csv")
+	local := filepath.Join(tempDir, "a.csv")
+
+	assert.Nil(t, client.PutObject(node, []byte(mockContent)))
+	assert.Nil(t, client.Download(node, local))
+	assert.Nil(t, client.Upload(local, client.PathJoin(root, "721211", "in", "a.csv")))
+	r, err := client.OpenReader(node)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, mockContent, string(data))
+
+	corrupt(true)
+	defer corrupt(false)
+	err = client.Download(node, local)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "%v", err)
+	_, statErr := os.Stat(local)
+	assert.True(t, os.IsNotExist(statErr))
+
+	r, err = client.OpenReader(node)
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(r)
+	r.Close()
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "%v", err)
+
+	assert.Nil(t, ioutil.WriteFile(local, []byte(mockContent), 0640))
+	err = client.Upload(local, client.PathJoin(root, "721211", "in", "b.csv"))
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "%v", err)
+	w, err := client.OpenWriter(client.PathJoin(root, "721211", "in", "c.csv"))
+	assert.Nil(t, err)
+	w.Write([]byte(mockContent))
+	err = w.Close()
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "%v", err)
+	corrupt(false)
+	assert.False(t, client.IsExist(client.PathJoin(root, "721211", "in", "b.csv")))
+	assert.False(t, client.IsExist(client.PathJoin(root, "721211", "in", "c.csv")))
+}
+
+func TestChecksum(t *testing.T) {
+	t.Run("gcs", func(t *testing.T) {
+		f := newFakeGCS(t)
+		doChecksumTestCases(t, NewGCSStorage(nil), "gs://bucket", func(corrupt bool) {
+			f.Lock()
+			f.corrupt = corrupt
+			f.Unlock()
+		})
+	})
+	t.Run("s3", func(t *testing.T) {
+		f, srv := newFakeS3(t)
+		doChecksumTestCases(t, newTestS3Storage(srv.URL), "s3://bucket", func(corrupt bool) {
+			f.Lock()
+			f.corrupt = corrupt
+			f.Unlock()
+		})
+	})
+}
+
+func TestIsRetryable_Checksum(t *testing.T) {
+	assert.True(t, IsRetryable(gcsChecksumError("gs://bucket/a.csv", errors.New("storage: bad CRC on read: got 1, want 2"))))
+	assert.True(t, errors.Is(&S3Error{StatusCode: 400, Code: "BadDigest"}, ErrChecksumMismatch))
+	assert.False(t, errors.Is(&S3Error{StatusCode: 400, Code: "InvalidArgument"}, ErrChecksumMismatch))
+}
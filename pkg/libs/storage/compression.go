@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Compression is the format of a compressed object, told by its extension.
+type Compression int
+
+const (
+	NoCompression Compression = iota
+	Gzip
+	Zip
+)
+
+// CompressionOf return the compression of node from its extension.
+func CompressionOf(node string) Compression {
+	switch strings.ToLower(path.Ext(node)) {
+	case ".gz":
+		return Gzip
+	case ".zip":
+		return Zip
+	}
+	return NoCompression
+}
+
+// Extension return the extension of the compression, "" for NoCompression.
+func (c Compression) Extension() string {
+	switch c {
+	case Gzip:
+		return ".gz"
+	case Zip:
+		return ".zip"
+	}
+	return ""
+}
+
+// ContentType return the content type of objects of the compression, "" for
+// NoCompression, whose content type is the one of the extension.
+func (c Compression) ContentType() string {
+	switch c {
+	case Gzip:
+		return "application/gzip"
+	case Zip:
+		return "application/zip"
+	}
+	return ""
+}
+
+// TrimExtension return node without the extension of the compression, the name
+// of the file compressed.
+func (c Compression) TrimExtension(node string) string {
+	if ext := c.Extension(); ext != "" && strings.EqualFold(path.Ext(node), ext) {
+		return node[:len(node)-len(ext)]
+	}
+	return node
+}
+
+// NewDecompressingReader return a stream of the content of r decompressed, closing
+// it closes r. A gzip stream which is not compressed, e.g. an object decompressed
+// by gcs on read, is returned as is. A zip archive must hold a single file.
+func NewDecompressingReader(r io.ReadCloser, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case Gzip:
+		buffered := bufio.NewReader(r)
+		if magic, err := buffered.Peek(2); err != nil || !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			return &readCloser{Reader: buffered, closers: []io.Closer{r}}, nil
+		}
+		zr, err := gzip.NewReader(buffered)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: zr, closers: []io.Closer{zr, r}}, nil
+	case Zip:
+		return newZipEntryReader(r)
+	}
+	return r, nil
+}
+
+// readCloser reads from Reader and closes closers in order.
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var first error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// newZipEntryReader spools the archive read by r into a temporary file, as the
+// directory of a zip is at its end, and return a stream of its single file.
+func newZipEntryReader(r io.ReadCloser) (io.ReadCloser, error) {
+	defer r.Close()
+	temp, err := ioutil.TempFile("", "archive-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := closerFunc(func() error {
+		temp.Close()
+		return os.Remove(temp.Name())
+	})
+	size, err := io.Copy(temp, r)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	archive, err := zip.NewReader(temp, size)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	var files []*zip.File
+	for _, f := range archive.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+	if len(files) != 1 {
+		cleanup()
+		return nil, fmt.Errorf("%w: zip archive holds %d files, expected 1", ErrNotSupported, len(files))
+	}
+	entry, err := files[0].Open()
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return &readCloser{Reader: entry, closers: []io.Closer{entry, cleanup}}, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// NewCompressingWriter return a stream compressing into w, closing it flushes
+// the compressed stream and closes w, AbortWriter aborts w. name is the name of
+// the file in a zip archive.
+func NewCompressingWriter(w io.WriteCloser, c Compression, name string) (io.WriteCloser, error) {
+	switch c {
+	case Gzip:
+		zw := gzip.NewWriter(w)
+		zw.Name = path.Base(name)
+		return &compressingWriter{Writer: zw, compressor: zw, w: w}, nil
+	case Zip:
+		zw := zip.NewWriter(w)
+		entry, err := zw.Create(path.Base(name))
+		if err != nil {
+			AbortWriter(w, err)
+			return nil, err
+		}
+		return &compressingWriter{Writer: entry, compressor: zw, w: w}, nil
+	}
+	return w, nil
+}
+
+// compressingWriter writes through a compressor into w.
+type compressingWriter struct {
+	io.Writer
+	compressor io.Closer
+	w          io.WriteCloser
+}
+
+func (c *compressingWriter) Close() error {
+	if err := c.compressor.Close(); err != nil {
+		AbortWriter(c.w, err)
+		return err
+	}
+	return c.w.Close()
+}
+
+func (c *compressingWriter) CloseWithError(err error) error {
+	return AbortWriter(c.w, err)
+}
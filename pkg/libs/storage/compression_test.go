@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressionOf(t *testing.T) {
+	assert.Equal(t, Gzip, CompressionOf("gs://bucket/a.csv.gz"))
+	assert.Equal(t, Zip, CompressionOf("gs://bucket/a.csv.ZIP"))
+	assert.Equal(t, NoCompression, CompressionOf("gs://bucket/a.csv"))
+	assert.Equal(t, "gs://bucket/a.csv", Gzip.TrimExtension("gs://bucket/a.csv.gz"))
+	assert.Equal(t, "gs://bucket/a.csv", Zip.TrimExtension("gs://bucket/a.csv"))
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	client := NewMemStorage(nil)
+	for _, node := range []string{"mem://compression/a.csv", "mem://compression/a.csv.gz", "mem://compression/a.csv.zip"} {
+		c := CompressionOf(node)
+		w, err := client.OpenWriter(node)
+		assert.Nil(t, err)
+		w, err = NewCompressingWriter(w, c, c.TrimExtension(node))
+		assert.Nil(t, err)
+		w.Write([]byte("id,name\n1,a\n"))
+		assert.Nil(t, w.Close())
+
+		data, err := client.GetObject(node)
+		assert.Nil(t, err)
+		assert.Equal(t, c == NoCompression, string(data) == "id,name\n1,a\n", node)
+
+		r, err := client.OpenReader(node)
+		assert.Nil(t, err)
+		r, err = NewDecompressingReader(r, c)
+		assert.Nil(t, err)
+		data, err = ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Nil(t, r.Close())
+		assert.Equal(t, "id,name\n1,a\n", string(data), node)
+	}
+
+	// an aborted writer commits nothing.
+	w, err := client.OpenWriter("mem://compression/b.csv.gz")
+	assert.Nil(t, err)
+	w, err = NewCompressingWriter(w, Gzip, "b.csv")
+	assert.Nil(t, err)
+	w.Write([]byte("id\n"))
+	AbortWriter(w, errors.New("failure"))
+	assert.False(t, client.IsExist("mem://compression/b.csv.gz"))
+}
+
+func TestNewDecompressingReader(t *testing.T) {
+	// a gzip object already decompressed on read.
+	r, err := NewDecompressingReader(ioutil.NopCloser(bytes.NewReader([]byte("id\n"))), Gzip)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "id\n", string(data))
+
+	archive := new(bytes.Buffer)
+	zw := zip.NewWriter(archive)
+	zw.Create("a.csv")
+	zw.Create("b.csv")
+	assert.Nil(t, zw.Close())
+	_, err = NewDecompressingReader(ioutil.NopCloser(archive), Zip)
+	assert.True(t, errors.Is(err, ErrNotSupported), err)
+
+	_, err = NewDecompressingReader(ioutil.NopCloser(bytes.NewReader([]byte("id\n"))), Zip)
+	assert.NotNil(t, err)
+}
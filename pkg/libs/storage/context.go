@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Deadlines applied to every storage call, zero means no deadline.
+// OperationTimeout bounds metadata calls such as get, put, list, copy and delete,
+// TransferTimeout bounds a whole download, upload or stream.
+var (
+	OperationTimeout time.Duration
+	TransferTimeout  time.Duration
+)
+
+// opContext is the context a storage client was bound to via WithContext.
+type opContext struct {
+	ctx context.Context
+}
+
+func (o opContext) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// operation return the context of a single metadata call.
+func (o opContext) operation() (context.Context, context.CancelFunc) {
+	return withTimeout(o.context(), OperationTimeout)
+}
+
+// transfer return the context of a single data transfer.
+func (o opContext) transfer() (context.Context, context.CancelFunc) {
+	return withTimeout(o.context(), TransferTimeout)
+}
+
+// err return the error of the bound context, if it is already done.
+func (o opContext) err() error {
+	return o.context().Err()
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ctxReader stops reading once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// cancelReadCloser releases the context of a stream when it is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
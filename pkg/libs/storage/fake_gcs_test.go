@@ -0,0 +1,436 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGCS is a minimal stand-in of the gcs json api, it covers the calls made by GCSStorage.
+type fakeGCS struct {
+	sync.Mutex
+	objects    map[string]*fakeGCSObject
+	generation int64
+	// archived holds the generations overwritten or deleted, as a versioned bucket.
+	archived []*fakeGCSObject
+	requests int
+	// matchGlob is the matchGlob of the last listing.
+	matchGlob string
+	// corrupt flips the first byte of the data received and served.
+	corrupt bool
+}
+
+func (f *fakeGCS) flip(data []byte) []byte {
+	if f.corrupt && len(data) > 0 {
+		data = append([]byte(nil), data...)
+		data[0] ^= 0xff
+	}
+	return data
+}
+
+type fakeGCSObject struct {
+	bucket      string
+	name        string
+	data        []byte
+	generation  int64
+	created     time.Time
+	updated     time.Time
+	contentType string
+	metadata    map[string]string
+	// composite objects have a crc32c but no md5.
+	composite bool
+	deleted   time.Time
+	// keySHA256 is the hash of the customer supplied key of the object,
+	// kmsKeyName its Cloud KMS key.
+	keySHA256  string
+	kmsKeyName string
+}
+
+// newFakeGCS starts a fake gcs server and points the gcs clients to it.
+func newFakeGCS(t testing.TB) *fakeGCS {
+	f := &fakeGCS{objects: map[string]*fakeGCSObject{}}
+	srv := httptest.NewServer(f)
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+	t.Cleanup(func() {
+		CloseClients()
+		srv.Close()
+	})
+	return f
+}
+
+func (f *fakeGCS) put(bucket, name string, data []byte) *fakeGCSObject {
+	f.generation++
+	now := time.Now().UTC()
+	f.archive(bucket + "/" + name)
+	obj := &fakeGCSObject{bucket: bucket, name: name, data: data, generation: f.generation, created: now, updated: now}
+	f.objects[bucket+"/"+name] = obj
+	return obj
+}
+
+// archive keeps the live generation of name, if any, as a noncurrent version.
+func (f *fakeGCS) archive(name string) {
+	if obj, ok := f.objects[name]; ok {
+		archived := *obj
+		archived.deleted = time.Now().UTC()
+		f.archived = append(f.archived, &archived)
+	}
+}
+
+// version return the generation of name, live or archived.
+func (f *fakeGCS) version(name, generation string) (*fakeGCSObject, bool) {
+	if obj, ok := f.objects[name]; ok && (generation == "" || generation == fmt.Sprint(obj.generation)) {
+		return obj, true
+	}
+	for _, obj := range f.archived {
+		if obj.bucket+"/"+obj.name == name && generation == fmt.Sprint(obj.generation) {
+			return obj, true
+		}
+	}
+	return nil, false
+}
+
+func (o *fakeGCSObject) resource() map[string]interface{} {
+	md5Sum := md5.Sum(o.data)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(o.data, crc32.MakeTable(crc32.Castagnoli)))
+	res := map[string]interface{}{
+		"kind":        "storage#object",
+		"bucket":      o.bucket,
+		"name":        o.name,
+		"size":        fmt.Sprint(len(o.data)),
+		"md5Hash":     base64.StdEncoding.EncodeToString(md5Sum[:]),
+		"crc32c":      base64.StdEncoding.EncodeToString(crc),
+		"generation":  fmt.Sprint(o.generation),
+		"timeCreated": o.created.Format(time.RFC3339Nano),
+		"updated":     o.updated.Format(time.RFC3339Nano),
+		"contentType": o.contentType,
+		"metadata":    o.metadata,
+	}
+	if o.composite {
+		delete(res, "md5Hash")
+	}
+	if !o.deleted.IsZero() {
+		res["timeDeleted"] = o.deleted.Format(time.RFC3339Nano)
+	}
+	if o.keySHA256 != "" {
+		res["customerEncryption"] = map[string]string{"encryptionAlgorithm": "AES256", "keySha256": o.keySHA256}
+	}
+	if o.kmsKeyName != "" {
+		res["kmsKeyName"] = o.kmsKeyName
+	}
+	return res
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests++
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for i := range segments {
+		segments[i], _ = url.PathUnescape(segments[i])
+	}
+	switch {
+	case len(segments) == 5 && segments[0] == "storage" && segments[4] == "o" && r.Method == http.MethodGet:
+		f.list(w, segments[3], r.URL.Query())
+	case len(segments) == 11 && segments[6] == "rewriteTo":
+		src, ok := f.version(segments[3]+"/"+segments[5], r.URL.Query().Get("sourceGeneration"))
+		if !ok {
+			f.error(w, http.StatusNotFound)
+			return
+		}
+		if !f.decrypts(w, r.Header.Get("X-Goog-Copy-Source-Encryption-Key-Sha256"), src) {
+			return
+		}
+		if !f.generationMatch(w, r, segments[8]+"/"+segments[10]) {
+			return
+		}
+		obj := f.put(segments[8], segments[10], append([]byte(nil), src.data...))
+		obj.contentType, obj.metadata = src.contentType, src.metadata
+		obj.keySHA256, obj.kmsKeyName = r.Header.Get("X-Goog-Encryption-Key-Sha256"), r.URL.Query().Get("destinationKmsKeyName")
+		f.json(w, map[string]interface{}{
+			"kind":                "storage#rewriteResponse",
+			"done":                true,
+			"totalBytesRewritten": fmt.Sprint(len(obj.data)),
+			"objectSize":          fmt.Sprint(len(obj.data)),
+			"resource":            obj.resource(),
+		})
+	case len(segments) == 7 && segments[6] == "compose" && r.Method == http.MethodPost:
+		f.compose(w, r, segments[3], segments[5])
+	case len(segments) == 6 && segments[0] == "storage" && segments[4] == "o":
+		name := segments[3] + "/" + segments[5]
+		obj, ok := f.objects[name]
+		if !ok {
+			f.error(w, http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if !f.generationMatch(w, r, name) {
+				return
+			}
+			f.archive(name)
+			delete(f.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		f.json(w, obj.resource())
+	case len(segments) == 6 && segments[0] == "upload" && r.Method == http.MethodPost:
+		f.upload(w, r, segments[4])
+	case len(segments) >= 2 && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		obj, ok := f.objects[segments[0]+"/"+strings.Join(segments[1:], "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !f.decrypts(w, r.Header.Get("X-Goog-Encryption-Key-Sha256"), obj) {
+			return
+		}
+		res := obj.resource()
+		w.Header().Set("X-Goog-Generation", fmt.Sprint(obj.generation))
+		if !f.corrupt {
+			// the gcs client checks the crc32c itself, corrupted data is left to GCSStorage.
+			w.Header().Set("X-Goog-Hash", fmt.Sprintf("crc32c=%s,md5=%s", res["crc32c"], res["md5Hash"]))
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			if end >= len(obj.data) {
+				end = len(obj.data) - 1
+			}
+			w.Header().Del("X-Goog-Hash")
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.data)))
+			w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(f.flip(obj.data[start : end+1]))
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+		w.Write(f.flip(obj.data))
+	default:
+		f.error(w, http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeGCS) list(w http.ResponseWriter, bucket string, query url.Values) {
+	prefix, delim, token := query.Get("prefix"), query.Get("delimiter"), query.Get("pageToken")
+	if query.Get("versions") == "true" {
+		f.listVersions(w, bucket, prefix)
+		return
+	}
+	maxResults, err := strconv.Atoi(query.Get("maxResults"))
+	if err != nil || maxResults <= 0 {
+		maxResults = 1000
+	}
+	f.matchGlob = query.Get("matchGlob")
+	match := fakeMatchGlob(f.matchGlob)
+	names := make([]string, 0)
+	for _, obj := range f.objects {
+		if obj.bucket == bucket && strings.HasPrefix(obj.name, prefix) && obj.name > token && match(obj.name) {
+			names = append(names, obj.name)
+		}
+	}
+	sort.Strings(names)
+
+	items := make([]interface{}, 0)
+	prefixes := make([]string, 0)
+	seen := map[string]bool{}
+	var next string
+	for _, name := range names {
+		if len(items)+len(prefixes) == maxResults {
+			next = token
+			break
+		}
+		if i := strings.Index(name[len(prefix):], delim); delim != "" && i >= 0 {
+			if p := name[:len(prefix)+i+1]; !seen[p] {
+				seen[p] = true
+				prefixes = append(prefixes, p)
+			}
+			token = name
+			continue
+		}
+		items = append(items, f.objects[bucket+"/"+name].resource())
+		token = name
+	}
+	f.json(w, map[string]interface{}{"kind": "storage#objects", "items": items, "prefixes": prefixes, "nextPageToken": next})
+}
+
+// fakeMatchGlob return the matcher of a match glob of gcs: ** matches any
+// characters, * any but / and ? one. An empty glob matches every name.
+func fakeMatchGlob(glob string) func(name string) bool {
+	if glob == "" {
+		return func(string) bool { return true }
+	}
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.ReplaceAll(expr, `\*\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\*`, "[^/]*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.MustCompile("^" + expr + "$").MatchString
+}
+
+// listVersions lists every generation of the objects under prefix in one page.
+func (f *fakeGCS) listVersions(w http.ResponseWriter, bucket, prefix string) {
+	objs := append([]*fakeGCSObject(nil), f.archived...)
+	for _, obj := range f.objects {
+		objs = append(objs, obj)
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		if objs[i].name != objs[j].name {
+			return objs[i].name < objs[j].name
+		}
+		return objs[i].generation < objs[j].generation
+	})
+	items := make([]interface{}, 0)
+	for _, obj := range objs {
+		if obj.bucket == bucket && strings.HasPrefix(obj.name, prefix) {
+			items = append(items, obj.resource())
+		}
+	}
+	f.json(w, map[string]interface{}{"kind": "storage#objects", "items": items})
+}
+
+func (f *fakeGCS) upload(w http.ResponseWriter, r *http.Request, bucket string) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		f.error(w, http.StatusBadRequest)
+		return
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	part, err := reader.NextPart()
+	if err != nil {
+		f.error(w, http.StatusBadRequest)
+		return
+	}
+	meta := struct {
+		Name        string            `json:"name"`
+		MD5         string            `json:"md5Hash"`
+		CRC32C      string            `json:"crc32c"`
+		ContentType string            `json:"contentType"`
+		Metadata    map[string]string `json:"metadata"`
+	}{}
+	if err := json.NewDecoder(part).Decode(&meta); err != nil {
+		f.error(w, http.StatusBadRequest)
+		return
+	}
+	part, err = reader.NextPart()
+	if err != nil {
+		f.error(w, http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		f.error(w, http.StatusBadRequest)
+		return
+	}
+	if !f.generationMatch(w, r, bucket+"/"+meta.Name) {
+		return
+	}
+	obj := &fakeGCSObject{data: f.flip(data)}
+	res := obj.resource()
+	if meta.MD5 != "" && meta.MD5 != res["md5Hash"] {
+		f.errorMessage(w, http.StatusBadRequest, "Provided MD5 hash doesn't match calculated MD5 hash.")
+		return
+	}
+	if meta.CRC32C != "" && meta.CRC32C != res["crc32c"] {
+		f.errorMessage(w, http.StatusBadRequest, "Provided CRC32C doesn't match calculated CRC32C.")
+		return
+	}
+	obj = f.put(bucket, meta.Name, obj.data)
+	obj.contentType, obj.metadata = meta.ContentType, meta.Metadata
+	obj.keySHA256, obj.kmsKeyName = r.Header.Get("X-Goog-Encryption-Key-Sha256"), r.URL.Query().Get("kmsKeyName")
+	f.json(w, obj.resource())
+}
+
+// generationMatch rejects the request if the object name is not at the
+// ifGenerationMatch of r, 0 meaning the object must not exist.
+func (f *fakeGCS) generationMatch(w http.ResponseWriter, r *http.Request, name string) bool {
+	want := r.URL.Query().Get("ifGenerationMatch")
+	if want == "" {
+		return true
+	}
+	var generation int64
+	if obj, ok := f.objects[name]; ok {
+		generation = obj.generation
+	}
+	if want != fmt.Sprint(generation) {
+		f.error(w, http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// decrypts rejects the request if keySHA256 is not the hash of the key the
+// object is encrypted with, empty for an object not encrypted by the customer.
+func (f *fakeGCS) decrypts(w http.ResponseWriter, keySHA256 string, obj *fakeGCSObject) bool {
+	if keySHA256 != obj.keySHA256 {
+		f.errorMessage(w, http.StatusBadRequest, "The customer-supplied encryption key does not match the object.")
+		return false
+	}
+	return true
+}
+
+func (f *fakeGCS) compose(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	req := struct {
+		Destination struct {
+			ContentType string            `json:"contentType"`
+			Metadata    map[string]string `json:"metadata"`
+			KMSKeyName  string            `json:"kmsKeyName"`
+		} `json:"destination"`
+		SourceObjects []struct {
+			Name string `json:"name"`
+		} `json:"sourceObjects"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.SourceObjects) > 32 {
+		f.error(w, http.StatusBadRequest)
+		return
+	}
+	var data []byte
+	for _, src := range req.SourceObjects {
+		obj, ok := f.objects[bucket+"/"+src.Name]
+		if !ok {
+			f.error(w, http.StatusNotFound)
+			return
+		}
+		if !f.decrypts(w, r.Header.Get("X-Goog-Encryption-Key-Sha256"), obj) {
+			return
+		}
+		data = append(data, obj.data...)
+	}
+	if !f.generationMatch(w, r, bucket+"/"+name) {
+		return
+	}
+	obj := f.put(bucket, name, data)
+	obj.contentType, obj.metadata, obj.composite = req.Destination.ContentType, req.Destination.Metadata, true
+	obj.keySHA256, obj.kmsKeyName = r.Header.Get("X-Goog-Encryption-Key-Sha256"), req.Destination.KMSKeyName
+	f.json(w, obj.resource())
+}
+
+func (f *fakeGCS) json(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeGCS) error(w http.ResponseWriter, code int) {
+	f.errorMessage(w, code, http.StatusText(code))
+}
+
+func (f *fakeGCS) errorMessage(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	io.WriteString(w, fmt.Sprintf(`{"error":{"code":%d,"message":"%s"}}`, code, message))
+}
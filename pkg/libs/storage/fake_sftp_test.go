@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeSFTP is an in-process ssh server serving the sftp subsystem of
+// github.com/pkg/sftp on a file system in memory.
+type fakeSFTP struct {
+	handlers sftp.Handlers
+	addr     string
+	// hostKey is the public key of the server in the authorized_keys format.
+	hostKey string
+	// userKey is the PEM private key accepted for the user, as is password.
+	userKey  string
+	password string
+	// dropReads counts the next opens for reading dropping the connections
+	// of the clients, as a server restarting in the middle of a call would.
+	dropReads atomic.Int32
+	mu        sync.Mutex
+	conns     []net.Conn
+}
+
+// droppingReader opens the files for reading, or drops the connections while
+// fakeSFTP.dropReads is positive. The file is not opened then, an aborted
+// transfer would fail the next reads of the file in memory.
+type droppingReader struct {
+	sftp.FileReader
+	f *fakeSFTP
+}
+
+func (r *droppingReader) Fileread(req *sftp.Request) (io.ReaderAt, error) {
+	if r.f.dropReads.Add(-1) >= 0 {
+		r.f.dropConns()
+		return nil, io.ErrUnexpectedEOF
+	}
+	return r.FileReader.Fileread(req)
+}
+
+func newFakeSFTP(t *testing.T) *fakeSFTP {
+	f := &fakeSFTP{handlers: sftp.InMemHandler(), password: "secret"}
+	f.handlers.FileGet = &droppingReader{FileReader: f.handlers.FileGet, f: f}
+	hostSigner := newTestSSHSigner(t, nil)
+	f.hostKey = string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey()))
+	var userPEM []byte
+	userSigner := newTestSSHSigner(t, &userPEM)
+	f.userKey = string(userPEM)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "copilot" && string(password) == f.password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "copilot" && string(key.Marshal()) == string(userSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("key rejected for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.addr = listener.Addr().String()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serveConn(conn, config)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		f.dropConns()
+	})
+	return f
+}
+
+// newTestSSHSigner return a new ecdsa key, its PEM encoding in pemKey if not nil.
+func newTestSSHSigner(t *testing.T, pemKey *[]byte) ssh.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pemKey != nil {
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		*pemKey = pem.EncodeToMemory(block)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// dropConns closes the connections of the clients, as a server restarting would.
+func (f *fakeSFTP) dropConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeSFTP) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go func() {
+						server := sftp.NewRequestServer(channel, f.handlers)
+						server.Serve()
+						server.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// files return the names of the files on the server, through a session of its own.
+func (f *fakeSFTP) files() []string {
+	clientConn, serverConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, f.handlers)
+	go server.Serve()
+	defer server.Close()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		return nil
+	}
+	defer client.Close()
+	names := []string{}
+	for walker := client.Walk(slash); walker.Step(); {
+		if walker.Err() == nil && !walker.Stat().IsDir() {
+			names = append(names, strings.TrimPrefix(walker.Path(), slash))
+		}
+	}
+	return names
+}
@@ -1,29 +1,54 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 )
 
 // FileStorage is local storage
 type FileStorage struct {
+	opContext
 	protocol string
 }
 
+func init() {
+	Register(Backend{
+		Type: StorageInLocal,
+		Name: "local",
+		New:  func(opts map[string]interface{}) Storage { return NewFileStorage(opts) },
+	})
+}
+
 // NewFileStorage return a new file storage client
 func NewFileStorage(opts map[string]interface{}) *FileStorage {
 	return new(FileStorage)
 }
 
+// WithContext return a copy of the client whose calls are bound to ctx
+func (f *FileStorage) WithContext(ctx context.Context) Storage {
+	c := *f
+	c.ctx = ctx
+	return &c
+}
+
 func (f *FileStorage) PathJoin(items ...string) string {
 	return filepath.Join(items...)
 }
 
 // GetObject return a file by node.
 func (f *FileStorage) GetObject(node string) ([]byte, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
 	if _, err := os.Stat(node); err != nil {
 		return nil, ErrCodeNoSuchKey
 	}
@@ -39,17 +64,34 @@ func (f *FileStorage) GetObject(node string) ([]byte, error) {
 	return bytes, err
 }
 
-// PutObject save a file via node.
-func (f *FileStorage) PutObject(node string, data []byte) error {
+// PutObject save a file via node, the options are kept in a sidecar file.
+func (f *FileStorage) PutObject(node string, data []byte, opts ...WriteOption) error {
+	if err := f.err(); err != nil {
+		return err
+	}
 	if err := mkDirs(node); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(node, data, 0750)
+	o := newWriteOptions(opts)
+	return f.conditional(node, o, func() error {
+		if err := ioutil.WriteFile(node, data, 0750); err != nil {
+			return err
+		}
+		return writeFileMeta(node, o)
+	})
 }
 
-// RemoveObject remove a file via node.
-func (f *FileStorage) RemoveObject(node string) error {
-	return os.Remove(node)
+// RemoveObject remove a file via node, if it satisfies the preconditions of opts.
+func (f *FileStorage) RemoveObject(node string, opts ...WriteOption) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.conditional(node, newWriteOptions(opts), func() error {
+		if err := os.Remove(node); err != nil {
+			return err
+		}
+		return removeFileMeta(node)
+	})
 }
 
 // RemoveDir remove a folder.
@@ -59,36 +101,110 @@ func (f *FileStorage) RemoveDir(path string) error {
 
 // RemoveAll remove a folder via path
 func (f *FileStorage) RemoveAll(path string) error {
+	if err := f.err(); err != nil {
+		return err
+	}
 	return os.RemoveAll(path)
 }
 
-// CopyObject backup this node file
-func (f *FileStorage) CopyObject(from, to string) error {
-	var cmd string
-	if isDir(from) {
-		to = to + "/"
-		cmd = "cp -rf %s/* %s"
+// CopyPrefix copy every file under the folder from into the folder to
+func (f *FileStorage) CopyPrefix(from, to string) error {
+	return f.prefixOp("copy", from, to, func(src, dst string) error {
+		if err := backupFile(f.context(), dst); err != nil {
+			return err
+		}
+		if err := copyFile(f.context(), src, dst); err != nil {
+			return err
+		}
+		return copyFileMeta(src, dst)
+	})
+}
+
+// MovePrefix move every file under the folder from into the folder to,
+// the folders left empty under from are removed.
+func (f *FileStorage) MovePrefix(from, to string) error {
+	err := f.prefixOp("move", from, to, func(src, dst string) error {
+		if err := backupFile(f.context(), dst); err != nil {
+			return err
+		}
+		if err := moveFile(f.context(), src, dst); err != nil {
+			return err
+		}
+		return moveFileMeta(src, dst)
+	})
+	removeEmptyDirs(from)
+	return err
+}
+
+func (f *FileStorage) prefixOp(name, from, to string, op func(src, dst string) error) error {
+	objs, _, err := f.ListObjects(from)
+	if err != nil {
+		return err
+	}
+	return forEachObject(f.context(), name, from, objs, func(obj *Object) error {
+		rel, err := filepath.Rel(from, obj.FileName)
+		if err != nil {
+			return err
+		}
+		return op(obj.FileName, filepath.Join(to, rel))
+	})
+}
+
+// CopyObject backup this node file, a folder is copied with its content into the
+// folder to and a file copied into an existing folder keeps its name. The copy
+// of a file must satisfy the preconditions of opts, a folder takes none, and it
+// is reported to WithProgress.
+func (f *FileStorage) CopyObject(from, to string, opts ...WriteOption) error {
+	info, err := os.Stat(from)
+	if err != nil {
+		return newFileError("copy", from, to, err)
+	}
+	o := newWriteOptions(opts)
+	ctx, cancel := f.transfer()
+	defer cancel()
+	if info.IsDir() && o.hasPrecondition() {
+		err = fmt.Errorf("%w: folder copy with a precondition", ErrNotSupported)
+	} else if info.IsDir() {
+		err = copyDir(ctx, from, to)
 	} else {
-		cmd = "cp -f %s %s"
+		target := fileTarget(from, to)
+		err = f.conditional(target, o, func() error {
+			if err := copyFileReporting(ctx, from, target, o.progress); err != nil {
+				return err
+			}
+			return copyFileMeta(from, target)
+		})
 	}
-	if err := mkDirs(to); err != nil {
-		return err
+	if err != nil {
+		return &FileError{Op: "copy", From: from, To: to, Err: err}
 	}
-	_, err := runCMD(fmt.Sprintf(cmd, from, to))
-	return err
+	return nil
 }
 
-// MoveObject rename this object
+// MoveObject rename this object, the content of a folder is moved into the folder
+// to and a file moved into an existing folder keeps its name.
 func (f *FileStorage) MoveObject(from, to string) error {
-	if isDir(from) {
-		from += "/*"
-		to += "/"
+	info, err := os.Stat(from)
+	if err != nil {
+		return newFileError("move", from, to, err)
 	}
-	if err := mkDirs(to); err != nil {
-		return err
+	ctx, cancel := f.transfer()
+	defer cancel()
+	if info.IsDir() {
+		err = moveDir(ctx, from, to)
+	} else {
+		target := fileTarget(from, to)
+		err = f.replacing(target, func() error {
+			if err := moveFile(ctx, from, target); err != nil {
+				return err
+			}
+			return moveFileMeta(from, target)
+		})
 	}
-	_, err := runCMD(fmt.Sprintf("mv -f %s %s", from, to))
-	return err
+	if err != nil {
+		return &FileError{Op: "move", From: from, To: to, Err: err}
+	}
+	return nil
 }
 
 // IsExist return false if node doesn't exist
@@ -97,6 +213,46 @@ func (f *FileStorage) IsExist(node string) bool {
 	return err == nil || os.IsExist(err)
 }
 
+// Stat return the attributes of the file and the options of its last write.
+// There is no generation of a file, its modification time in nanoseconds stands for it.
+// The md5 of the file is computed again only once its size or modification time change.
+func (f *FileStorage) Stat(node string) (*Object, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(node)
+	if os.IsNotExist(err) || err == nil && info.IsDir() {
+		return nil, ErrCodeNoSuchKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum, err := fileSums.sum(node, info)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := readFileMeta(node)
+	if err != nil {
+		return nil, err
+	}
+	o := &writeOptions{contentType: meta.ContentType}
+	metadata := meta.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return &Object{
+		FileName:    node,
+		Size:        info.Size(),
+		ModTime:     info.ModTime().Unix(),
+		Sum:         sum,
+		Created:     info.ModTime(),
+		Updated:     info.ModTime(),
+		ContentType: o.contentTypeOf(node),
+		Generation:  info.ModTime().UnixNano(),
+		Metadata:    metadata,
+	}, nil
+}
+
 // ListObjects return all files via prefix dir
 func (f *FileStorage) ListObjects(dir string) ([]*Object, int64, error) {
 	return f.listByPrefix(dir, "")
@@ -109,12 +265,15 @@ func (f *FileStorage) ListChildObjects(dir string) ([]*Object, int64, error) {
 // ListDirs return all dirs via prefix dir
 func (f *FileStorage) ListDirs(dir string) ([]string, error) {
 	var files []string
+	if err := f.err(); err != nil {
+		return files, err
+	}
 	dirs, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return files, err
 	}
 	for _, fi := range dirs {
-		if fi.IsDir() == true {
+		if fi.IsDir() == true && fi.Name() != fileMetaDir {
 			files = append(files, filepath.Join(dir, fi.Name()))
 		}
 	}
@@ -122,15 +281,18 @@ func (f *FileStorage) ListDirs(dir string) ([]string, error) {
 }
 
 func (f *FileStorage) listByPrefix(prefix, delim string) ([]*Object, int64, error) {
-	objs := make([]*Object, 0, 10000)
+	objs := make([]*Object, 0)
 	var size int64
+	if err := f.err(); err != nil {
+		return nil, 0, err
+	}
 	if delim == "/" {
 		dirs, err := ioutil.ReadDir(prefix)
 		if err != nil {
 			return nil, 0, err
 		}
 		for _, f := range dirs {
-			if f.IsDir() == false {
+			if f.IsDir() == false && !isTempName(f.Name()) {
 				objs = append(objs, &Object{
 					FileName: filepath.Join(prefix, f.Name()),
 					Size:     f.Size(),
@@ -140,11 +302,18 @@ func (f *FileStorage) listByPrefix(prefix, delim string) ([]*Object, int64, erro
 			}
 		}
 	} else {
+		ctx := f.context()
 		err := filepath.Walk(prefix, func(path string, f os.FileInfo, err error) error {
 			if f == nil {
 				return err
 			}
-			if f.IsDir() == false {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if f.IsDir() && f.Name() == fileMetaDir {
+				return filepath.SkipDir
+			}
+			if f.IsDir() == false && !isTempName(f.Name()) {
 				objs = append(objs, &Object{
 					FileName: path,
 					Size:     f.Size(),
@@ -161,33 +330,397 @@ func (f *FileStorage) listByPrefix(prefix, delim string) ([]*Object, int64, erro
 	return objs, size, nil
 }
 
-// Download download file to local
-func (f *FileStorage) Download(from, to string) error {
-	return f.CopyObject(from, to)
+// ListPage return a page of the files under the folder dir, the page token is
+// the slash separated path below dir of the last file of the previous page.
+func (f *FileStorage) ListPage(dir, pageToken string, pageSize int) ([]*Object, string, error) {
+	objs := make([]*Object, 0, pageSize)
+	var next, last string
+	err := f.walkSorted(dir, "", pageToken, func(key string, fi os.FileInfo) error {
+		if len(objs) == pageSize {
+			next = last
+			return ErrStopWalk
+		}
+		objs = append(objs, &Object{
+			FileName: filepath.Join(dir, filepath.FromSlash(key)),
+			Size:     fi.Size(),
+			ModTime:  fi.ModTime().Unix(),
+		})
+		last = key
+		return nil
+	})
+	if err != nil && err != ErrStopWalk {
+		return nil, "", err
+	}
+	return objs, next, nil
 }
 
-// Upload put file to remote
-func (f *FileStorage) Upload(from, to string) error {
-	return f.CopyObject(from, to)
+// Walk calls fn for every file under the folder dir
+func (f *FileStorage) Walk(dir string, fn WalkFunc) error {
+	return walk(f.context(), f, dir, fn)
 }
 
-func runCMD(shell string) (string, error) {
-	cmd := exec.Command("sh", "-c", shell)
-	result, err := cmd.Output()
+// walkSorted calls fn for the files below root/rel whose key sorts after the key
+// after, in lexical order of the keys, the slash separated paths below root.
+// Folders are ordered as their key with a trailing slash, as a bucket would
+// order their objects, and folders sorting entirely before after are skipped.
+func (f *FileStorage) walkSorted(root, rel, after string, fn func(key string, fi os.FileInfo) error) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(root, filepath.FromSlash(rel)))
 	if err != nil {
-		return "", fmt.Errorf("run cmd %s: %v", shell, err)
+		return err
+	}
+	keys := make(map[os.FileInfo]string, len(infos))
+	for _, fi := range infos {
+		keys[fi] = path.Join(rel, fi.Name())
+		if fi.IsDir() {
+			keys[fi] += slash
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return keys[infos[i]] < keys[infos[j]] })
+	for _, fi := range infos {
+		key := keys[fi]
+		if !fi.IsDir() {
+			if key > after && !isTempName(fi.Name()) {
+				if err := fn(key, fi); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if fi.Name() == fileMetaDir || key < after && !strings.HasPrefix(after, key) {
+			continue
+		}
+		if err := f.walkSorted(root, strings.TrimSuffix(key, slash), after, fn); err != nil {
+			return err
+		}
 	}
-	return string(result), nil
+	return nil
 }
 
-func mkDirs(node string) error {
-	dir := filepath.Dir(node)
-	if !isExist(dir) {
-		os.MkdirAll(dir, 0750)
+// isTempName reports a file staged by a writer next to its target, named
+// .<name>.tmp-<suffix>, which is left out of listings until it is renamed.
+func isTempName(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp-")
+}
+
+// Glob calls fn for the objects matching pattern, under the folder of the pattern.
+func (f *FileStorage) Glob(pattern string, fn WalkFunc) error {
+	return globWalk(f, pattern, fn)
+}
+
+// Download download file to local, only the content is copied: the local copy
+// takes no sidecar nor backup, and it is reported to WithProgress.
+func (f *FileStorage) Download(from, to string, opts ...WriteOption) error {
+	info, err := os.Stat(from)
+	if err != nil {
+		return newFileError("download", from, to, err)
+	}
+	ctx, cancel := f.transfer()
+	defer cancel()
+	if info.IsDir() {
+		err = copyDir(ctx, from, to)
+	} else {
+		err = copyFileReporting(ctx, from, fileTarget(from, to), newWriteOptions(opts).progress)
+	}
+	if err != nil {
+		return &FileError{Op: "download", From: from, To: to, Err: err}
 	}
 	return nil
 }
 
+// Upload put file to remote, the options are kept in a sidecar file.
+func (f *FileStorage) Upload(from, to string, opts ...WriteOption) error {
+	target := fileTarget(from, to)
+	o := newWriteOptions(opts)
+	return f.conditional(target, o, func() error {
+		if err := f.CopyObject(from, to, WithProgress(o.progress)); err != nil {
+			return err
+		}
+		return writeFileMeta(target, o)
+	})
+}
+
+// OpenReader return a stream of the file
+func (f *FileStorage) OpenReader(node string) (io.ReadCloser, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(node)
+	if os.IsNotExist(err) {
+		return nil, ErrCodeNoSuchKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := f.transfer()
+	return &cancelReadCloser{
+		ReadCloser: struct {
+			io.Reader
+			io.Closer
+		}{&ctxReader{ctx: ctx, r: file}, file},
+		cancel: cancel,
+	}, nil
+}
+
+// OpenWriter return a stream writing into a temp file, which is renamed to node on Close
+func (f *FileStorage) OpenWriter(node string, opts ...WriteOption) (io.WriteCloser, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	if err := mkDirs(node); err != nil {
+		return nil, err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(node), "."+filepath.Base(node)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := f.transfer()
+	return &fileWriter{File: temp, storage: f, node: node, options: newWriteOptions(opts), ctx: ctx, cancel: cancel}, nil
+}
+
+// fileWriter makes the written file visible only once it is complete.
+type fileWriter struct {
+	*os.File
+	storage *FileStorage
+	node    string
+	options *writeOptions
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.File.Write(p)
+}
+
+func (w *fileWriter) Close() error {
+	defer w.cancel()
+	if err := w.ctx.Err(); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	if err := os.Chmod(w.Name(), 0750); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	err := w.storage.conditional(w.node, w.options, func() error {
+		if err := os.Rename(w.Name(), w.node); err != nil {
+			return err
+		}
+		return writeFileMeta(w.node, w.options)
+	})
+	if err != nil {
+		os.Remove(w.Name())
+	}
+	return err
+}
+
+func (w *fileWriter) CloseWithError(err error) error {
+	defer w.cancel()
+	w.File.Close()
+	return os.Remove(w.Name())
+}
+
+// FileError reports a failed file operation of FileStorage, it unwraps to the
+// cause, ErrCodeNoSuchKey when from does not exist.
+type FileError struct {
+	Op   string
+	From string
+	To   string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s %s to %s: %v", e.Op, e.From, e.To, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// newFileError wraps the error of the stat of from.
+func newFileError(op, from, to string, err error) error {
+	if os.IsNotExist(err) {
+		err = ErrCodeNoSuchKey
+	}
+	return &FileError{Op: op, From: from, To: to, Err: err}
+}
+
+// fileTarget return the path of the file from copied or moved to to, inside to
+// if it is an existing folder.
+func fileTarget(from, to string) string {
+	if isDir(to) {
+		return filepath.Join(to, filepath.Base(from))
+	}
+	return to
+}
+
+// copyFile copies the file src to dst, see copyFileReporting.
+func copyFile(ctx context.Context, src, dst string) error {
+	return copyFileReporting(ctx, src, dst, nil)
+}
+
+// copyFileReporting copies the content and the permissions of the file src to dst,
+// reporting to fn if not nil. The content is written into a temporary file renamed
+// to dst once complete, so dst is never seen half written.
+func copyFileReporting(ctx context.Context, src, dst string, fn ProgressFunc) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	reader, err := newCustomReader(in, fn)
+	if err != nil {
+		return err
+	}
+	if err := mkDirs(dst); err != nil {
+		return err
+	}
+	out, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, &ctxReader{ctx: ctx, r: reader}); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err := os.Chmod(out.Name(), info.Mode().Perm()); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err := os.Rename(out.Name(), dst); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	reader.done()
+	return nil
+}
+
+// copyDir copies the files and folders under src into dst, as cp -rf src/* dst/
+// but hidden files included.
+func copyDir(ctx context.Context, src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, 0750); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(ctx, path, target)
+		}
+		return fmt.Errorf("%s: unsupported file type %v", path, info.Mode().Type())
+	})
+}
+
+// moveFile renames the file src to dst, it falls back to a copy when they are on
+// different devices.
+func moveFile(ctx context.Context, src, dst string) error {
+	if err := mkDirs(dst); err != nil {
+		return err
+	}
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFile(ctx, src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// moveDir moves the files and folders under src into dst, as mv -f src/* dst/
+// but hidden files included, the folders of both sides are merged. src is left empty.
+func moveDir(ctx context.Context, src, dst string) error {
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0750); err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		from, to := filepath.Join(src, info.Name()), filepath.Join(dst, info.Name())
+		if !info.IsDir() {
+			if err := moveFile(ctx, from, to); err != nil {
+				return err
+			}
+			continue
+		}
+		if !isExist(to) {
+			if err := os.Rename(from, to); err == nil {
+				continue
+			} else if !errors.Is(err, syscall.EXDEV) {
+				return err
+			}
+		}
+		if err := moveDir(ctx, from, to); err != nil {
+			return err
+		}
+		if err := os.Remove(from); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEmptyDirs removes the folders under dir, dir included, which hold no file.
+func removeEmptyDirs(dir string) {
+	var dirs []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	// deepest folders first, os.Remove fails on the ones still holding files.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
+
+func mkDirs(node string) error {
+	return os.MkdirAll(filepath.Dir(node), 0750)
+}
+
 func isDir(path string) bool {
 	s, err := os.Stat(path)
 	if err != nil {
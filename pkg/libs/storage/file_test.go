@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -9,11 +10,7 @@ import (
 )
 
 func Test_CopyObject(t *testing.T) {
-	tempDir, err := ioutil.TempDir("./", "fileStorage")
-	if err != nil {
-		t.Fail()
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	local := NewFileStorage(nil)
 	local.PutObject(local.PathJoin(tempDir, "f0"), []byte("a1"))
@@ -64,11 +61,7 @@ func Test_CopyObject(t *testing.T) {
 }
 
 func Test_CopyObject2(t *testing.T) {
-	tempDir, err := ioutil.TempDir("./", "fileStorage")
-	if err != nil {
-		t.Fail()
-	}
-	//defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	local := NewFileStorage(nil)
 	//local.PutObject(local.PathJoin(tempDir, "d01", "f0"), []byte("a1"))
@@ -82,3 +75,114 @@ func Test_CopyObject2(t *testing.T) {
 	assert.True(t, string(bs) == "a1")
 
 }
+
+func Test_OpenWriter(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	local := NewFileStorage(nil)
+	node := local.PathJoin(tempDir, "in", "a.csv")
+	w, err := local.OpenWriter(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("a,b\n"))
+	assert.False(t, local.IsExist(node))
+	// the file staged by the writer is listed by no listing.
+	objs, _, _ := local.ListObjects(local.PathJoin(tempDir, "in"))
+	assert.Empty(t, objs)
+	objs, _, _ = local.ListChildObjects(local.PathJoin(tempDir, "in"))
+	assert.Empty(t, objs)
+	page, _, _ := local.ListPage(tempDir, "", 10)
+	assert.Empty(t, page)
+	assert.Nil(t, w.Close())
+
+	r, err := local.OpenReader(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "a,b\n", string(bs))
+
+	w, err = local.OpenWriter(local.PathJoin(tempDir, "in", "b.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("partial"))
+	assert.Nil(t, AbortWriter(w, nil))
+	objs, _, _ = local.ListObjects(local.PathJoin(tempDir, "in"))
+	assert.Equal(t, 1, len(objs))
+
+	_, err = local.OpenReader(local.PathJoin(tempDir, "missing"))
+	assert.Equal(t, ErrCodeNoSuchKey, err)
+}
+
+func Test_TrickyNames(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	local := NewFileStorage(nil)
+	names := []string{"with space.csv", `quote'd "name".csv`, "$(touch pwned).csv", "semi;colon&amp.csv", "star*.csv", "-rf", ".hidden.csv"}
+	for _, name := range names {
+		from := local.PathJoin(tempDir, "src dir", name)
+		assert.Nil(t, local.PutObject(from, []byte(name)))
+		assert.Nil(t, local.CopyObject(from, local.PathJoin(tempDir, "copied", name)), name)
+		assert.Nil(t, local.MoveObject(from, local.PathJoin(tempDir, "moved", name)), name)
+		assert.False(t, local.IsExist(from))
+		bs, err := local.GetObject(local.PathJoin(tempDir, "moved", name))
+		assert.Nil(t, err)
+		assert.Equal(t, name, string(bs))
+	}
+	assert.False(t, local.IsExist("pwned"))
+	assert.False(t, local.IsExist(local.PathJoin(tempDir, "pwned")))
+
+	// folders, hidden files included.
+	assert.Nil(t, local.CopyObject(local.PathJoin(tempDir, "copied"), local.PathJoin(tempDir, "copied again")))
+	objs, _, err := local.ListObjects(local.PathJoin(tempDir, "copied again"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(names), len(objs))
+	assert.Nil(t, local.MoveObject(local.PathJoin(tempDir, "copied again"), local.PathJoin(tempDir, "moved")))
+	objs, _, _ = local.ListObjects(local.PathJoin(tempDir, "moved"))
+	assert.Equal(t, len(names), len(objs))
+	objs, _, _ = local.ListObjects(local.PathJoin(tempDir, "copied again"))
+	assert.Equal(t, 0, len(objs))
+}
+
+func Test_CopyObjectSemantics(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	local := NewFileStorage(nil)
+	src := local.PathJoin(tempDir, "a.csv")
+	assert.Nil(t, ioutil.WriteFile(src, []byte("a"), 0640))
+
+	// a file copied or moved into an existing folder keeps its name.
+	assert.Nil(t, os.Mkdir(local.PathJoin(tempDir, "dir"), 0750))
+	assert.Nil(t, local.CopyObject(src, local.PathJoin(tempDir, "dir")))
+	info, err := os.Stat(local.PathJoin(tempDir, "dir", "a.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	// the destination is replaced.
+	assert.Nil(t, local.PutObject(local.PathJoin(tempDir, "b.csv"), []byte("old")))
+	assert.Nil(t, local.MoveObject(src, local.PathJoin(tempDir, "b.csv")))
+	bs, _ := local.GetObject(local.PathJoin(tempDir, "b.csv"))
+	assert.Equal(t, "a", string(bs))
+
+	err = local.CopyObject(src, local.PathJoin(tempDir, "c.csv"))
+	fileErr, ok := err.(*FileError)
+	assert.True(t, ok)
+	assert.Equal(t, "copy", fileErr.Op)
+	assert.True(t, errors.Is(err, ErrCodeNoSuchKey))
+	assert.True(t, errors.Is(local.MoveObject(src, local.PathJoin(tempDir, "c.csv")), ErrCodeNoSuchKey))
+}
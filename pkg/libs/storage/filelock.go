@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileStaleLock is the age of a lock file left by a crashed writer, it is taken over.
+// The holder of a lock refreshes its lock file every fileStaleLock/4.
+var fileStaleLock = time.Minute
+
+// fileLockPollInterval is the wait between two attempts to take a lock.
+var fileLockPollInterval = 10 * time.Millisecond
+
+// fileLockPath return the path of the lock file of node, next to its sidecar.
+func fileLockPath(node string) string {
+	return filepath.Join(filepath.Dir(node), fileMetaDir, filepath.Base(node)+".lock")
+}
+
+// lockFile takes the lock of node by creating its lock file exclusively, it
+// waits for the lock until ctx is done. The returned func releases the lock.
+// The lock file is touched while the lock is held, so that a long write is not
+// taken for a crashed one.
+func lockFile(ctx context.Context, node string) (func(), error) {
+	path := fileLockPath(node)
+	if err := mkDirs(path); err != nil {
+		return nil, err
+	}
+	for {
+		lock, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+		if err == nil {
+			lock.Close()
+			return heartbeatLock(path), nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > fileStaleLock {
+			os.Remove(path)
+			continue
+		}
+		timer := time.NewTimer(fileLockPollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// heartbeatLock refreshes the modification time of the lock file at path until
+// the returned func is called, which removes the lock file.
+func heartbeatLock(path string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(fileStaleLock / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				now := time.Now()
+				os.Chtimes(path, now, now)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		os.Remove(path)
+	}
+}
+
+// fileGeneration return the generation of the file node, its modification time
+// in nanoseconds, 0 if it does not exist.
+func fileGeneration(node string) (int64, error) {
+	info, err := os.Stat(node)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir() {
+		return 0, nil
+	}
+	return info.ModTime().UnixNano(), nil
+}
+
+// conditional runs fn if node satisfies the preconditions of o, once node is
+// backed up. The check and fn run under the lock of node, so that concurrent
+// conditional calls are serialized.
+func (f *FileStorage) conditional(node string, o *writeOptions, fn func() error) error {
+	if !o.hasPrecondition() {
+		return f.replacing(node, fn)
+	}
+	unlock, err := lockFile(f.context(), node)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	generation, err := fileGeneration(node)
+	if err != nil {
+		return err
+	}
+	if err := o.checkPrecondition(node, generation); err != nil {
+		return err
+	}
+	return f.replacing(node, fn)
+}
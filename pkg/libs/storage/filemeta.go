@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// fileMetaDir is the hidden folder, next to the files, holding the content type
+// and the custom metadata of the files of FileStorage. It is left out of listings.
+const fileMetaDir = ".ae-copilot"
+
+type fileMeta struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// fileMetaPath return the path of the sidecar file of node.
+func fileMetaPath(node string) string {
+	return filepath.Join(filepath.Dir(node), fileMetaDir, filepath.Base(node)+".json")
+}
+
+// writeFileMeta saves the options of the write of node, the sidecar of a
+// previous write is removed if there is nothing to save.
+func writeFileMeta(node string, o *writeOptions) error {
+	if o.contentType == "" && len(o.metadata) == 0 {
+		return removeFileMeta(node)
+	}
+	data, err := json.Marshal(&fileMeta{ContentType: o.contentType, Metadata: o.metadata})
+	if err != nil {
+		return err
+	}
+	sidecar := fileMetaPath(node)
+	if err := mkDirs(sidecar); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sidecar, data, 0640)
+}
+
+// readFileMeta return the saved options of node, empty if there is no sidecar.
+func readFileMeta(node string) (*fileMeta, error) {
+	meta := new(fileMeta)
+	data, err := ioutil.ReadFile(fileMetaPath(node))
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func removeFileMeta(node string) error {
+	err := os.Remove(fileMetaPath(node))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// copyFileMeta copies the sidecar of src to dst, or removes the one of dst if src has none.
+func copyFileMeta(src, dst string) error {
+	meta, err := readFileMeta(src)
+	if err != nil {
+		return err
+	}
+	return writeFileMeta(dst, &writeOptions{contentType: meta.ContentType, metadata: meta.Metadata})
+}
+
+// moveFileMeta moves the sidecar of src to dst.
+func moveFileMeta(src, dst string) error {
+	if err := copyFileMeta(src, dst); err != nil {
+		return err
+	}
+	return removeFileMeta(src)
+}
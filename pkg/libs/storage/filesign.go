@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// The signed urls of local files point to FileURLBase, the http handler serving
+// them with VerifyFileURL, and are signed with FileURLKey. No key, no url.
+var (
+	FileURLKey  []byte
+	FileURLBase string
+)
+
+// ErrURLSignature is the error of a signed url forged, altered or expired.
+var ErrURLSignature = errors.New("invalid url signature")
+
+// SignURL return a url of FileURLBase granting method on the file until expiry
+// has passed, only GET is supported, which grants HEAD as well.
+func (f *FileStorage) SignURL(node, method string, expiry time.Duration) (string, error) {
+	if len(FileURLKey) == 0 || FileURLBase == "" {
+		return "", fmt.Errorf("%w: signed url of %s, no signing key", ErrNotSupported, node)
+	}
+	if method != http.MethodGet {
+		return "", fmt.Errorf("%w: signed url of %s for %s", ErrNotSupported, node, method)
+	}
+	node, err := filepath.Abs(node)
+	if err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{
+		"node":      {node},
+		"expires":   {expires},
+		"signature": {fileURLSignature(method, node, expires)},
+	}
+	return FileURLBase + "?" + query.Encode(), nil
+}
+
+// VerifyFileURL return the file granted to the http method by the query of a
+// url signed by FileStorage.SignURL, or ErrURLSignature.
+func VerifyFileURL(method string, query url.Values) (string, error) {
+	if len(FileURLKey) == 0 {
+		return "", fmt.Errorf("%w: no signing key", ErrURLSignature)
+	}
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	node, expires := query.Get("node"), query.Get("expires")
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed signature", ErrURLSignature)
+	}
+	want, _ := hex.DecodeString(fileURLSignature(method, node, expires))
+	if !hmac.Equal(signature, want) {
+		return "", fmt.Errorf("%w: %s of %s is not granted", ErrURLSignature, method, node)
+	}
+	deadline, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > deadline {
+		return "", fmt.Errorf("%w: url of %s expired", ErrURLSignature, node)
+	}
+	return node, nil
+}
+
+func fileURLSignature(method, node, expires string) string {
+	mac := hmac.New(sha256.New, FileURLKey)
+	mac.Write([]byte(method + "\n" + node + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
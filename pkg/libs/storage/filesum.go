@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileSumCacheSize is the number of md5s of local files kept by fileSums.
+const fileSumCacheSize = 4096
+
+// fileSums keeps the md5 of the files stated lately, so that Stat does not hash
+// a file again until its size or its modification time change.
+var fileSums = newFileSumCache(fileSumCacheSize)
+
+type fileSumCache struct {
+	max int
+
+	mu      sync.Mutex
+	lru     *list.List // of *fileSumEntry, the most recently used first
+	entries map[string]*list.Element
+}
+
+type fileSumEntry struct {
+	path    string
+	size    int64
+	modTime int64
+	sum     string
+}
+
+func newFileSumCache(max int) *fileSumCache {
+	return &fileSumCache{max: max, lru: list.New(), entries: map[string]*list.Element{}}
+}
+
+// sum return the md5 in hex of the file at path, of which info is the stat.
+func (c *fileSumCache) sum(path string, info os.FileInfo) (string, error) {
+	size, modTime := info.Size(), info.ModTime().UnixNano()
+	c.mu.Lock()
+	if e, ok := c.entries[path]; ok {
+		entry := e.Value.(*fileSumEntry)
+		if entry.size == size && entry.modTime == modTime {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			return entry.sum, nil
+		}
+	}
+	c.mu.Unlock()
+
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return "", err
+	}
+	entry := &fileSumEntry{path: path, size: size, modTime: modTime, sum: fmt.Sprintf("%x", sum.MD5())}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[path]; ok {
+		c.lru.Remove(e)
+	}
+	c.entries[path] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*fileSumEntry).path)
+	}
+	return entry.sum, nil
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// FileVersions is the number of backups of a file kept by FileStorage when the
+// file is overwritten or removed, 0 keeps none. Versioning is opted in, every
+// write would copy the file replaced otherwise.
+var FileVersions = 0
+
+// fileVersionsDir return the folder of the backups of node, next to its sidecar.
+// A backup is named after the generation of the file it saved.
+func fileVersionsDir(node string) string {
+	return filepath.Join(filepath.Dir(node), fileMetaDir, "versions", filepath.Base(node))
+}
+
+func fileBackupPath(node string, generation int64) string {
+	return filepath.Join(fileVersionsDir(node), strconv.FormatInt(generation, 10))
+}
+
+// fileBackups return the generations of the backups of node, oldest first.
+func fileBackups(node string) ([]int64, error) {
+	infos, err := ioutil.ReadDir(fileVersionsDir(node))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	generations := make([]int64, 0, len(infos))
+	for _, info := range infos {
+		if generation, err := strconv.ParseInt(info.Name(), 10, 64); err == nil && !info.IsDir() {
+			generations = append(generations, generation)
+		}
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i] < generations[j] })
+	return generations, nil
+}
+
+// backupFile saves the file node, if there is one, and its sidecar before it is
+// replaced or removed. The oldest backups beyond FileVersions are removed.
+func backupFile(ctx context.Context, node string) error {
+	if FileVersions <= 0 {
+		return nil
+	}
+	generation, err := fileGeneration(node)
+	if err != nil || generation == 0 {
+		return err
+	}
+	backup := fileBackupPath(node, generation)
+	if !isExist(backup) {
+		if err := copyFile(ctx, node, backup); err != nil {
+			return err
+		}
+		if err := copyFileMeta(node, backup); err != nil {
+			return err
+		}
+	}
+	generations, err := fileBackups(node)
+	if err != nil {
+		return err
+	}
+	for len(generations) > FileVersions {
+		oldest := fileBackupPath(node, generations[0])
+		if err := os.Remove(oldest); err != nil {
+			return err
+		}
+		if err := removeFileMeta(oldest); err != nil {
+			return err
+		}
+		generations = generations[1:]
+	}
+	return nil
+}
+
+// replacing runs fn, which replaces or removes the file node, once node is backed up.
+// The modification times of files are coarse, a file written within the tick of
+// the generation it replaces is given a later one, so that generations are unique.
+func (f *FileStorage) replacing(node string, fn func() error) error {
+	previous, err := fileGeneration(node)
+	if err != nil {
+		return err
+	}
+	if generations, err := fileBackups(node); err != nil {
+		return err
+	} else if n := len(generations); n > 0 && generations[n-1] > previous {
+		previous = generations[n-1]
+	}
+	if err := backupFile(f.context(), node); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	if current, err := fileGeneration(node); err != nil || current == 0 || current > previous {
+		return err
+	}
+	later := time.Unix(0, previous+1)
+	return os.Chtimes(node, later, later)
+}
+
+// ListVersions return the backups of the file, oldest first, and the file itself
+// if it exists. A backup is dated by the time it was replaced.
+func (f *FileStorage) ListVersions(node string) ([]*Object, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	generations, err := fileBackups(node)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]*Object, 0, len(generations)+1)
+	for _, generation := range generations {
+		backup := fileBackupPath(node, generation)
+		info, err := os.Stat(backup)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := fileSums.sum(backup, info)
+		if err != nil {
+			return nil, err
+		}
+		updated := time.Unix(0, generation)
+		objs = append(objs, &Object{
+			FileName:   node,
+			Size:       info.Size(),
+			ModTime:    updated.Unix(),
+			Sum:        sum,
+			Updated:    updated,
+			Generation: generation,
+			Deleted:    info.ModTime(),
+		})
+	}
+	if obj, err := f.Stat(node); err == nil {
+		objs = append(objs, obj)
+	} else if !errors.Is(err, ErrCodeNoSuchKey) {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// RestoreVersion copies the backup generation of the file over it, the file
+// replaced is backed up in turn.
+func (f *FileStorage) RestoreVersion(node string, generation int64) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	backup := fileBackupPath(node, generation)
+	if !isExist(backup) {
+		return ErrCodeNoSuchKey
+	}
+	meta, err := readFileMeta(backup)
+	if err != nil {
+		return err
+	}
+	// staged first, backing up the file may prune the backup being restored.
+	ctx, cancel := f.transfer()
+	defer cancel()
+	staged := filepath.Join(filepath.Dir(node), "."+filepath.Base(node)+".restore-"+strconv.FormatInt(generation, 10))
+	if err := copyFile(ctx, backup, staged); err != nil {
+		return err
+	}
+	defer os.Remove(staged)
+	return f.conditional(node, new(writeOptions), func() error {
+		if err := os.Rename(staged, node); err != nil {
+			return err
+		}
+		return writeFileMeta(node, &writeOptions{contentType: meta.ContentType, metadata: meta.Metadata})
+	})
+}
@@ -3,24 +3,47 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
+	"regexp"
 	"strings"
+	"time"
 
 	gs "cloud.google.com/go/storage"
 
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
 )
 
 // GCSStorage is remote storage by gcs
 type GCSStorage struct {
+	opContext
 	ProjectID string
 	Token     string
-	protocol  string
+	// EncryptionKey is the AES-256 key the objects are encrypted with, supplied
+	// by the customer, KMSKeyName the Cloud KMS key the objects written are
+	// encrypted with. At most one is set, see gcsOptions.
+	EncryptionKey []byte
+	KMSKeyName    string
+	protocol      string
+}
+
+func init() {
+	Register(Backend{
+		Type:          StorageOnGCP,
+		Name:          "gcp",
+		Scheme:        "gs://",
+		DecodeOptions: gcsOptions,
+		New:           func(opts map[string]interface{}) Storage { return NewGCSStorage(opts) },
+	})
 }
 
 // NewGCSStorage return a new GCS storage client
@@ -32,10 +55,23 @@ func NewGCSStorage(opts map[string]interface{}) *GCSStorage {
 	if Token, ok := opts["SecretAccessKey"]; ok {
 		gcpStorage.Token = Token.(string)
 	}
+	if key, ok := opts["EncryptionKey"]; ok {
+		gcpStorage.EncryptionKey = decodeEncryptionKey(key.(string))
+	}
+	if name, ok := opts["KMSKeyName"]; ok {
+		gcpStorage.KMSKeyName = name.(string)
+	}
 	gcpStorage.protocol = StorageOnGCP.Protocol()
 	return gcpStorage
 }
 
+// WithContext return a copy of the client whose calls are bound to ctx
+func (g *GCSStorage) WithContext(ctx context.Context) Storage {
+	c := *g
+	c.ctx = ctx
+	return &c
+}
+
 func (g *GCSStorage) PathJoin(items ...string) string {
 	if len(items) <= 0 {
 		return ""
@@ -58,21 +94,21 @@ func (g *GCSStorage) GetObject(node string) ([]byte, error) {
 }
 
 // PutObject save a data object via node.
-func (g *GCSStorage) PutObject(node string, data []byte) error {
+func (g *GCSStorage) PutObject(node string, data []byte, options ...WriteOption) error {
 	opts, err := parseObj(node)
 	if err != nil {
 		return err
 	}
-	return g.write(data, opts.Bucket, opts.Key)
+	return gcsPreconditionError(node, g.write(data, opts.Bucket, opts.Key, newWriteOptions(options)))
 }
 
-// RemoveObject remove a data object via node.
-func (g *GCSStorage) RemoveObject(node string) error {
+// RemoveObject remove a data object via node, if it satisfies the preconditions of options.
+func (g *GCSStorage) RemoveObject(node string, options ...WriteOption) error {
 	opts, err := parseObj(node)
 	if err != nil {
 		return err
 	}
-	return g.delete(opts.Bucket, opts.Key)
+	return gcsPreconditionError(node, g.delete(opts.Bucket, opts.Key, newWriteOptions(options)))
 }
 
 // RemoveDir remove a folder.
@@ -81,17 +117,34 @@ func (g *GCSStorage) RemoveDir(node string) error {
 	if err != nil {
 		return err
 	}
-	return g.delete(opts.Bucket, opts.Prefix)
+	return g.delete(opts.Bucket, opts.Prefix, new(writeOptions))
 }
 
-// RemoveAll remove a folder via path
+// RemoveAll remove every object under the folder, failures are reported as a *PrefixError
 func (g *GCSStorage) RemoveAll(path string) error {
-	// todo: detail logic
-	return nil
+	objs, _, err := g.ListObjects(path)
+	if err != nil {
+		return err
+	}
+	return forEachObject(g.context(), "remove", path, objs, func(obj *Object) error {
+		return g.RemoveObject(obj.FileName)
+	})
+}
+
+// CopyPrefix copy every object under the folder from into the folder to
+func (g *GCSStorage) CopyPrefix(from, to string) error {
+	return bucketPrefixOp(g.context(), g, "copy", from, to, func(src, dst string) error {
+		return g.CopyObject(src, dst)
+	})
 }
 
-// CopyObject backup this object
-func (g *GCSStorage) CopyObject(src, dst string) error {
+// MovePrefix move every object under the folder from into the folder to
+func (g *GCSStorage) MovePrefix(from, to string) error {
+	return bucketPrefixOp(g.context(), g, "move", from, to, g.MoveObject)
+}
+
+// CopyObject backup this object, dst must satisfy the preconditions of options.
+func (g *GCSStorage) CopyObject(src, dst string, options ...WriteOption) error {
 	srcOpts, err := parseObj(src)
 	if err != nil {
 		return err
@@ -100,7 +153,8 @@ func (g *GCSStorage) CopyObject(src, dst string) error {
 	if err != nil {
 		return err
 	}
-	return g.copyToBucket(srcOpts.Bucket, srcOpts.Key, dstOpts.Bucket, dstOpts.Key)
+	err = g.copyToBucket(srcOpts.Bucket, srcOpts.Key, dstOpts.Bucket, dstOpts.Key, newWriteOptions(options))
+	return gcsPreconditionError(dst, err)
 }
 
 // MoveObject rename this object
@@ -129,13 +183,187 @@ func (g *GCSStorage) IsExist(node string) bool {
 	return true
 }
 
+// Stat return the attributes and the custom metadata of the object
+func (g *GCSStorage) Stat(node string) (*Object, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := g.attrs(opts.Bucket, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{
+		FileName:        node,
+		Size:            attrs.Size,
+		ModTime:         attrs.Updated.Unix(),
+		Sum:             fmt.Sprintf("%x", attrs.MD5),
+		Created:         attrs.Created,
+		Updated:         attrs.Updated,
+		ContentType:     attrs.ContentType,
+		ContentEncoding: attrs.ContentEncoding,
+		Generation:      attrs.Generation,
+		Metadata:        attrs.Metadata,
+	}, nil
+}
+
+// Glob calls fn for the objects matching pattern, the listing is narrowed by
+// gcs to the prefix of the pattern before its first wildcard and, unless the
+// pattern holds characters special to gcs, to the pattern itself. The objects
+// are listed a page of WalkPageSize at a time, as Walk.
+func (g *GCSStorage) Glob(pattern string, fn WalkFunc) error {
+	glob, err := compileGlob(pattern)
+	if err != nil {
+		return err
+	}
+	opts, err := parseObj(pattern)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(opts.Bucket, globMeta) {
+		return fmt.Errorf("%w: glob %s matches buckets", ErrNotSupported, pattern)
+	}
+	query := &gs.Query{Prefix: opts.Key}
+	if i := strings.IndexAny(opts.Key, globMeta); i >= 0 {
+		query.Prefix = opts.Key[:i]
+	}
+	if !strings.ContainsAny(opts.Key, `[]{}\`) {
+		query.MatchGlob = gcsMatchGlob(opts.Key)
+	}
+	client, err := g.conn()
+	if err != nil {
+		return err
+	}
+	var token string
+	for {
+		if err := g.context().Err(); err != nil {
+			return err
+		}
+		page, next, err := g.queryPage(client.Bucket(opts.Bucket), query, token)
+		if err != nil {
+			return err
+		}
+		for _, attrs := range page {
+			obj := &Object{
+				FileName:   fmt.Sprintf("gs://%s/%s", opts.Bucket, attrs.Name),
+				Size:       attrs.Size,
+				Sum:        fmt.Sprintf("%x", attrs.MD5),
+				Created:    attrs.Created,
+				Updated:    attrs.Updated,
+				Generation: attrs.Generation,
+			}
+			if attrs.Name == "" || !glob.match(obj.FileName) {
+				continue
+			}
+			if err := fn(obj); err != nil {
+				if err == ErrStopWalk {
+					return nil
+				}
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
+
+// queryPage return the page of the objects of query following pageToken.
+func (g *GCSStorage) queryPage(bucket *gs.BucketHandle, query *gs.Query, pageToken string) ([]*gs.ObjectAttrs, string, error) {
+	ctx, cancel := g.operation()
+	defer cancel()
+	var page []*gs.ObjectAttrs
+	next, err := iterator.NewPager(bucket.Objects(ctx, query), WalkPageSize, pageToken).NextPage(&page)
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
+
+// gcsMatchGlob return the match glob of gcs selecting at least the keys matching
+// the pattern of key: the ** of gcs does not match the folders of a **/ left out.
+func gcsMatchGlob(key string) string {
+	return gcsGlobStars.ReplaceAllString(strings.ReplaceAll(key, "**/", "**"), "**")
+}
+
+var gcsGlobStars = regexp.MustCompile(`\*{3,}`)
+
+// ListVersions return the generations of the object kept by the bucket, it needs
+// the object versioning of the bucket to keep more than the live one.
+func (g *GCSStorage) ListVersions(node string) ([]*Object, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	objs, _, err := g.listByPrefix(opts.Bucket, opts.Key, "", true, ObjectTypeIsObject)
+	if err != nil {
+		return nil, err
+	}
+	return sortVersions(node, objs), nil
+}
+
+// RestoreVersion copies the generation of the object over the live one, the
+// restored content is a new generation.
+func (g *GCSStorage) RestoreVersion(node string, generation int64) error {
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	client, err := g.conn()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.operation()
+	defer cancel()
+	obj := g.object(client.Bucket(opts.Bucket), opts.Key)
+	_, err = g.copier(obj, obj.Generation(generation)).Run(ctx)
+	var gcsErr *googleapi.Error
+	if err == gs.ErrObjectNotExist || errors.As(err, &gcsErr) && gcsErr.Code == http.StatusNotFound {
+		return ErrCodeNoSuchKey
+	}
+	return err
+}
+
+// SignURL return a V4 signed url of the object, signed with the private key of
+// the service account of the credentials, or through the IAM credentials api by
+// the account of the default credentials. The objects encrypted with a customer
+// supplied key are not signed for, the holder of the url would need the key.
+func (g *GCSStorage) SignURL(node, method string, expiry time.Duration) (string, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return "", err
+	}
+	if g.EncryptionKey != nil {
+		return "", fmt.Errorf("%w: signed url of %s, encrypted with a customer supplied key", ErrNotSupported, node)
+	}
+	signOpts := &gs.SignedURLOptions{
+		Scheme:  gs.SigningSchemeV4,
+		Method:  method,
+		Expires: time.Now().Add(expiry),
+	}
+	var account struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if json.Unmarshal([]byte(g.Token), &account) == nil && account.ClientEmail != "" && account.PrivateKey != "" {
+		signOpts.GoogleAccessID, signOpts.PrivateKey = account.ClientEmail, []byte(account.PrivateKey)
+		return gs.SignedURL(opts.Bucket, opts.Key, signOpts)
+	}
+	client, err := g.conn()
+	if err != nil {
+		return "", err
+	}
+	return client.Bucket(opts.Bucket).SignedURL(opts.Key, signOpts)
+}
+
 // ListObjects return all files via prefix dir
 func (g *GCSStorage) ListObjects(dir string) ([]*Object, int64, error) {
 	opts, err := parseObj(dir)
 	if err != nil {
 		return nil, 0, err
 	}
-	list, size, err := g.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "", ObjectTypeIsObject)
+	list, size, err := g.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "", false, ObjectTypeIsObject)
 	return list, size, err
 }
 
@@ -144,7 +372,7 @@ func (g *GCSStorage) ListChildObjects(dir string) ([]*Object, int64, error) {
 	if err != nil {
 		return nil, 0, err
 	}
-	list, size, err := g.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", ObjectTypeIsObject)
+	list, size, err := g.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", false, ObjectTypeIsObject)
 	return list, size, err
 }
 
@@ -154,7 +382,7 @@ func (g *GCSStorage) ListDirs(dir string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	objs, _, err := g.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", ObjectTypeIsDir)
+	objs, _, err := g.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", false, ObjectTypeIsDir)
 	strSlice := make([]string, len(objs))
 	for k, v := range objs {
 		strSlice[k] = v.FileName
@@ -162,33 +390,56 @@ func (g *GCSStorage) ListDirs(dir string) ([]string, error) {
 	return strSlice, err
 }
 
-func (g *GCSStorage) conn() (*gs.Client, error) {
-	ctx := context.Background()
-	var client *gs.Client
-	var err error
-	if g.Token != "" {
-		cred := []byte(g.Token)
-		opts := make([]option.ClientOption, 0)
-		if cred != nil && len(cred) > 0 {
-			opts = append(opts, option.WithCredentialsJSON(cred))
-		}
-		client, err = gs.NewClient(ctx, opts...)
-	} else {
-		client, err = gs.NewClient(ctx)
+// ListPage return a page of the objects under the folder dir
+func (g *GCSStorage) ListPage(dir, pageToken string, pageSize int) ([]*Object, string, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, "", err
 	}
+	client, err := g.conn()
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	ctx, cancel := g.operation()
+	defer cancel()
+	it := client.Bucket(opts.Bucket).Objects(ctx, &gs.Query{Prefix: appendPathSuffix(opts.Key)})
+	var page []*gs.ObjectAttrs
+	next, err := iterator.NewPager(it, pageSize, pageToken).NextPage(&page)
+	if err != nil {
+		return nil, "", err
+	}
+	objs := make([]*Object, 0, len(page))
+	for _, attrs := range page {
+		objs = append(objs, &Object{
+			FileName: fmt.Sprintf("gs://%s/%s", opts.Bucket, attrs.Name),
+			Size:     attrs.Size,
+			Sum:      fmt.Sprintf("%x", attrs.MD5),
+			Created:  attrs.Created,
+			Updated:  attrs.Updated,
+		})
 	}
-	return client, nil
+	return objs, next, nil
+}
+
+// Walk calls fn for every object under the folder dir
+func (g *GCSStorage) Walk(dir string, fn WalkFunc) error {
+	return walk(g.context(), g, dir, fn)
+}
+
+// conn return the shared client of the credential
+func (g *GCSStorage) conn() (*gs.Client, error) {
+	return gcsClients.get(g.Token)
 }
 
-func (g *GCSStorage) write(data []byte, bucket, object string) error {
+func (g *GCSStorage) write(data []byte, bucket, object string, o *writeOptions) error {
 	client, err := g.conn()
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
-	wc := client.Bucket(bucket).Object(object).NewWriter(ctx)
+	ctx, cancel := g.operation()
+	defer cancel()
+	wc := g.newWriter(ctx, o.conditions(g.object(client.Bucket(bucket), object)))
+	o.apply(wc)
 	if _, err = io.Copy(wc, bytes.NewReader(data)); err != nil {
 		return err
 	}
@@ -203,8 +454,9 @@ func (g *GCSStorage) read(bucket, object string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	ctx := context.Background()
-	rc, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	ctx, cancel := g.operation()
+	defer cancel()
+	rc, err := g.object(client.Bucket(bucket), object).NewReader(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -222,11 +474,12 @@ func (g *GCSStorage) move(srcBucket, srcObject, dstBucket, dstObject string) err
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
-	src := client.Bucket(srcBucket).Object(srcObject)
-	dst := client.Bucket(dstBucket).Object(dstObject)
+	ctx, cancel := g.operation()
+	defer cancel()
+	src := g.object(client.Bucket(srcBucket), srcObject)
+	dst := g.object(client.Bucket(dstBucket), dstObject)
 
-	if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
+	if _, err := g.copier(dst, src).Run(ctx); err != nil {
 		return err
 	}
 	if err := src.Delete(ctx); err != nil {
@@ -235,68 +488,84 @@ func (g *GCSStorage) move(srcBucket, srcObject, dstBucket, dstObject string) err
 	return nil
 }
 
-func (g *GCSStorage) copyToBucket(srcBucket, srcObject, dstBucket, dstObject string) error {
+func (g *GCSStorage) copyToBucket(srcBucket, srcObject, dstBucket, dstObject string, o *writeOptions) error {
 	client, err := g.conn()
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
-	src := client.Bucket(srcBucket).Object(srcObject)
-	dst := client.Bucket(dstBucket).Object(dstObject)
+	ctx, cancel := g.operation()
+	defer cancel()
+	src := g.object(client.Bucket(srcBucket), srcObject)
+	dst := o.conditions(g.object(client.Bucket(dstBucket), dstObject))
 
-	if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
+	if _, err := g.copier(dst, src).Run(ctx); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (g *GCSStorage) delete(bucket, object string) error {
+func (g *GCSStorage) delete(bucket, object string, options *writeOptions) error {
 	client, err := g.conn()
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
-	o := client.Bucket(bucket).Object(object)
+	ctx, cancel := g.operation()
+	defer cancel()
+	o := options.conditions(g.object(client.Bucket(bucket), object))
 	if err := o.Delete(ctx); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (g *GCSStorage) attrs(bucket, object string) (map[string]string, error) {
+func (g *GCSStorage) attrs(bucket, object string) (*gs.ObjectAttrs, error) {
 	client, err := g.conn()
 	if err != nil {
 		return nil, err
 	}
-	ctx := context.Background()
-	o := client.Bucket(bucket).Object(object)
+	ctx, cancel := g.operation()
+	defer cancel()
+	o := g.object(client.Bucket(bucket), object)
 	attrs, err := o.Attrs(ctx)
 	if err != nil {
+		if err == gs.ErrObjectNotExist {
+			return nil, ErrCodeNoSuchKey
+		}
 		return nil, err
 	}
-	m := make(map[string]string)
-	for key, value := range attrs.Metadata {
-		m[key] = value
-	}
-	return m, nil
+	return attrs, nil
 }
 
-func (g *GCSStorage) listByPrefix(bucket, prefix, delim string, types ...ObjectType) ([]*Object, int64, error) {
-	var dirType, objectType bool
-	for _, v := range types {
-		if v == ObjectTypeIsDir {
-			dirType = true
-		}
-		if v == ObjectTypeIsObject {
-			objectType = true
-		}
+// conditions return obj bound to the preconditions of the options, generation
+// 0 stands for an object that does not exist.
+func (o *writeOptions) conditions(obj *gs.ObjectHandle) *gs.ObjectHandle {
+	switch {
+	case o.ifNotExist || o.ifGeneration != nil && *o.ifGeneration == 0:
+		return obj.If(gs.Conditions{DoesNotExist: true})
+	case o.ifGeneration != nil:
+		return obj.If(gs.Conditions{GenerationMatch: *o.ifGeneration})
 	}
+	return obj
+}
 
-	client, err := g.conn()
-	if err != nil {
-		return nil, 0, err
+// gcsPreconditionError wraps the rejection of a precondition by gcs with ErrPreconditionFailed.
+func gcsPreconditionError(node string, err error) error {
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) && gcsErr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("%w: %s: %v", ErrPreconditionFailed, node, err)
 	}
-	ctx := context.Background()
+	return err
+}
+
+// apply sets the options on a gcs writer.
+func (o *writeOptions) apply(w *gs.Writer) {
+	w.ContentType = o.contentTypeOf(w.Name)
+	w.Metadata = o.metadata
+}
+
+// listByPrefix lists the objects or the folders under prefix, every generation
+// of the objects if versions is set, the live ones only otherwise.
+func (g *GCSStorage) listByPrefix(bucket, prefix, delim string, versions bool, types ...ObjectType) ([]*Object, int64, error) {
 	// Prefixes and delimiters can be used to emulate directory listings.
 	// Prefixes can be used filter objects starting with prefix.
 	// The delimiter argument can be used to restrict the results to only the
@@ -313,12 +582,33 @@ func (g *GCSStorage) listByPrefix(bucket, prefix, delim string, types ...ObjectT
 	//
 	// However, if you specify prefix="a/" and delim="/", you'll get back:
 	//   /a/1.txt
-	it := client.Bucket(bucket).Objects(ctx, &gs.Query{
+	return g.listQuery(bucket, &gs.Query{
 		Prefix:    prefix,
 		Delimiter: delim,
-		Versions:  false,
-	})
-	m := make([]*Object, 0, 10000)
+		Versions:  versions,
+	}, types...)
+}
+
+// listQuery lists the objects or the folders of the bucket selected by query.
+func (g *GCSStorage) listQuery(bucket string, query *gs.Query, types ...ObjectType) ([]*Object, int64, error) {
+	var dirType, objectType bool
+	for _, v := range types {
+		if v == ObjectTypeIsDir {
+			dirType = true
+		}
+		if v == ObjectTypeIsObject {
+			objectType = true
+		}
+	}
+
+	client, err := g.conn()
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx, cancel := g.operation()
+	defer cancel()
+	it := client.Bucket(bucket).Objects(ctx, query)
+	m := make([]*Object, 0)
 	var size int64
 	for {
 		attrs, err := it.Next()
@@ -343,51 +633,260 @@ func (g *GCSStorage) listByPrefix(bucket, prefix, delim string, types ...ObjectT
 		}
 		if attrs.Name != "" && objectType {
 			m = append(m, &Object{
-				FileName: fmt.Sprintf("gs://%s/%s", bucket, attrs.Name),
-				Size:     attrs.Size,
-				Sum:      fmt.Sprintf("%x", attrs.MD5),
-				Created:  attrs.Created,
-				Updated:  attrs.Updated,
+				FileName:   fmt.Sprintf("gs://%s/%s", bucket, attrs.Name),
+				Size:       attrs.Size,
+				Sum:        fmt.Sprintf("%x", attrs.MD5),
+				Created:    attrs.Created,
+				Updated:    attrs.Updated,
+				Generation: attrs.Generation,
+				Deleted:    attrs.Deleted,
 			})
 		}
 	}
 	return m, size, nil
 }
 
-// Download download file to local
-func (g *GCSStorage) Download(from, to string) error {
+// Download download file to local, the file is verified against the md5 and
+// crc32c of the object, see ErrChecksumMismatch.
+func (g *GCSStorage) Download(from, to string, options ...WriteOption) error {
 	opts, err := parseObj(from)
 	if err != nil {
 		return err
 	}
+	client, err := g.conn()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.transfer()
+	defer cancel()
+	obj := g.object(client.Bucket(opts.Bucket), opts.Key)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		if err == gs.ErrObjectNotExist {
+			return ErrCodeNoSuchKey
+		}
+		return err
+	}
+	o := newWriteOptions(options)
+	// gzip encoded objects are served decompressed, their ranges can not be read.
+	if parallelTransfer(attrs.Size) && attrs.ContentEncoding != "gzip" {
+		return g.parallelDownload(ctx, obj.Generation(attrs.Generation), attrs, to, o.progress)
+	}
+
 	file, err := os.Create(to)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	r, verify, err := g.readerOf(ctx, obj, attrs)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	sum := newChecksum()
+	p := newProgress(o.progress, attrs.Size)
+	buf := make([]byte, 5*1024*1024) //5MB
+	if _, err = io.CopyBuffer(&progressWriter{w: io.MultiWriter(file, sum), progress: p}, r, buf); err == nil {
+		err = verify(sum)
+	}
+	if errors.Is(err, ErrChecksumMismatch) {
+		file.Close()
+		os.Remove(to)
+	}
+	if err == nil {
+		p.done()
+	}
+	return err
+}
 
+// OpenReader return a stream of the object, the last read fails with
+// ErrChecksumMismatch if the data does not match the object.
+func (g *GCSStorage) OpenReader(node string) (io.ReadCloser, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
 	client, err := g.conn()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	ctx, cancel := g.transfer()
+	r, verify, err := g.newReader(ctx, client, opts.Bucket, opts.Key)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelReadCloser{ReadCloser: newVerifyingReader(r, verify), cancel: cancel}, nil
+}
+
+// newReader opens the object at its current generation, and return the check
+// of the data read against the hashes of that generation.
+func (g *GCSStorage) newReader(ctx context.Context, client *gs.Client, bucket, object string) (*gcsReader, func(sum *checksum) error, error) {
+	obj := g.object(client.Bucket(bucket), object)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		if err == gs.ErrObjectNotExist {
+			return nil, nil, ErrCodeNoSuchKey
+		}
+		return nil, nil, err
 	}
-	r, err := client.Bucket(opts.Bucket).Object(opts.Key).NewReader(context.Background())
+	return g.readerOf(ctx, obj, attrs)
+}
+
+// readerOf opens obj at the generation of attrs.
+func (g *GCSStorage) readerOf(ctx context.Context, obj *gs.ObjectHandle, attrs *gs.ObjectAttrs) (*gcsReader, func(sum *checksum) error, error) {
+	r, err := obj.Generation(attrs.Generation).NewReader(ctx)
 	if err != nil {
 		if err == gs.ErrObjectNotExist {
-			return ErrCodeNoSuchKey
+			return nil, nil, ErrCodeNoSuchKey
 		}
+		return nil, nil, err
+	}
+	node := fmt.Sprintf("gs://%s/%s", attrs.Bucket, attrs.Name)
+	return &gcsReader{Reader: r, node: node}, gcsVerifier(node, attrs), nil
+}
+
+// gcsVerifier return the check of the data of an object against its hashes,
+// composite objects have no md5.
+func gcsVerifier(node string, attrs *gs.ObjectAttrs) func(sum *checksum) error {
+	return func(sum *checksum) error {
+		// gzip encoded objects are served decompressed, the hashes are the ones of the stored bytes.
+		if attrs.ContentEncoding == "gzip" {
+			return nil
+		}
+		if err := sum.verifyMD5(node, attrs.MD5); err != nil {
+			return err
+		}
+		return sum.verifyCRC32C(node, attrs.CRC32C)
+	}
+}
+
+// gcsReader reports the crc32c check of the gcs client as ErrChecksumMismatch.
+type gcsReader struct {
+	*gs.Reader
+	node string
+}
+
+func (r *gcsReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	return n, gcsChecksumError(r.node, err)
+}
+
+// gcsChecksumError wraps the errors of gcs about hashes with ErrChecksumMismatch,
+// a bad crc32c on read or an upload rejected for its md5 or crc32c.
+func gcsChecksumError(node string, err error) error {
+	if err == nil || err == io.EOF {
 		return err
 	}
-	defer r.Close()
-	buf := make([]byte, 5*1024*1024) //5MB
-	_, err = io.CopyBuffer(file, r, buf)
+	var gcsErr *googleapi.Error
+	if strings.Contains(err.Error(), "bad CRC") ||
+		errors.As(err, &gcsErr) && gcsErr.Code == http.StatusBadRequest &&
+			(strings.Contains(gcsErr.Message, "MD5") || strings.Contains(gcsErr.Message, "CRC32C")) {
+		return fmt.Errorf("%w: %s: %v", ErrChecksumMismatch, node, err)
+	}
+	return err
+}
+
+// TempPrefix is the folder, at the root of a bucket, where data is staged
+// until it is verified and copied or composed into place. It is out of the
+// folders of the tenants so that no consumer lists partial data.
+var TempPrefix = "_ae-copilot/tmp/"
+
+// tempName return the name of a new object of TempPrefix.
+func tempName() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return TempPrefix + hex.EncodeToString(b[:])
+}
+
+// OpenWriter return a stream writing into the object, the object is created on Close.
+// With WithMD5 gcs rejects other data itself. Otherwise the data is staged in
+// an object of TempPrefix and copied into place once its hashes are checked,
+// so that Close fails with ErrChecksumMismatch and leaves node untouched if
+// gcs did not store the data written.
+func (g *GCSStorage) OpenWriter(node string, options ...WriteOption) (io.WriteCloser, error) {
+	opts, err := parseObj(node)
 	if err != nil {
+		return nil, err
+	}
+	client, err := g.conn()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.transfer()
+	o := newWriteOptions(options)
+	bucket := client.Bucket(opts.Bucket)
+	dst := o.conditions(g.object(bucket, opts.Key))
+	if o.md5 != nil {
+		w := g.newWriter(ctx, dst)
+		o.apply(w)
+		w.MD5 = o.md5
+		return &gcsWriter{Writer: w, ctx: ctx, cancel: cancel, node: node}, nil
+	}
+	staged := g.object(bucket, tempName())
+	w := g.newWriter(ctx, staged.If(gs.Conditions{DoesNotExist: true}))
+	o.apply(w)
+	w.ContentType = o.contentTypeOf(opts.Key)
+	return &gcsWriter{Writer: w, ctx: ctx, cancel: cancel, node: node, storage: g, staged: staged, dst: dst, sum: newChecksum()}, nil
+}
+
+// gcsWriter cancels the upload context instead of committing the object on abort.
+type gcsWriter struct {
+	*gs.Writer
+	ctx    context.Context
+	cancel context.CancelFunc
+	node   string
+	// staged is the object the data is written to when its md5 is not known
+	// beforehand, it is copied into dst once sum is checked.
+	storage *GCSStorage
+	staged  *gs.ObjectHandle
+	dst     *gs.ObjectHandle
+	sum     *checksum
+}
+
+func (w *gcsWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if w.sum != nil {
+		w.sum.Write(p[:n])
+	}
+	return n, err
+}
+
+func (w *gcsWriter) Close() error {
+	defer w.cancel()
+	if err := w.Writer.Close(); err != nil {
+		return gcsPreconditionError(w.node, gcsChecksumError(w.node, err))
+	}
+	if w.staged == nil {
+		return nil
+	}
+	attrs := w.Writer.Attrs()
+	staged := w.staged.If(gs.Conditions{GenerationMatch: attrs.Generation})
+	// best effort, a staged object left behind is outside of the tenant folders.
+	defer staged.Delete(w.ctx)
+	if err := w.sum.verifyMD5(w.node, attrs.MD5); err != nil {
+		return err
+	}
+	if err := w.sum.verifyCRC32C(w.node, attrs.CRC32C); err != nil {
 		return err
 	}
+	_, err := w.storage.copier(w.dst, staged).Run(w.ctx)
+	return gcsPreconditionError(w.node, err)
+}
+
+func (w *gcsWriter) CloseWithError(err error) error {
+	w.cancel()
+	w.Writer.Close()
 	return nil
 }
 
-// Upload put file to remote
-func (g *GCSStorage) Upload(from, to string) error {
+// Upload put file to remote, the md5 and crc32c of the file are sent along so
+// that gcs rejects corrupted data, see ErrChecksumMismatch.
+func (g *GCSStorage) Upload(from, to string, options ...WriteOption) error {
+	sum, err := fileChecksum(from)
+	if err != nil {
+		return err
+	}
 	file, err := os.Open(from)
 	if err != nil {
 		return err
@@ -402,12 +901,32 @@ func (g *GCSStorage) Upload(from, to string) error {
 	if err != nil {
 		return err
 	}
-	w := client.Bucket(opts.Bucket).Object(opts.Key).NewWriter(context.Background())
-	defer w.Close()
+	ctx, cancel := g.transfer()
+	defer cancel()
+	o := newWriteOptions(options)
+	reader, err := newCustomReader(file, o.progress)
+	if err != nil {
+		return err
+	}
+	// the gcs client sends no KMS key along a compose, such files are sent in one stream.
+	if parallelTransfer(reader.size) && g.KMSKeyName == "" {
+		err := g.parallelUpload(ctx, client.Bucket(opts.Bucket), opts.Key, reader, sum, o)
+		return gcsPreconditionError(to, gcsChecksumError(to, err))
+	}
+	w := g.newWriter(ctx, o.conditions(g.object(client.Bucket(opts.Bucket), opts.Key)))
+	o.apply(w)
+	w.MD5 = sum.MD5()
+	w.CRC32C = sum.CRC32C()
+	w.SendCRC32C = true
 	buf := make([]byte, 5*1024*1024) //5MB
-	_, err = io.CopyBuffer(w, file, buf)
+	_, err = io.CopyBuffer(w, reader, buf)
 	if err != nil {
+		// the upload is abandoned by cancel, w must not commit a partial object.
+		return err
+	}
+	if err := gcsPreconditionError(to, gcsChecksumError(to, w.Close())); err != nil {
 		return err
 	}
+	reader.done()
 	return nil
 }
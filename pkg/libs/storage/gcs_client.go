@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	gs "cloud.google.com/go/storage"
+
+	"google.golang.org/api/option"
+)
+
+// gcsClients is shared by every GCSStorage, gs.Client is safe for concurrent
+// use and keeps its own connection pool, so one client per credential is enough.
+var gcsClients = &gcsClientCache{clients: map[string]*gs.Client{}}
+
+type gcsClientCache struct {
+	sync.Mutex
+	clients map[string]*gs.Client
+}
+
+// get return the client of the credential, dialing it on first use.
+func (c *gcsClientCache) get(token string) (*gs.Client, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	c.Lock()
+	defer c.Unlock()
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+	client, err := newGCSClient(token)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[key] = client
+	return client, nil
+}
+
+// close closes and forgets every cached client.
+func (c *gcsClientCache) close() error {
+	c.Lock()
+	defer c.Unlock()
+	var err error
+	for key, client := range c.clients {
+		if e := client.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(c.clients, key)
+	}
+	return err
+}
+
+func newGCSClient(token string) (*gs.Client, error) {
+	// the client outlives a single call, it must not be bound to the call context.
+	ctx := context.Background()
+	if token == "" {
+		return gs.NewClient(ctx)
+	}
+	return gs.NewClient(ctx, option.WithCredentialsJSON([]byte(token)))
+}
+
+// CloseClients closes the clients shared by the storage backends, call it on shutdown.
+func CloseClients() error {
+	return gcsClients.close()
+}
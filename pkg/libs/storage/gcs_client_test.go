@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCSClientCache(t *testing.T) {
+	newFakeGCS(t)
+	a, err := gcsClients.get("")
+	assert.Nil(t, err)
+	b, err := gcsClients.get("")
+	assert.Nil(t, err)
+	assert.True(t, a == b)
+
+	assert.Nil(t, CloseClients())
+	c, err := gcsClients.get("")
+	assert.Nil(t, err)
+	assert.True(t, a != c)
+}
+
+func mockGCSListing(f *fakeGCS, tenants int) {
+	for i := 0; i < tenants; i++ {
+		for j := 0; j < 10; j++ {
+			f.put("bucket", fmt.Sprintf("%d/REJECT/folder/%d.csv", i, j), []byte(mockContent))
+		}
+	}
+}
+
+// BenchmarkGCSStorage_ListChildObjects lists like the scanner does on every tick,
+// dial redials a client per call as GCSStorage did before the shared cache.
+func BenchmarkGCSStorage_ListChildObjects(b *testing.B) {
+	f := newFakeGCS(b)
+	mockGCSListing(f, 10)
+	for _, shared := range []bool{false, true} {
+		name := "dial"
+		if shared {
+			name = "shared"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !shared {
+					CloseClients()
+				}
+				client, err := NewStorageClient("gs://bucket/", `{}`)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, _, err := client.ListChildObjects(fmt.Sprintf("gs://bucket/%d/REJECT/folder", i%10)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
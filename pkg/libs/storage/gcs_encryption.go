@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	gs "cloud.google.com/go/storage"
+)
+
+// gcsOptions decodes the credentials of gcs. Besides ProjectID and
+// SecretAccessKey they may set the encryption of the objects: EncryptionKey, a
+// base64 AES-256 key supplied by the customer, or KMSKeyName, the resource name
+// of a Cloud KMS key, e.g. projects/p/locations/l/keyRings/r/cryptoKeys/k.
+func gcsOptions(credentials string) (map[string]interface{}, error) {
+	opts, err := JSONOptions("ProjectID", "SecretAccessKey", "EncryptionKey", "KMSKeyName")(credentials)
+	if err != nil || opts == nil {
+		return opts, err
+	}
+	key, withKey := opts["EncryptionKey"]
+	if _, withKMS := opts["KMSKeyName"]; withKey && withKMS {
+		return nil, fmt.Errorf("storage: malformed credentials: EncryptionKey and KMSKeyName are exclusive")
+	}
+	if withKey {
+		if decoded, err := base64.StdEncoding.DecodeString(key.(string)); err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("storage: malformed credentials: EncryptionKey is not a base64 AES-256 key")
+		}
+	}
+	return opts, nil
+}
+
+// decodeEncryptionKey return the AES-256 key encoded in key. A malformed key is
+// kept empty but set, so that gcs refuses every call instead of writing in clear.
+func decodeEncryptionKey(key string) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return []byte{}
+	}
+	return decoded
+}
+
+// object return the handle of the object name of bucket, bound to the
+// encryption key of the storage if any.
+func (g *GCSStorage) object(bucket *gs.BucketHandle, name string) *gs.ObjectHandle {
+	obj := bucket.Object(name)
+	if g.EncryptionKey != nil {
+		obj = obj.Key(g.EncryptionKey)
+	}
+	return obj
+}
+
+// newWriter return a writer of obj encrypted with the KMS key of the storage if any.
+func (g *GCSStorage) newWriter(ctx context.Context, obj *gs.ObjectHandle) *gs.Writer {
+	w := obj.NewWriter(ctx)
+	w.KMSKeyName = g.KMSKeyName
+	return w
+}
+
+// copier return the copier of src into dst, encrypted with the KMS key of the storage if any.
+func (g *GCSStorage) copier(dst, src *gs.ObjectHandle) *gs.Copier {
+	c := dst.CopierFrom(src)
+	c.DestinationKMSKeyName = g.KMSKeyName
+	return c
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCSOptions(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	opts, err := gcsOptions(`{"ProjectID":"p","EncryptionKey":"` + key + `"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{1}, 32), NewGCSStorage(opts).EncryptionKey)
+	opts, err = gcsOptions(`{"KMSKeyName":"projects/p/locations/l/keyRings/r/cryptoKeys/k"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "projects/p/locations/l/keyRings/r/cryptoKeys/k", NewGCSStorage(opts).KMSKeyName)
+	opts, err = gcsOptions("")
+	assert.Nil(t, err)
+	assert.Nil(t, NewGCSStorage(opts).EncryptionKey)
+
+	for _, credentials := range []string{
+		`{"EncryptionKey":"` + key + `","KMSKeyName":"k"}`,
+		`{"EncryptionKey":"not base64"}`,
+		`{"EncryptionKey":"` + base64.StdEncoding.EncodeToString([]byte("short")) + `"}`,
+	} {
+		_, err := gcsOptions(credentials)
+		assert.NotNil(t, err, credentials)
+	}
+}
+
+func TestGCSStorage_EncryptionKey(t *testing.T) {
+	fake := newFakeGCS(t)
+	setTransferParts(t, 1000, 4)
+	tempDir, err := ioutil.TempDir("", "encryption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	key := bytes.Repeat([]byte{7}, 32)
+	keySHA256 := sha256.Sum256(key)
+	encrypted := NewGCSStorage(map[string]interface{}{"EncryptionKey": base64.StdEncoding.EncodeToString(key)})
+	clear := NewGCSStorage(nil)
+
+	assert.Nil(t, encrypted.PutObject("gs://bucket/REJECT/a.csv", []byte("hello")))
+	data, err := encrypted.GetObject("gs://bucket/REJECT/a.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+	_, err = clear.GetObject("gs://bucket/REJECT/a.csv")
+	assert.NotNil(t, err)
+
+	reader, err := encrypted.OpenReader("gs://bucket/REJECT/a.csv")
+	assert.Nil(t, err)
+	data, err = ioutil.ReadAll(reader)
+	reader.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	writer, err := encrypted.OpenWriter("gs://bucket/in/a.csv")
+	assert.Nil(t, err)
+	writer.Write([]byte("world"))
+	assert.Nil(t, writer.Close())
+	assert.Nil(t, encrypted.CopyObject("gs://bucket/in/a.csv", "gs://bucket/in/b.csv"))
+	assert.Nil(t, encrypted.Download("gs://bucket/in/b.csv", filepath.Join(tempDir, "b.csv")))
+	data, err = ioutil.ReadFile(filepath.Join(tempDir, "b.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(data))
+
+	// uploaded in parts, composed with the same key.
+	large := bytes.Repeat([]byte("0123456789"), 350)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "large.csv"), large, 0640))
+	assert.Nil(t, encrypted.Upload(filepath.Join(tempDir, "large.csv"), "gs://bucket/in/large.csv"))
+	assert.Nil(t, encrypted.Download("gs://bucket/in/large.csv", filepath.Join(tempDir, "large.out")))
+	data, err = ioutil.ReadFile(filepath.Join(tempDir, "large.out"))
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(large, data))
+
+	for _, name := range []string{"bucket/REJECT/a.csv", "bucket/in/a.csv", "bucket/in/b.csv", "bucket/in/large.csv"} {
+		assert.Equal(t, base64.StdEncoding.EncodeToString(keySHA256[:]), fake.objects[name].keySHA256, name)
+	}
+
+	malformed := NewGCSStorage(map[string]interface{}{"EncryptionKey": "not base64"})
+	assert.NotNil(t, malformed.PutObject("gs://bucket/in/c.csv", []byte("hello")))
+	assert.Nil(t, fake.objects["bucket/in/c.csv"])
+}
+
+func TestGCSStorage_KMSKeyName(t *testing.T) {
+	fake := newFakeGCS(t)
+	setTransferParts(t, 1000, 4)
+	tempDir, err := ioutil.TempDir("", "encryption")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	kmsKeyName := "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+	encrypted := NewGCSStorage(map[string]interface{}{"KMSKeyName": kmsKeyName})
+
+	assert.Nil(t, encrypted.PutObject("gs://bucket/in/a.csv", []byte("hello")))
+	writer, err := encrypted.OpenWriter("gs://bucket/in/b.csv")
+	assert.Nil(t, err)
+	writer.Write([]byte("world"))
+	assert.Nil(t, writer.Close())
+	assert.Nil(t, encrypted.CopyObject("gs://bucket/in/a.csv", "gs://bucket/in/c.csv"))
+	assert.Nil(t, encrypted.MoveObject("gs://bucket/in/c.csv", "gs://bucket/in/d.csv"))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "large.csv"), bytes.Repeat([]byte("0123456789"), 350), 0640))
+	assert.Nil(t, encrypted.Upload(filepath.Join(tempDir, "large.csv"), "gs://bucket/in/large.csv"))
+
+	for _, name := range []string{"bucket/in/a.csv", "bucket/in/b.csv", "bucket/in/d.csv", "bucket/in/large.csv"} {
+		assert.Equal(t, kmsKeyName, fake.objects[name].kmsKeyName, name)
+	}
+	data, err := NewGCSStorage(nil).GetObject("gs://bucket/in/d.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+}
@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	gs "cloud.google.com/go/storage"
+)
+
+// TransferPartSize is the size of the byte ranges of parallel downloads and of the
+// parts of parallel uploads, smaller objects are transferred in a single stream.
+var TransferPartSize int64 = 64 << 20
+
+// TransferConcurrency bounds the parts of a transfer in flight, 1 or less turns
+// parallel transfers off.
+var TransferConcurrency = 8
+
+// gcsComposeLimit is the most objects gcs composes at once.
+const gcsComposeLimit = 32
+
+// parallelTransfer return true if an object of size bytes is transferred in parts.
+func parallelTransfer(size int64) bool {
+	return TransferConcurrency > 1 && TransferPartSize > 0 && size > TransferPartSize
+}
+
+// partRange return the offset and the length of the part i of size bytes.
+func partRange(size int64, i int) (int64, int64) {
+	off := int64(i) * TransferPartSize
+	if size-off < TransferPartSize {
+		return off, size - off
+	}
+	return off, TransferPartSize
+}
+
+// forEachPart calls fn for the parts 0 to n-1 which are not done, with at most
+// TransferConcurrency calls in flight. No part is started after the first failure,
+// which is returned, the context of the calls in flight is canceled.
+func forEachPart(ctx context.Context, n int, done func(i int) bool, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var first error
+	var once sync.Once
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, TransferConcurrency)
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		if done(i) {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if first != nil {
+		return first
+	}
+	return ctx.Err()
+}
+
+// downloadState records the parts of a parallel download written to the local
+// file, it is saved next to the file so that an interrupted download resumes.
+type downloadState struct {
+	Generation int64  `json:"generation"`
+	Size       int64  `json:"size"`
+	PartSize   int64  `json:"part_size"`
+	Done       []bool `json:"done"`
+}
+
+func downloadStatePath(to string) string {
+	return to + ".parts"
+}
+
+// loadDownloadState return the saved state of the download of attrs into to, or
+// nil if there is none for that generation and part size.
+func loadDownloadState(to string, attrs *gs.ObjectAttrs, n int) *downloadState {
+	data, err := ioutil.ReadFile(downloadStatePath(to))
+	if err != nil {
+		return nil
+	}
+	state := new(downloadState)
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil
+	}
+	if state.Generation != attrs.Generation || state.Size != attrs.Size ||
+		state.PartSize != TransferPartSize || len(state.Done) != n {
+		return nil
+	}
+	if info, err := os.Stat(to); err != nil || info.Size() != attrs.Size {
+		return nil
+	}
+	return state
+}
+
+// save writes the state into a temporary file renamed over the previous state.
+func (s *downloadState) save(to string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	path := downloadStatePath(to)
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// offsetWriter writes at increasing offsets of a file.
+type offsetWriter struct {
+	file *os.File
+	off  int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// parallelDownload reads the byte ranges of obj in parallel into the local file to.
+// The parts written are recorded in a state file, a later download of the same
+// generation only reads the parts left. The file is verified against the hashes
+// of the object once complete.
+func (g *GCSStorage) parallelDownload(ctx context.Context, obj *gs.ObjectHandle, attrs *gs.ObjectAttrs, to string, fn ProgressFunc) error {
+	n := int((attrs.Size + TransferPartSize - 1) / TransferPartSize)
+	state := loadDownloadState(to, attrs, n)
+	flag := os.O_RDWR | os.O_CREATE
+	if state == nil {
+		state = &downloadState{Generation: attrs.Generation, Size: attrs.Size, PartSize: TransferPartSize, Done: make([]bool, n)}
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(to, flag, 0640)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(attrs.Size); err != nil {
+		return err
+	}
+	if err := state.save(to); err != nil {
+		return err
+	}
+
+	p := newProgress(fn, attrs.Size)
+	for i, done := range state.Done {
+		if _, length := partRange(attrs.Size, i); done {
+			p.add(int(length))
+		}
+	}
+	var mu sync.Mutex
+	resumed := append([]bool(nil), state.Done...)
+	err = forEachPart(ctx, n, func(i int) bool { return resumed[i] }, func(ctx context.Context, i int) error {
+		off, length := partRange(attrs.Size, i)
+		r, err := obj.NewRangeReader(ctx, off, length)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		written, err := io.Copy(&progressWriter{w: &offsetWriter{file: file, off: off}, progress: p}, r)
+		if err != nil {
+			return err
+		}
+		if written != length {
+			return io.ErrUnexpectedEOF
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		state.Done[i] = true
+		return state.save(to)
+	})
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	sum, err := fileChecksum(to)
+	if err != nil {
+		return err
+	}
+	if err := gcsVerifier(fmt.Sprintf("gs://%s/%s", attrs.Bucket, attrs.Name), attrs)(sum); err != nil {
+		file.Close()
+		os.Remove(to)
+		os.Remove(downloadStatePath(to))
+		return err
+	}
+	p.done()
+	return os.Remove(downloadStatePath(to))
+}
+
+// parallelUpload uploads the parts of the file read by reader in parallel as
+// temporary objects of TempPrefix, composes them into object and removes them.
+// The composed object is checked against the crc32c of the file, sum.
+func (g *GCSStorage) parallelUpload(ctx context.Context, bucket *gs.BucketHandle, object string, reader *CustomReader, sum *checksum, o *writeOptions) error {
+	n := int((reader.size + TransferPartSize - 1) / TransferPartSize)
+	// staged out of the folder of object, its consumers never list the parts.
+	prefix := tempName() + "/"
+	// the sources of a compose are given without the encryption key, gcs reads
+	// them with the key of the destination.
+	parts := make([]*gs.ObjectHandle, n)
+	temps := make([]*gs.ObjectHandle, 0, n)
+	for i := range parts {
+		parts[i] = bucket.Object(fmt.Sprintf("%s%05d", prefix, i))
+		temps = append(temps, parts[i])
+	}
+	defer func() {
+		// the parts are removed whatever the outcome, a part not uploaded is not found.
+		ctx, cancel := g.operation()
+		defer cancel()
+		for _, temp := range temps {
+			temp.Delete(ctx)
+		}
+	}()
+
+	err := forEachPart(ctx, n, func(int) bool { return false }, func(ctx context.Context, i int) error {
+		off, length := partRange(reader.size, i)
+		partSum := newChecksum()
+		if _, err := io.Copy(partSum, io.NewSectionReader(reader.fp, off, length)); err != nil {
+			return err
+		}
+		w := g.newWriter(ctx, g.object(bucket, parts[i].ObjectName()).If(gs.Conditions{DoesNotExist: true}))
+		w.MD5 = partSum.MD5()
+		w.CRC32C = partSum.CRC32C()
+		w.SendCRC32C = true
+		if _, err := io.Copy(w, io.NewSectionReader(reader, off, length)); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	// compose the parts by groups until they fit in one compose.
+	sources := parts
+	for level := 0; len(sources) > gcsComposeLimit; level++ {
+		var next []*gs.ObjectHandle
+		for i := 0; i < len(sources); i += gcsComposeLimit {
+			end := i + gcsComposeLimit
+			if end > len(sources) {
+				end = len(sources)
+			}
+			temp := bucket.Object(fmt.Sprintf("%scompose-%d-%05d", prefix, level, i/gcsComposeLimit))
+			temps = append(temps, temp)
+			if _, err := g.object(bucket, temp.ObjectName()).ComposerFrom(sources[i:end]...).Run(ctx); err != nil {
+				return err
+			}
+			next = append(next, temp)
+		}
+		sources = next
+	}
+	dst := g.object(bucket, object)
+	composer := o.conditions(dst).ComposerFrom(sources...)
+	composer.ContentType = o.contentTypeOf(object)
+	composer.Metadata = o.metadata
+	attrs, err := composer.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if err := sum.verifyCRC32C(fmt.Sprintf("gs://%s/%s", attrs.Bucket, attrs.Name), attrs.CRC32C); err != nil {
+		// only the generation composed here, a newer one is left alone.
+		dst.If(gs.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+		return err
+	}
+	reader.done()
+	return nil
+}
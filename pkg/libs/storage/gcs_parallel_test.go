@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setTransferParts makes parallel transfers kick in for small files during the test.
+func setTransferParts(t *testing.T, partSize int64, concurrency int) {
+	oldSize, oldConcurrency := TransferPartSize, TransferConcurrency
+	TransferPartSize, TransferConcurrency = partSize, concurrency
+	t.Cleanup(func() {
+		TransferPartSize, TransferConcurrency = oldSize, oldConcurrency
+	})
+}
+
+func TestGCSStorage_ParallelTransfer(t *testing.T) {
+	fake := newFakeGCS(t)
+	setTransferParts(t, 1000, 4)
+	tempDir, err := ioutil.TempDir("", "parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// more parts than one compose takes.
+	data := bytes.Repeat([]byte("0123456789abcdef"), 41*1000/16+7)
+	local := filepath.Join(tempDir, "a.csv")
+	assert.Nil(t, ioutil.WriteFile(local, data, 0640))
+	client := NewGCSStorage(nil)
+	recorder := new(progressRecorder)
+	assert.Nil(t, client.Upload(local, "gs://bucket/in/a.csv", WithMetadata(map[string]string{"source": "a"}), WithProgress(recorder.observe)))
+	assert.Equal(t, int64(len(data)), recorder.reports[len(recorder.reports)-1].Transferred)
+
+	obj, err := client.Stat("gs://bucket/in/a.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(data)), obj.Size)
+	assert.Equal(t, "a", obj.Metadata["source"])
+	objs, _, err := client.ListObjects("gs://bucket/in")
+	assert.Nil(t, err)
+	assert.Len(t, objs, 1, "the parts are removed")
+	fake.Lock()
+	assert.NotEmpty(t, fake.archived)
+	for _, staged := range fake.archived {
+		assert.True(t, strings.HasPrefix(staged.name, TempPrefix), staged.name)
+	}
+	for name := range fake.objects {
+		assert.False(t, strings.Contains(name, TempPrefix), name)
+	}
+	fake.Unlock()
+
+	err = client.Upload(local, "gs://bucket/in/a.csv", IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	downloaded := filepath.Join(tempDir, "b.csv")
+	recorder = new(progressRecorder)
+	assert.Nil(t, client.Download("gs://bucket/in/a.csv", downloaded, WithProgress(recorder.observe)))
+	recorder.check(t, int64(len(data)))
+	got, err := ioutil.ReadFile(downloaded)
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+	assert.False(t, isExist(downloadStatePath(downloaded)))
+
+	fake.corrupt = true
+	err = client.Download("gs://bucket/in/a.csv", downloaded)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), err)
+	assert.False(t, isExist(downloaded))
+}
+
+func TestGCSStorage_ResumeDownload(t *testing.T) {
+	fake := newFakeGCS(t)
+	setTransferParts(t, 1000, 2)
+	tempDir, err := ioutil.TempDir("", "parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	data := []byte(strings.Repeat("a,b,c\n", 1000))
+	obj := fake.put("bucket", "a.csv", data)
+	n := (len(data) + 999) / 1000
+
+	// an interrupted download wrote the first 4 parts.
+	to := filepath.Join(tempDir, "a.csv")
+	partial := append(append([]byte(nil), data[:4000]...), make([]byte, len(data)-4000)...)
+	assert.Nil(t, ioutil.WriteFile(to, partial, 0640))
+	state := &downloadState{Generation: obj.generation, Size: int64(len(data)), PartSize: 1000, Done: make([]bool, n)}
+	for i := 0; i < 4; i++ {
+		state.Done[i] = true
+	}
+	assert.Nil(t, state.save(to))
+
+	requests := fake.requests
+	assert.Nil(t, NewGCSStorage(nil).Download("gs://bucket/a.csv", to))
+	// the attributes and the parts left.
+	assert.Equal(t, 1+n-4, fake.requests-requests)
+	got, err := ioutil.ReadFile(to)
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+
+	// a state of another generation is ignored.
+	state.Generation++
+	assert.Nil(t, state.save(to))
+	requests = fake.requests
+	assert.Nil(t, NewGCSStorage(nil).Download("gs://bucket/a.csv", to))
+	assert.Equal(t, 1+n, fake.requests-requests)
+}
+
+func TestForEachPart(t *testing.T) {
+	setTransferParts(t, 1, 3)
+	var mu sync.Mutex
+	called := map[int]bool{}
+	assert.Nil(t, forEachPart(context.Background(), 10, func(i int) bool { return i%2 == 0 }, func(ctx context.Context, i int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		called[i] = true
+		return nil
+	}))
+	assert.Equal(t, map[int]bool{1: true, 3: true, 5: true, 7: true, 9: true}, called)
+
+	failure := errors.New("failure")
+	started := 0
+	err := forEachPart(context.Background(), 100, func(int) bool { return false }, func(ctx context.Context, i int) error {
+		mu.Lock()
+		started++
+		mu.Unlock()
+		return failure
+	})
+	assert.Equal(t, failure, err)
+	assert.True(t, started < 100)
+}
@@ -1,6 +1,13 @@
 package storage
 
-import "testing"
+import (
+	"crypto/md5"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestGCSStorage_ListChildObjects(t *testing.T) {
 	dir := "gs://lranalytics-au-endpoint-select-vm/721211/REJECT/"
@@ -66,7 +73,7 @@ func TestGCSStorage_RemoveAllObject(t *testing.T) {
 
 func Test_listByPrefix(t *testing.T) {
 	client := NewGCSStorage(nil)
-	objs, _, err := client.listByPrefix("lr-select-vm-us-qa-etl", "", "/", ObjectTypeIsDir)
+	objs, _, err := client.listByPrefix("lr-select-vm-us-qa-etl", "", "/", false, ObjectTypeIsDir)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -76,3 +83,109 @@ func Test_listByPrefix(t *testing.T) {
 	}
 	t.Log(strSlice)
 }
+
+func TestGCSStorage_Emulator(t *testing.T) {
+	f := newFakeGCS(t)
+	client := NewGCSStorage(nil)
+	prefix := "gs://bucket/721211/REJECT"
+	node := client.PathJoin(prefix, "folder", "a.csv")
+
+	assert.Nil(t, client.PutObject(node, []byte(mockContent)))
+	data, err := client.GetObject(node)
+	assert.Nil(t, err)
+	assert.Equal(t, mockContent, string(data))
+
+	assert.Nil(t, client.CopyObject(node, node+".bak"))
+	assert.Nil(t, client.MoveObject(node+".bak", client.PathJoin(prefix, "b.csv")))
+	assert.False(t, client.IsExist(node+".bak"))
+
+	objs, _, err := client.ListChildObjects(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{client.PathJoin(prefix, "b.csv")}, ObjectsToStrings(objs))
+	dirs, err := client.ListDirs(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{prefix + "/folder/"}, dirs)
+
+	w, err := client.OpenWriter(client.PathJoin(prefix, "c.csv"))
+	assert.Nil(t, err)
+	w.Write([]byte(mockContent))
+	assert.Nil(t, w.Close())
+	r, err := client.OpenReader(client.PathJoin(prefix, "c.csv"))
+	assert.Nil(t, err)
+	data, _ = ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, mockContent, string(data))
+
+	_, err = client.OpenReader(client.PathJoin(prefix, "missing.csv"))
+	assert.Equal(t, ErrCodeNoSuchKey, err)
+	assert.True(t, f.requests > 0)
+}
+
+func TestGCSStorage_OpenWriterStaged(t *testing.T) {
+	f := newFakeGCS(t)
+	client := NewGCSStorage(nil)
+	node := "gs://bucket/721211/in/c.csv"
+	assert.Nil(t, client.PutObject(node, []byte("old")))
+	names := func() []string {
+		f.Lock()
+		defer f.Unlock()
+		names := []string{}
+		for name := range f.objects {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	// corrupted data is staged, the live object is left untouched.
+	f.Lock()
+	f.corrupt = true
+	f.Unlock()
+	w, err := client.OpenWriter(node)
+	assert.Nil(t, err)
+	w.Write([]byte(mockContent))
+	err = w.Close()
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "%v", err)
+	f.Lock()
+	f.corrupt = false
+	f.Unlock()
+	data, err := client.GetObject(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(data))
+	assert.Equal(t, []string{"bucket/721211/in/c.csv"}, names())
+
+	w, err = client.OpenWriter(node, WithMetadata(map[string]string{"source": "reject"}))
+	assert.Nil(t, err)
+	w.Write([]byte(mockContent))
+	assert.Nil(t, w.Close())
+	obj, err := client.Stat(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "text/csv; charset=utf-8", obj.ContentType)
+	assert.Equal(t, map[string]string{"source": "reject"}, obj.Metadata)
+	assert.Equal(t, []string{"bucket/721211/in/c.csv"}, names())
+
+	// the md5 known beforehand is checked by gcs itself.
+	w, err = client.OpenWriter(node, WithMD5(md5Sum("other")))
+	assert.Nil(t, err)
+	w.Write([]byte("data"))
+	err = w.Close()
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "%v", err)
+	w, err = client.OpenWriter(node, WithMD5(md5Sum("data")), IfGenerationMatch(obj.Generation))
+	assert.Nil(t, err)
+	w.Write([]byte("data"))
+	assert.Nil(t, w.Close())
+	data, err = client.GetObject(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(data))
+
+	w, err = client.OpenWriter(node, IfNotExist())
+	assert.Nil(t, err)
+	w.Write([]byte("data"))
+	err = w.Close()
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), "%v", err)
+	assert.Equal(t, []string{"bucket/721211/in/c.csv"}, names())
+}
+
+func md5Sum(data string) []byte {
+	sum := md5.Sum([]byte(data))
+	return sum[:]
+}
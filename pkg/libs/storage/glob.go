@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// globMeta are the characters of a pattern of Glob.
+const globMeta = "*?"
+
+// glob is a compiled pattern of Glob.
+type glob struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// compileGlob translates pattern into a regular expression of the whole path:
+// * matches any characters but /, ? one character but /, and ** any number of
+// folders, none included, e.g. a/**/b matches a/b and a/x/y/b.
+func compileGlob(pattern string) (*glob, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("%v glob %s: %v", IllegalPath, pattern, err)
+	}
+	return &glob{pattern: pattern, re: re}, nil
+}
+
+func (g *glob) match(name string) bool {
+	return g.re.MatchString(name)
+}
+
+// dir return the deepest folder of the pattern holding no wildcard, every match is under it.
+func (g *glob) dir() string {
+	static := g.pattern
+	if i := strings.IndexAny(static, globMeta); i >= 0 {
+		static = static[:i]
+	}
+	if i := strings.LastIndex(static, slash); i >= 0 {
+		return static[:i]
+	}
+	return ""
+}
+
+// globWalk serves Glob by walking the folder of the pattern and matching the
+// names of the objects, for the backends listing no pattern themselves.
+func globWalk(s Storage, pattern string, fn WalkFunc) error {
+	g, err := compileGlob(pattern)
+	if err != nil {
+		return err
+	}
+	dir := g.dir()
+	if dir == "" || strings.HasSuffix(dir, ":/") {
+		return fmt.Errorf("%w: glob %s matches buckets", ErrNotSupported, pattern)
+	}
+	err = s.Walk(appendPathSuffix(dir), func(obj *Object) error {
+		if g.match(obj.FileName) {
+			return fn(obj)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		// the local folder of the pattern does not exist.
+		return nil
+	}
+	return err
+}
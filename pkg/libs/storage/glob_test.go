@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileGlob(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		matches []string
+		misses  []string
+	}{
+		{"gs://b/*/REJECT/*.csv", []string{"gs://b/1/REJECT/a.csv"}, []string{"gs://b/1/REJECT/x/a.csv", "gs://b/1/2/REJECT/a.csv", "gs://b/1/REJECT/a.csv.scan"}},
+		{"gs://b/*/REJECT/**/*.csv", []string{"gs://b/1/REJECT/a.csv", "gs://b/1/REJECT/x/y/a.csv"}, []string{"gs://b/1/in/a.csv", "gs://b/1/REJECTa.csv"}},
+		{"gs://b/a?.csv", []string{"gs://b/a1.csv"}, []string{"gs://b/a.csv", "gs://b/a/.csv", "gs://b/a12.csv"}},
+		{"gs://b/in/**", []string{"gs://b/in/a.csv", "gs://b/in/x/a.csv"}, []string{"gs://b/out/a.csv"}},
+		{"/data/a+b(1).csv", []string{"/data/a+b(1).csv"}, []string{"/data/aab(1).csv"}},
+	} {
+		g, err := compileGlob(c.pattern)
+		assert.Nil(t, err)
+		for _, name := range c.matches {
+			assert.True(t, g.match(name), "%s should match %s", c.pattern, name)
+		}
+		for _, name := range c.misses {
+			assert.False(t, g.match(name), "%s should not match %s", c.pattern, name)
+		}
+	}
+	g, _ := compileGlob("gs://b/*/REJECT/**/*.csv")
+	assert.Equal(t, "gs://b", g.dir())
+	g, _ = compileGlob("/data/721211/REJECT/*.csv")
+	assert.Equal(t, "/data/721211/REJECT", g.dir())
+	assert.Equal(t, "1/**.csv", gcsMatchGlob("1/**/*.csv"))
+	assert.Equal(t, "1/**b.csv", gcsMatchGlob("1/**/b.csv"))
+}
+
+// globAll return the objects matched by pattern.
+func globAll(client Storage, pattern string) ([]*Object, error) {
+	objs := make([]*Object, 0)
+	err := client.Glob(pattern, func(obj *Object) error {
+		objs = append(objs, obj)
+		return nil
+	})
+	return objs, err
+}
+
+func doGlobTestCases(t *testing.T, client Storage, root string) {
+	for _, name := range []string{
+		"721211/REJECT/folder/a.csv", "721211/REJECT/folder/deep/b.csv", "721211/REJECT/c.csv",
+		"721211/REJECT/c.txt", "721212/REJECT/d.csv", "721211/in/e.csv",
+	} {
+		assert.Nil(t, client.PutObject(client.PathJoin(root, name), []byte(name)))
+	}
+	glob := func(pattern string) []string {
+		objs, err := globAll(client, root+"/"+pattern)
+		assert.Nil(t, err)
+		names := []string{}
+		for _, obj := range objs {
+			names = append(names, strings.TrimPrefix(obj.FileName, root+"/"))
+		}
+		return names
+	}
+	assert.Equal(t, []string{"721211/REJECT/c.csv", "721211/REJECT/folder/a.csv", "721211/REJECT/folder/deep/b.csv", "721212/REJECT/d.csv"}, glob("*/REJECT/**/*.csv"))
+	assert.Equal(t, []string{"721211/REJECT/folder/a.csv"}, glob("*/REJECT/*/*.csv"))
+	assert.Equal(t, []string{"721211/REJECT/c.csv", "721211/REJECT/c.txt"}, glob("72121?/REJECT/c.*"))
+	assert.Equal(t, []string{"721211/in/e.csv"}, glob("721211/in/e.csv"))
+
+	// the glob stops at the first object when fn returns ErrStopWalk.
+	var first []string
+	assert.Nil(t, client.Glob(root+"/*/REJECT/**/*.csv", func(obj *Object) error {
+		first = append(first, strings.TrimPrefix(obj.FileName, root+"/"))
+		return ErrStopWalk
+	}))
+	assert.Equal(t, []string{"721211/REJECT/c.csv"}, first)
+
+	assert.Equal(t, []string{}, glob("721213/**"))
+}
+
+func TestGlob(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	t.Run("file", func(t *testing.T) {
+		doGlobTestCases(t, NewFileStorage(nil), tempDir)
+	})
+	t.Run("gcs", func(t *testing.T) {
+		fake := newFakeGCS(t)
+		doGlobTestCases(t, NewGCSStorage(nil), "gs://bucket")
+		assert.Equal(t, "721213/**", fake.matchGlob)
+	})
+	t.Run("mem", func(t *testing.T) {
+		doGlobTestCases(t, NewMemStorage(nil), "mem://glob")
+	})
+	_, err = globAll(NewGCSStorage(nil), "gs://bucket-*/a.csv")
+	assert.True(t, errors.Is(err, ErrNotSupported), err)
+	_, err = globAll(NewMemStorage(nil), "mem://*/a.csv")
+	assert.True(t, errors.Is(err, ErrNotSupported), err)
+}
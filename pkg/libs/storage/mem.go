@@ -0,0 +1,470 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memStore holds the objects of every MemStorage in the process, keyed by bucket/key.
+var memStore = &memObjects{objects: map[string]*memObject{}}
+
+type memObjects struct {
+	sync.RWMutex
+	objects    map[string]*memObject
+	generation int64
+}
+
+type memObject struct {
+	data        []byte
+	sum         string
+	created     time.Time
+	updated     time.Time
+	contentType string
+	generation  int64
+	metadata    map[string]string
+}
+
+// MemStorage is storage kept in memory, for tests and dry runs.
+// Every client shares the objects of the process, as if they were one remote bucket service.
+type MemStorage struct {
+	opContext
+	protocol string
+}
+
+func init() {
+	Register(Backend{
+		Type:   StorageInMemory,
+		Name:   "memory",
+		Scheme: "mem://",
+		New:    func(opts map[string]interface{}) Storage { return NewMemStorage(opts) },
+	})
+}
+
+// NewMemStorage return a new in-memory storage client
+func NewMemStorage(opts map[string]interface{}) *MemStorage {
+	return &MemStorage{protocol: StorageInMemory.Protocol()}
+}
+
+// WithContext return a copy of the client whose calls are bound to ctx
+func (m *MemStorage) WithContext(ctx context.Context) Storage {
+	c := *m
+	c.ctx = ctx
+	return &c
+}
+
+func (m *MemStorage) PathJoin(items ...string) string {
+	if len(items) <= 0 {
+		return ""
+	}
+	items[0] = strings.Replace(items[0], m.protocol, "", 1)
+	return m.protocol + path.Join(items...)
+}
+
+// GetObject return a data object by node.
+func (m *MemStorage) GetObject(node string) ([]byte, error) {
+	obj, err := m.get(node)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), obj.data...), nil
+}
+
+// PutObject save a data object via node.
+func (m *MemStorage) PutObject(node string, data []byte, options ...WriteOption) error {
+	if err := m.err(); err != nil {
+		return err
+	}
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	return m.put(opts.Bucket+slash+opts.Key, append([]byte(nil), data...), newWriteOptions(options))
+}
+
+// RemoveObject remove a data object via node.
+func (m *MemStorage) RemoveObject(node string, options ...WriteOption) error {
+	if err := m.err(); err != nil {
+		return err
+	}
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	return m.delete(opts.Bucket+slash+opts.Key, newWriteOptions(options))
+}
+
+// RemoveDir remove a folder.
+func (m *MemStorage) RemoveDir(node string) error {
+	if err := m.err(); err != nil {
+		return err
+	}
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	return m.delete(opts.Bucket+slash+opts.Prefix, new(writeOptions))
+}
+
+// RemoveAll remove every object under the folder
+func (m *MemStorage) RemoveAll(dir string) error {
+	if err := m.err(); err != nil {
+		return err
+	}
+	opts, err := parseObj(dir)
+	if err != nil {
+		return err
+	}
+	prefix := opts.Bucket + slash + appendPathSuffix(opts.Key)
+	memStore.Lock()
+	defer memStore.Unlock()
+	for name := range memStore.objects {
+		if strings.HasPrefix(name, prefix) {
+			delete(memStore.objects, name)
+		}
+	}
+	return nil
+}
+
+// CopyObject backup this object, with its content type and metadata, dst must
+// satisfy the preconditions of options.
+func (m *MemStorage) CopyObject(src, dst string, options ...WriteOption) error {
+	obj, err := m.get(src)
+	if err != nil {
+		return err
+	}
+	options = append([]WriteOption{WithContentType(obj.contentType), WithMetadata(obj.metadata)}, options...)
+	return m.PutObject(dst, obj.data, options...)
+}
+
+// MoveObject rename this object
+func (m *MemStorage) MoveObject(src, dst string) error {
+	if err := m.CopyObject(src, dst); err != nil {
+		return err
+	}
+	return m.RemoveObject(src)
+}
+
+// CopyPrefix copy every object under the folder from into the folder to
+func (m *MemStorage) CopyPrefix(from, to string) error {
+	return bucketPrefixOp(m.context(), m, "copy", from, to, func(src, dst string) error {
+		return m.CopyObject(src, dst)
+	})
+}
+
+// MovePrefix move every object under the folder from into the folder to
+func (m *MemStorage) MovePrefix(from, to string) error {
+	return bucketPrefixOp(m.context(), m, "move", from, to, m.MoveObject)
+}
+
+// IsExist return false if node doesn't exist
+func (m *MemStorage) IsExist(node string) bool {
+	_, err := m.get(node)
+	return err == nil
+}
+
+// Stat return the attributes and the custom metadata of the object
+func (m *MemStorage) Stat(node string) (*Object, error) {
+	obj, err := m.get(node)
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]string{}
+	for k, v := range obj.metadata {
+		metadata[k] = v
+	}
+	return &Object{
+		FileName:    node,
+		Size:        int64(len(obj.data)),
+		ModTime:     obj.updated.Unix(),
+		Sum:         obj.sum,
+		Created:     obj.created,
+		Updated:     obj.updated,
+		ContentType: obj.contentType,
+		Generation:  obj.generation,
+		Metadata:    metadata,
+	}, nil
+}
+
+// ListObjects return all files via prefix dir
+func (m *MemStorage) ListObjects(dir string) ([]*Object, int64, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	return m.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "", ObjectTypeIsObject)
+}
+
+func (m *MemStorage) ListChildObjects(dir string) ([]*Object, int64, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	return m.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", ObjectTypeIsObject)
+}
+
+// ListDirs return all dirs via prefix dir
+func (m *MemStorage) ListDirs(dir string) ([]string, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, err
+	}
+	objs, _, err := m.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", ObjectTypeIsDir)
+	return ObjectsToStrings(objs), err
+}
+
+// listByPrefix follows the rules of GCSStorage.listByPrefix, a name holding the
+// delimiter after the prefix is folded into its folder.
+func (m *MemStorage) listByPrefix(bucket, prefix, delim string, types ...ObjectType) ([]*Object, int64, error) {
+	if err := m.err(); err != nil {
+		return nil, 0, err
+	}
+	var dirType, objectType bool
+	for _, v := range types {
+		if v == ObjectTypeIsDir {
+			dirType = true
+		}
+		if v == ObjectTypeIsObject {
+			objectType = true
+		}
+	}
+
+	memStore.RLock()
+	defer memStore.RUnlock()
+	keys := make([]string, 0)
+	for name := range memStore.objects {
+		if key := strings.TrimPrefix(name, bucket+slash); key != name && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	objs := make([]*Object, 0)
+	var size int64
+	dirs := map[string]bool{}
+	for _, key := range keys {
+		if i := strings.Index(key[len(prefix):], delim); delim != "" && i >= 0 {
+			dir := key[:len(prefix)+i+len(delim)]
+			if dirType && !dirs[dir] {
+				objs = append(objs, &Object{FileName: fmt.Sprintf("%s%s/%s", m.protocol, bucket, dir)})
+			}
+			dirs[dir] = true
+			continue
+		}
+		obj := memStore.objects[bucket+slash+key]
+		size += int64(len(obj.data))
+		if objectType {
+			objs = append(objs, &Object{
+				FileName: fmt.Sprintf("%s%s/%s", m.protocol, bucket, key),
+				Size:     int64(len(obj.data)),
+				Sum:      obj.sum,
+				Created:  obj.created,
+				Updated:  obj.updated,
+			})
+		}
+	}
+	return objs, size, nil
+}
+
+// ListPage return a page of the objects under the folder dir, the page token is
+// the key of the last object of the previous page.
+func (m *MemStorage) ListPage(dir, pageToken string, pageSize int) ([]*Object, string, error) {
+	if err := m.err(); err != nil {
+		return nil, "", err
+	}
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix := appendPathSuffix(opts.Key)
+
+	memStore.RLock()
+	defer memStore.RUnlock()
+	keys := make([]string, 0)
+	for name := range memStore.objects {
+		if key := strings.TrimPrefix(name, opts.Bucket+slash); key != name && strings.HasPrefix(key, prefix) && key > pageToken {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var next string
+	if len(keys) > pageSize {
+		keys = keys[:pageSize]
+		next = keys[pageSize-1]
+	}
+	objs := make([]*Object, 0, len(keys))
+	for _, key := range keys {
+		obj := memStore.objects[opts.Bucket+slash+key]
+		objs = append(objs, &Object{
+			FileName: fmt.Sprintf("%s%s/%s", m.protocol, opts.Bucket, key),
+			Size:     int64(len(obj.data)),
+			Sum:      obj.sum,
+			Created:  obj.created,
+			Updated:  obj.updated,
+		})
+	}
+	return objs, next, nil
+}
+
+// Walk calls fn for every object under the folder dir
+func (m *MemStorage) Walk(dir string, fn WalkFunc) error {
+	return walk(m.context(), m, dir, fn)
+}
+
+// Glob calls fn for the objects matching pattern, under the folder of the pattern.
+func (m *MemStorage) Glob(pattern string, fn WalkFunc) error {
+	return globWalk(m, pattern, fn)
+}
+
+// ListVersions fails with ErrNotSupported, the memory keeps the live objects only.
+func (m *MemStorage) ListVersions(node string) ([]*Object, error) {
+	return nil, fmt.Errorf("%w: versions of %s", ErrNotSupported, node)
+}
+
+// RestoreVersion fails with ErrNotSupported, see ListVersions.
+func (m *MemStorage) RestoreVersion(node string, generation int64) error {
+	return fmt.Errorf("%w: versions of %s", ErrNotSupported, node)
+}
+
+// SignURL fails with ErrNotSupported, the memory is not reachable from outside the process.
+func (m *MemStorage) SignURL(node, method string, expiry time.Duration) (string, error) {
+	return "", fmt.Errorf("%w: signed url of %s", ErrNotSupported, node)
+}
+
+// Download download file to local
+func (m *MemStorage) Download(from, to string, options ...WriteOption) error {
+	data, err := m.GetObject(from)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(to, data, 0750); err != nil {
+		return err
+	}
+	reportCopied(newWriteOptions(options).progress, len(data))
+	return nil
+}
+
+// Upload put file to remote
+func (m *MemStorage) Upload(from, to string, options ...WriteOption) error {
+	data, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	if err := m.PutObject(to, data, options...); err != nil {
+		return err
+	}
+	reportCopied(newWriteOptions(options).progress, len(data))
+	return nil
+}
+
+// reportCopied reports a transfer of n bytes done at once.
+func reportCopied(fn ProgressFunc, n int) {
+	p := newProgress(fn, int64(n))
+	p.add(n)
+	p.done()
+}
+
+// OpenReader return a stream of the object
+func (m *MemStorage) OpenReader(node string) (io.ReadCloser, error) {
+	data, err := m.GetObject(node)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(&ctxReader{ctx: m.context(), r: bytes.NewReader(data)}), nil
+}
+
+// OpenWriter return a stream writing into the object, the object is created on Close
+func (m *MemStorage) OpenWriter(node string, options ...WriteOption) (io.WriteCloser, error) {
+	if err := m.err(); err != nil {
+		return nil, err
+	}
+	if _, err := parseObj(node); err != nil {
+		return nil, err
+	}
+	return &memWriter{storage: m, node: node, options: options}, nil
+}
+
+type memWriter struct {
+	bytes.Buffer
+	storage *MemStorage
+	node    string
+	options []WriteOption
+}
+
+func (w *memWriter) Close() error {
+	return w.storage.PutObject(w.node, w.Bytes(), w.options...)
+}
+
+func (w *memWriter) CloseWithError(err error) error {
+	w.Reset()
+	return nil
+}
+
+func (m *MemStorage) get(node string) (*memObject, error) {
+	if err := m.err(); err != nil {
+		return nil, err
+	}
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	memStore.RLock()
+	defer memStore.RUnlock()
+	obj, ok := memStore.objects[opts.Bucket+slash+opts.Key]
+	if !ok {
+		return nil, ErrCodeNoSuchKey
+	}
+	return obj, nil
+}
+
+func (m *MemStorage) put(name string, data []byte, o *writeOptions) error {
+	now := time.Now()
+	obj := &memObject{
+		data:        data,
+		sum:         fmt.Sprintf("%x", md5.Sum(data)),
+		created:     now,
+		updated:     now,
+		contentType: o.contentTypeOf(name),
+		metadata:    o.metadata,
+	}
+	memStore.Lock()
+	defer memStore.Unlock()
+	if err := o.checkPrecondition(name, memStore.objects[name].gen()); err != nil {
+		return err
+	}
+	memStore.generation++
+	obj.generation = memStore.generation
+	memStore.objects[name] = obj
+	return nil
+}
+
+func (m *MemStorage) delete(name string, o *writeOptions) error {
+	memStore.Lock()
+	defer memStore.Unlock()
+	obj, ok := memStore.objects[name]
+	if !ok {
+		return ErrCodeNoSuchKey
+	}
+	if err := o.checkPrecondition(name, obj.gen()); err != nil {
+		return err
+	}
+	delete(memStore.objects, name)
+	return nil
+}
+
+// gen return the generation of the object, 0 if there is none.
+func (o *memObject) gen() int64 {
+	if o == nil {
+		return 0
+	}
+	return o.generation
+}
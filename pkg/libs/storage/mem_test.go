@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemStorage(t *testing.T) {
+	client, err := NewStorageClient("mem://mem-storage/a.csv", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "mem://mem-storage/folder/a.csv", client.PathJoin("mem://mem-storage", "folder", "a.csv"))
+
+	assert.Nil(t, client.PutObject("mem://mem-storage/folder/a.csv", []byte("hello")))
+	assert.True(t, client.IsExist("mem://mem-storage/folder/a.csv"))
+	assert.False(t, client.IsExist("mem://other-bucket/folder/a.csv"))
+	data, err := client.GetObject("mem://mem-storage/folder/a.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	objs, size, err := client.ListObjects("mem://mem-storage/folder")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+	assert.Equal(t, 1, len(objs))
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte("hello"))), objs[0].Sum)
+	assert.False(t, objs[0].Created.IsZero())
+
+	assert.Nil(t, client.MoveObject("mem://mem-storage/folder/a.csv", "mem://mem-storage/folder/b.csv"))
+	_, err = client.GetObject("mem://mem-storage/folder/a.csv")
+	assert.Equal(t, ErrCodeNoSuchKey, err)
+	assert.Equal(t, ErrCodeNoSuchKey, client.RemoveObject("mem://mem-storage/folder/a.csv"))
+
+	tempDir, err := ioutil.TempDir("", "memStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	local := filepath.Join(tempDir, "b.csv")
+	assert.Nil(t, client.Download("mem://mem-storage/folder/b.csv", local))
+	assert.Nil(t, client.Upload(local, "mem://mem-storage/folder/c.csv"))
+	data, err = client.GetObject("mem://mem-storage/folder/c.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	w, err := client.OpenWriter("mem://mem-storage/stream.csv")
+	assert.Nil(t, err)
+	w.Write([]byte("partial"))
+	assert.False(t, client.IsExist("mem://mem-storage/stream.csv"))
+	AbortWriter(w, context.Canceled)
+	assert.False(t, client.IsExist("mem://mem-storage/stream.csv"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, client.WithContext(ctx).PutObject("mem://mem-storage/d.csv", nil))
+}
+
+// TestMemStorage_ListingRules compares the listings of MemStorage with the ones of GCSStorage.
+func TestMemStorage_ListingRules(t *testing.T) {
+	newFakeGCS(t)
+	gcs, mem := NewGCSStorage(nil), NewMemStorage(nil)
+	names := []string{"721211/REJECT/", "721211/REJECT/a.csv", "721211/REJECT/folder/b.csv",
+		"721211/REJECT/folder/deep/c.csv", "721211/REJECT2/d.csv", "721211/e.csv"}
+	for _, name := range names {
+		assert.Nil(t, gcs.PutObject("gs://listing-rules/"+name, []byte(name)))
+		assert.Nil(t, mem.PutObject("mem://listing-rules/"+name, []byte(name)))
+	}
+	swap := func(files []string) []string {
+		for i := range files {
+			files[i] = strings.Replace(files[i], "gs://", "mem://", 1)
+		}
+		return files
+	}
+	fileNames := func(objs []*Object, _ int64, err error) []string {
+		assert.Nil(t, err)
+		return swap(ObjectsToStrings(objs))
+	}
+	for _, dir := range []string{"721211", "721211/REJECT", "721211/REJECT/", "721211/REJECT/folder", "721211/none"} {
+		assert.Equal(t, fileNames(gcs.ListObjects("gs://listing-rules/"+dir)), fileNames(mem.ListObjects("mem://listing-rules/"+dir)), dir)
+		assert.Equal(t, fileNames(gcs.ListChildObjects("gs://listing-rules/"+dir)), fileNames(mem.ListChildObjects("mem://listing-rules/"+dir)), dir)
+
+		gcsDirs, err := gcs.ListDirs("gs://listing-rules/" + dir)
+		assert.Nil(t, err)
+		memDirs, err := mem.ListDirs("mem://listing-rules/" + dir)
+		assert.Nil(t, err)
+		assert.Equal(t, swap(gcsDirs), memDirs, dir)
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+)
+
+// ErrPreconditionFailed is returned, wrapped, when the object does not satisfy
+// the precondition of a call, see IfNotExist and IfGenerationMatch.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// WriteOption sets an option of PutObject, Upload and OpenWriter. CopyObject and
+// RemoveObject take the preconditions only, Download takes WithProgress only.
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	contentType  string
+	metadata     map[string]string
+	ifNotExist   bool
+	ifGeneration *int64
+	progress     ProgressFunc
+	md5          []byte
+}
+
+// WithMetadata attaches custom metadata to the object written, e.g. its provenance.
+// Keys are best kept lower case, s3 does not preserve their case.
+func WithMetadata(metadata map[string]string) WriteOption {
+	return func(o *writeOptions) {
+		if o.metadata == nil {
+			o.metadata = map[string]string{}
+		}
+		for k, v := range metadata {
+			o.metadata[k] = v
+		}
+	}
+}
+
+// WithContentType sets the content type of the object written, it is guessed
+// from the name of the object otherwise.
+func WithContentType(contentType string) WriteOption {
+	return func(o *writeOptions) {
+		o.contentType = contentType
+	}
+}
+
+// WithMD5 sets the md5 of the data written by OpenWriter, when it is known
+// beforehand, so that gcs, s3 and azure reject other data.
+func WithMD5(sum []byte) WriteOption {
+	return func(o *writeOptions) {
+		o.md5 = sum
+	}
+}
+
+func newWriteOptions(opts []WriteOption) *writeOptions {
+	o := new(writeOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// contentTypeOf return the content type set by the options or the one of the extension of node.
+func (o *writeOptions) contentTypeOf(node string) string {
+	if o.contentType != "" {
+		return o.contentType
+	}
+	if contentType := mime.TypeByExtension(path.Ext(node)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// IfNotExist makes the write fail with ErrPreconditionFailed if the object exists.
+func IfNotExist() WriteOption {
+	return func(o *writeOptions) {
+		o.ifNotExist = true
+	}
+}
+
+// IfGenerationMatch makes the call fail with ErrPreconditionFailed unless the
+// object is at generation, as returned by Stat.
+func IfGenerationMatch(generation int64) WriteOption {
+	return func(o *writeOptions) {
+		o.ifGeneration = &generation
+	}
+}
+
+// hasPrecondition return true if the call depends on the state of the object.
+func (o *writeOptions) hasPrecondition() bool {
+	return o.ifNotExist || o.ifGeneration != nil
+}
+
+// checkPrecondition return ErrPreconditionFailed if an object of generation, 0
+// when it does not exist, does not satisfy the preconditions.
+func (o *writeOptions) checkPrecondition(node string, generation int64) error {
+	if o.ifNotExist && generation != 0 {
+		return fmt.Errorf("%w: %s exists", ErrPreconditionFailed, node)
+	}
+	if o.ifGeneration != nil && *o.ifGeneration != generation {
+		return fmt.Errorf("%w: %s is at generation %d, want %d", ErrPreconditionFailed, node, generation, *o.ifGeneration)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func doPreconditionTestCases(t *testing.T, client Storage, root string) {
+	dir := client.PathJoin(root, "721211", "precondition")
+	node := client.PathJoin(dir, "a.csv")
+
+	assert.Nil(t, client.PutObject(node, []byte("1"), IfNotExist()))
+	err := client.PutObject(node, []byte("2"), IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+	data, err := client.GetObject(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(data))
+
+	obj, err := client.Stat(node)
+	assert.Nil(t, err)
+	assert.Nil(t, client.PutObject(node, []byte("2"), IfGenerationMatch(obj.Generation)))
+	// the generation changed with the write.
+	err = client.PutObject(node, []byte("3"), IfGenerationMatch(obj.Generation))
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+	err = client.RemoveObject(node, IfGenerationMatch(obj.Generation))
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+	assert.True(t, client.IsExist(node))
+
+	copied := client.PathJoin(dir, "b.csv")
+	assert.Nil(t, client.CopyObject(node, copied, IfNotExist()))
+	err = client.CopyObject(node, copied, IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	w, err := client.OpenWriter(copied, IfNotExist())
+	assert.Nil(t, err)
+	w.Write([]byte("4"))
+	err = w.Close()
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	local, err := ioutil.TempFile("", "precondition")
+	assert.Nil(t, err)
+	defer os.Remove(local.Name())
+	local.Close()
+	err = client.Upload(local.Name(), copied, IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	obj, err = client.Stat(node)
+	assert.Nil(t, err)
+	assert.Nil(t, client.RemoveObject(node, IfGenerationMatch(obj.Generation)))
+	assert.False(t, client.IsExist(node))
+	data, err = client.GetObject(copied)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(data))
+}
+
+func TestPreconditions(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	t.Run("file", func(t *testing.T) {
+		doPreconditionTestCases(t, NewFileStorage(nil), tempDir)
+	})
+	t.Run("gcs", func(t *testing.T) {
+		newFakeGCS(t)
+		doPreconditionTestCases(t, NewGCSStorage(nil), "gs://bucket")
+	})
+	t.Run("mem", func(t *testing.T) {
+		doPreconditionTestCases(t, NewMemStorage(nil), "mem://precondition")
+	})
+}
+
+func TestS3Storage_Preconditions(t *testing.T) {
+	_, srv := newFakeS3(t)
+	client := newTestS3Storage(srv.URL)
+	assert.Nil(t, client.PutObject("s3://bucket/a.csv", []byte("1"), IfNotExist()))
+	err := client.PutObject("s3://bucket/a.csv", []byte("2"), IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	w, err := client.OpenWriter("s3://bucket/a.csv", IfNotExist())
+	assert.Nil(t, err)
+	w.Write([]byte("3"))
+	assert.True(t, errors.Is(w.Close(), ErrPreconditionFailed))
+
+	assert.True(t, errors.Is(client.PutObject("s3://bucket/a.csv", nil, IfGenerationMatch(1)), ErrNotSupported))
+	assert.True(t, errors.Is(client.RemoveObject("s3://bucket/a.csv", IfGenerationMatch(1)), ErrNotSupported))
+	assert.True(t, errors.Is(client.CopyObject("s3://bucket/a.csv", "s3://bucket/b.csv", IfNotExist()), ErrNotSupported))
+}
+
+func TestFileStorage_ConcurrentCreate(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	client := NewFileStorage(nil)
+	node := client.PathJoin(tempDir, "lease.json")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := client.PutObject(node, []byte(fmt.Sprint(i)), IfNotExist())
+			if err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			} else {
+				assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, created)
+	assert.False(t, client.IsExist(fileLockPath(node)))
+}
+
+func TestFileStorage_LockRefreshed(t *testing.T) {
+	defer func(stale time.Duration) { fileStaleLock = stale }(fileStaleLock)
+	fileStaleLock = 40 * time.Millisecond
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	node := filepath.Join(tempDir, "lease.json")
+
+	unlock, err := lockFile(context.Background(), node)
+	assert.NoError(t, err)
+	// held longer than fileStaleLock, the lock is not taken over.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*fileStaleLock)
+	defer cancel()
+	_, err = lockFile(ctx, node)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	unlock()
+	assert.False(t, isExist(fileLockPath(node)))
+	unlock, err = lockFile(context.Background(), node)
+	assert.NoError(t, err)
+	unlock()
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress reports how far a transfer has got.
+type Progress struct {
+	Transferred int64
+	// Total is the size of the transfer, -1 if it is unknown.
+	Total int64
+	// Rate is the average of the bytes transferred per second.
+	Rate    float64
+	Elapsed time.Duration
+}
+
+// Percent return the part of Total transferred, -1 if Total is unknown.
+func (p Progress) Percent() float64 {
+	if p.Total < 0 {
+		return -1
+	}
+	if p.Total == 0 {
+		return 100
+	}
+	return float64(p.Transferred) * 100 / float64(p.Total)
+}
+
+// ProgressFunc observes a transfer, it is called at most once per ProgressInterval
+// and once more when the transfer completes.
+type ProgressFunc func(p Progress)
+
+// ProgressInterval is the least time between two reports of a transfer.
+var ProgressInterval = time.Second
+
+// WithProgress reports the progress of Download and Upload to fn.
+func WithProgress(fn ProgressFunc) WriteOption {
+	return func(o *writeOptions) {
+		o.progress = fn
+	}
+}
+
+// progress counts the bytes of a transfer and reports them to its ProgressFunc.
+// A nil progress counts nothing.
+type progress struct {
+	sync.Mutex
+	fn          ProgressFunc
+	total       int64
+	transferred int64
+	start       time.Time
+	reported    time.Time
+}
+
+func newProgress(fn ProgressFunc, total int64) *progress {
+	if fn == nil {
+		return nil
+	}
+	now := time.Now()
+	return &progress{fn: fn, total: total, start: now, reported: now}
+}
+
+func (p *progress) add(n int) {
+	if p == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&p.transferred, int64(n))
+	p.Lock()
+	defer p.Unlock()
+	if time.Since(p.reported) >= ProgressInterval {
+		p.report()
+	}
+}
+
+// done reports the end of the transfer.
+func (p *progress) done() {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.report()
+}
+
+func (p *progress) report() {
+	p.reported = time.Now()
+	elapsed := p.reported.Sub(p.start)
+	transferred := atomic.LoadInt64(&p.transferred)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(transferred) / elapsed.Seconds()
+	}
+	p.fn(Progress{Transferred: transferred, Total: p.total, Rate: rate, Elapsed: elapsed})
+}
+
+// progressWriter counts the bytes written through it.
+type progressWriter struct {
+	w        io.Writer
+	progress *progress
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.progress.add(n)
+	return n, err
+}
+
+// progressReader counts the bytes read through it.
+type progressReader struct {
+	r        io.Reader
+	progress *progress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.progress.add(n)
+	if err == io.EOF {
+		r.progress.done()
+	}
+	return n, err
+}
+
+// NewProgressReader return r reporting the bytes read to fn, total is the size
+// of the stream or -1. The last report is made when r reaches io.EOF.
+func NewProgressReader(r io.Reader, total int64, fn ProgressFunc) io.Reader {
+	if fn == nil {
+		return r
+	}
+	return &progressReader{r: r, progress: newProgress(fn, total)}
+}
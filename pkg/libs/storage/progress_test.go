@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// progressRecorder keeps the reports of a transfer.
+type progressRecorder struct {
+	sync.Mutex
+	reports []Progress
+}
+
+func (r *progressRecorder) observe(p Progress) {
+	r.Lock()
+	defer r.Unlock()
+	r.reports = append(r.reports, p)
+}
+
+func (r *progressRecorder) check(t *testing.T, size int64) {
+	if !assert.NotEmpty(t, r.reports) {
+		return
+	}
+	for i := 1; i < len(r.reports); i++ {
+		assert.True(t, r.reports[i].Transferred >= r.reports[i-1].Transferred)
+	}
+	last := r.reports[len(r.reports)-1]
+	assert.Equal(t, size, last.Transferred)
+	assert.Equal(t, size, last.Total)
+	assert.Equal(t, float64(100), last.Percent())
+}
+
+func doProgressTestCases(t *testing.T, client Storage, root string) {
+	interval := ProgressInterval
+	defer func() { ProgressInterval = interval }()
+	ProgressInterval = 0
+
+	tempDir, err := ioutil.TempDir("", "progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	data := bytes.Repeat([]byte("a,b,c\n"), 100000)
+	local := filepath.Join(tempDir, "a.csv")
+	assert.Nil(t, ioutil.WriteFile(local, data, 0640))
+
+	node := client.PathJoin(root, "721211", "progress", "a.csv")
+	uploaded := new(progressRecorder)
+	assert.Nil(t, client.Upload(local, node, WithProgress(uploaded.observe)))
+	uploaded.check(t, int64(len(data)))
+
+	downloaded := new(progressRecorder)
+	assert.Nil(t, client.Download(node, filepath.Join(tempDir, "b.csv"), WithProgress(downloaded.observe)))
+	downloaded.check(t, int64(len(data)))
+}
+
+func TestProgress(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	t.Run("file", func(t *testing.T) {
+		doProgressTestCases(t, NewFileStorage(nil), tempDir)
+	})
+	t.Run("gcs", func(t *testing.T) {
+		newFakeGCS(t)
+		doProgressTestCases(t, NewGCSStorage(nil), "gs://bucket")
+	})
+	t.Run("s3", func(t *testing.T) {
+		_, srv := newFakeS3(t)
+		doProgressTestCases(t, newTestS3Storage(srv.URL), "s3://bucket")
+	})
+	t.Run("mem", func(t *testing.T) {
+		doProgressTestCases(t, NewMemStorage(nil), "mem://progress")
+	})
+}
+
+func TestNewProgressReader(t *testing.T) {
+	recorder := new(progressRecorder)
+	data, err := ioutil.ReadAll(NewProgressReader(strings.NewReader("abc"), 3, recorder.observe))
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", string(data))
+	recorder.check(t, 3)
+
+	recorder = new(progressRecorder)
+	ioutil.ReadAll(NewProgressReader(strings.NewReader("abc"), -1, recorder.observe))
+	assert.Equal(t, float64(-1), recorder.reports[len(recorder.reports)-1].Percent())
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Backend describes a storage backend, each backend registers itself for the
+// scheme of its urls, see Register.
+type Backend struct {
+	Type StorageType
+	// Name labels the backend in the configuration and the metrics, e.g. "gcp".
+	Name string
+	// Scheme prefixes the urls of the backend, e.g. "gs://". The backend with
+	// an empty scheme takes the paths of no registered scheme.
+	Scheme string
+	// DecodeOptions turns the credentials given to NewStorageClient into the options of New.
+	DecodeOptions func(credentials string) (map[string]interface{}, error)
+	New           func(opts map[string]interface{}) Storage
+}
+
+var backends = struct {
+	sync.RWMutex
+	byType map[StorageType]*Backend
+}{byType: map[StorageType]*Backend{}}
+
+// Register makes a backend available to NewStorage and NewStorageClient, it
+// panics if the type or the scheme of the backend is already registered.
+func Register(b Backend) {
+	backends.Lock()
+	defer backends.Unlock()
+	for _, registered := range backends.byType {
+		if registered.Type == b.Type || registered.Scheme == b.Scheme {
+			panic(fmt.Sprintf("storage: backend %s registered twice", b.Name))
+		}
+	}
+	if b.DecodeOptions == nil {
+		b.DecodeOptions = NoOptions
+	}
+	backends.byType[b.Type] = &b
+}
+
+// StorageTypes return the types of the registered backends in order.
+func StorageTypes() []StorageType {
+	backends.RLock()
+	defer backends.RUnlock()
+	types := make([]StorageType, 0, len(backends.byType))
+	for t := range backends.byType {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func backendOf(t StorageType) (*Backend, bool) {
+	backends.RLock()
+	defer backends.RUnlock()
+	b, ok := backends.byType[t]
+	return b, ok
+}
+
+// backendFor return the backend of the longest scheme prefixing node.
+func backendFor(node string) (*Backend, error) {
+	backends.RLock()
+	defer backends.RUnlock()
+	var found *Backend
+	for _, b := range backends.byType {
+		if strings.HasPrefix(node, b.Scheme) && (found == nil || len(b.Scheme) > len(found.Scheme)) {
+			found = b
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: no storage backend for %s", ErrNotSupported, node)
+	}
+	return found, nil
+}
+
+// IsLocal return true if node is a path of the local file system.
+func IsLocal(node string) bool {
+	b, err := backendFor(node)
+	return err == nil && b.Type == StorageInLocal
+}
+
+// NoOptions is the options decoder of the backends which take no credentials.
+func NoOptions(credentials string) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// JSONOptions return the options decoder of credentials given as a json object,
+// the values of keys must be strings. Empty credentials decode to no options.
+func JSONOptions(keys ...string) func(credentials string) (map[string]interface{}, error) {
+	return func(credentials string) (map[string]interface{}, error) {
+		if strings.TrimSpace(credentials) == "" {
+			return nil, nil
+		}
+		var opts map[string]interface{}
+		if err := json.Unmarshal([]byte(credentials), &opts); err != nil {
+			return nil, fmt.Errorf("storage: malformed credentials: %w", err)
+		}
+		for _, key := range keys {
+			if v, ok := opts[key]; ok {
+				if _, ok := v.(string); !ok {
+					return nil, fmt.Errorf("storage: malformed credentials: %s is not a string", key)
+				}
+			}
+		}
+		return opts, nil
+	}
+}
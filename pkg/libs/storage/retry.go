@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy tells how often and how long a failed storage call is retried.
+type RetryPolicy struct {
+	// MaxAttempts counts the first call, 1 or less means no retry.
+	MaxAttempts int
+	// InitialBackoff is the longest wait before the first retry, it doubles on
+	// every retry up to MaxBackoff. The actual wait is a random part of it.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Budget bounds the time spent in the attempts of one call, zero means no bound.
+	Budget time.Duration
+}
+
+// RetryPolicies holds the policy of each storage type, NewStorage wraps the
+// clients of the types with a policy into a RetryStorage.
+var RetryPolicies = map[StorageType]RetryPolicy{}
+
+// Counts of the retried calls and of the calls given up on, keyed by storage type and operation.
+var (
+	retryCount  = expvar.NewMap("storage_retries")
+	giveUpCount = expvar.NewMap("storage_retry_giveups")
+)
+
+// RetryStorage retries the calls of a Storage failing with a transient error,
+// see IsRetryable.
+type RetryStorage struct {
+	Storage
+	opContext
+	name   string
+	policy RetryPolicy
+}
+
+// NewRetryStorage return s retrying its calls by policy, name labels the counts of retries and give-ups.
+func NewRetryStorage(s Storage, name string, policy RetryPolicy) *RetryStorage {
+	return &RetryStorage{Storage: s, name: name, policy: policy}
+}
+
+// WithContext return a copy of the storage whose calls and waits are bound to ctx
+func (r *RetryStorage) WithContext(ctx context.Context) Storage {
+	return &RetryStorage{Storage: r.Storage.WithContext(ctx), opContext: opContext{ctx}, name: r.name, policy: r.policy}
+}
+
+// IsRetryable return true if err is likely transient: a 408, 429 or 5xx response,
+// a timeout of a single call, a broken connection, or corrupted data.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var prefixErr *PrefixError
+	if errors.As(err, &prefixErr) {
+		for _, e := range prefixErr.Failed {
+			if IsRetryable(e) {
+				return true
+			}
+		}
+		return false
+	}
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) {
+		return retryableStatus(gcsErr.Code)
+	}
+	var s3Err *S3Error
+	if errors.As(err, &s3Err) {
+		return retryableStatus(s3Err.StatusCode)
+	}
+	var azErr *AzureError
+	if errors.As(err, &azErr) {
+		return retryableStatus(azErr.StatusCode)
+	}
+	// a lost sftp session, the next attempt dials again.
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || err == io.EOF {
+		return true
+	}
+	if errors.Is(err, ErrChecksumMismatch) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// retry calls fn until it succeeds, fails with an error that is not retryable,
+// or the attempts or the budget of the policy run out.
+func (r *RetryStorage) retry(op string, fn func() error) error {
+	ctx := r.context()
+	start := time.Now()
+	backoff := r.policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= r.policy.MaxAttempts {
+			giveUpCount.Add(r.name+"."+op, 1)
+			return err
+		}
+		var wait time.Duration
+		if backoff > 0 {
+			wait = time.Duration(rand.Int63n(int64(backoff)) + 1)
+		}
+		if r.policy.Budget > 0 && time.Since(start)+wait > r.policy.Budget {
+			giveUpCount.Add(r.name+"."+op, 1)
+			return err
+		}
+		retryCount.Add(r.name+"."+op, 1)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		if backoff *= 2; r.policy.MaxBackoff > 0 && backoff > r.policy.MaxBackoff {
+			backoff = r.policy.MaxBackoff
+		}
+	}
+}
+
+// GetObject return a data object by node.
+func (r *RetryStorage) GetObject(node string) (data []byte, err error) {
+	err = r.retry("GetObject", func() error {
+		data, err = r.Storage.GetObject(node)
+		return err
+	})
+	return data, err
+}
+
+// PutObject save a data object via node. A precondition failing on a retry while
+// the object holds data was saved by an earlier attempt.
+func (r *RetryStorage) PutObject(node string, data []byte, opts ...WriteOption) error {
+	attempt := 0
+	return r.retry("PutObject", func() error {
+		attempt++
+		err := r.Storage.PutObject(node, data, opts...)
+		if attempt > 1 && errors.Is(err, ErrPreconditionFailed) && r.holds(node, fmt.Sprintf("%x", md5.Sum(data))) {
+			return nil
+		}
+		return err
+	})
+}
+
+// holds reports whether node holds the data of the md5 sum, in hex, the
+// objects of the backends keeping no sum never do.
+func (r *RetryStorage) holds(node, sum string) bool {
+	obj, err := r.Storage.Stat(node)
+	return err == nil && sum != "" && obj.Sum == sum
+}
+
+// RemoveObject remove a data object via node, an object gone on a retry was removed by an earlier attempt.
+func (r *RetryStorage) RemoveObject(node string, opts ...WriteOption) error {
+	attempt := 0
+	return r.retry("RemoveObject", func() error {
+		attempt++
+		err := r.Storage.RemoveObject(node, opts...)
+		if attempt > 1 && err == ErrCodeNoSuchKey {
+			return nil
+		}
+		return err
+	})
+}
+
+// RemoveDir remove a folder.
+func (r *RetryStorage) RemoveDir(node string) error {
+	return r.retry("RemoveDir", func() error {
+		return r.Storage.RemoveDir(node)
+	})
+}
+
+// RemoveAll remove every object under the folder, a retry lists the objects left.
+func (r *RetryStorage) RemoveAll(node string) error {
+	return r.retry("RemoveAll", func() error {
+		return r.Storage.RemoveAll(node)
+	})
+}
+
+// Stat return the attributes and the custom metadata of the object
+func (r *RetryStorage) Stat(node string) (obj *Object, err error) {
+	err = r.retry("Stat", func() error {
+		obj, err = r.Storage.Stat(node)
+		return err
+	})
+	return obj, err
+}
+
+// CopyObject backup this object. A precondition failing on a retry while to
+// holds the data of from was copied by an earlier attempt.
+func (r *RetryStorage) CopyObject(from, to string, opts ...WriteOption) error {
+	attempt := 0
+	return r.retry("CopyObject", func() error {
+		attempt++
+		err := r.Storage.CopyObject(from, to, opts...)
+		if attempt > 1 && errors.Is(err, ErrPreconditionFailed) {
+			if src, statErr := r.Storage.Stat(from); statErr == nil && r.holds(to, src.Sum) {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// MoveObject rename this object
+func (r *RetryStorage) MoveObject(from, to string) error {
+	return r.retry("MoveObject", func() error {
+		return r.Storage.MoveObject(from, to)
+	})
+}
+
+// CopyPrefix copy every object under the folder from into the folder to
+func (r *RetryStorage) CopyPrefix(from, to string) error {
+	return r.retry("CopyPrefix", func() error {
+		return r.Storage.CopyPrefix(from, to)
+	})
+}
+
+// MovePrefix move every object under the folder from into the folder to, a retry lists the objects left.
+func (r *RetryStorage) MovePrefix(from, to string) error {
+	return r.retry("MovePrefix", func() error {
+		return r.Storage.MovePrefix(from, to)
+	})
+}
+
+// ListObjects return all files via prefix dir
+func (r *RetryStorage) ListObjects(dir string) (objs []*Object, size int64, err error) {
+	err = r.retry("ListObjects", func() error {
+		objs, size, err = r.Storage.ListObjects(dir)
+		return err
+	})
+	return objs, size, err
+}
+
+func (r *RetryStorage) ListChildObjects(dir string) (objs []*Object, size int64, err error) {
+	err = r.retry("ListChildObjects", func() error {
+		objs, size, err = r.Storage.ListChildObjects(dir)
+		return err
+	})
+	return objs, size, err
+}
+
+// ListDirs return all dirs via prefix dir
+func (r *RetryStorage) ListDirs(dir string) (dirs []string, err error) {
+	err = r.retry("ListDirs", func() error {
+		dirs, err = r.Storage.ListDirs(dir)
+		return err
+	})
+	return dirs, err
+}
+
+// ListPage return a page of the objects under the folder dir
+func (r *RetryStorage) ListPage(dir, pageToken string, pageSize int) (objs []*Object, next string, err error) {
+	err = r.retry("ListPage", func() error {
+		objs, next, err = r.Storage.ListPage(dir, pageToken, pageSize)
+		return err
+	})
+	return objs, next, err
+}
+
+// Walk calls fn for every object under the folder dir, each page is retried on its own
+func (r *RetryStorage) Walk(dir string, fn WalkFunc) error {
+	return walk(r.context(), r, dir, fn)
+}
+
+// Glob calls fn for the objects matching pattern, a retry skips the objects
+// handed to fn by the earlier attempts. An error of fn is not retried.
+func (r *RetryStorage) Glob(pattern string, fn WalkFunc) error {
+	var last string
+	var fnErr error
+	err := r.retry("Glob", func() error {
+		return r.Storage.Glob(pattern, func(obj *Object) error {
+			if last != "" && obj.FileName <= last {
+				return nil
+			}
+			last = obj.FileName
+			if fnErr = fn(obj); fnErr != nil {
+				return ErrStopWalk
+			}
+			return nil
+		})
+	})
+	if fnErr != nil && fnErr != ErrStopWalk {
+		return fnErr
+	}
+	return err
+}
+
+func (r *RetryStorage) ListVersions(node string) (objs []*Object, err error) {
+	err = r.retry("ListVersions", func() error {
+		objs, err = r.Storage.ListVersions(node)
+		return err
+	})
+	return objs, err
+}
+
+func (r *RetryStorage) RestoreVersion(node string, generation int64) error {
+	return r.retry("RestoreVersion", func() error {
+		return r.Storage.RestoreVersion(node, generation)
+	})
+}
+
+// Download download file to local
+func (r *RetryStorage) Download(from, to string, opts ...WriteOption) error {
+	return r.retry("Download", func() error {
+		return r.Storage.Download(from, to, opts...)
+	})
+}
+
+// Upload put file to remote. A precondition failing on a retry while to holds
+// the data of the file was uploaded by an earlier attempt.
+func (r *RetryStorage) Upload(from, to string, opts ...WriteOption) error {
+	attempt := 0
+	return r.retry("Upload", func() error {
+		attempt++
+		err := r.Storage.Upload(from, to, opts...)
+		if attempt > 1 && errors.Is(err, ErrPreconditionFailed) {
+			if sum, sumErr := fileChecksum(from); sumErr == nil && r.holds(to, hex.EncodeToString(sum.MD5())) {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// OpenReader return a stream of the object, only opening it is retried
+func (r *RetryStorage) OpenReader(node string) (rc io.ReadCloser, err error) {
+	err = r.retry("OpenReader", func() error {
+		rc, err = r.Storage.OpenReader(node)
+		return err
+	})
+	return rc, err
+}
+
+// OpenWriter return a stream writing into the object, only opening it is retried
+func (r *RetryStorage) OpenWriter(node string, opts ...WriteOption) (wc io.WriteCloser, err error) {
+	err = r.retry("OpenWriter", func() error {
+		wc, err = r.Storage.OpenWriter(node, opts...)
+		return err
+	})
+	return wc, err
+}
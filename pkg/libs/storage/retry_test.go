@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+// flakyStorage fails the first calls of GetObject, PutObject, RemoveObject,
+// CopyObject, Upload, ListPage and Glob with err.
+type flakyStorage struct {
+	Storage
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyStorage) fail() error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+func (f *flakyStorage) WithContext(ctx context.Context) Storage {
+	return f
+}
+
+func (f *flakyStorage) GetObject(node string) ([]byte, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.Storage.GetObject(node)
+}
+
+func (f *flakyStorage) RemoveObject(node string, opts ...WriteOption) error {
+	if err := f.fail(); err != nil {
+		// the object is removed although the response is lost.
+		f.Storage.RemoveObject(node, opts...)
+		return err
+	}
+	return f.Storage.RemoveObject(node, opts...)
+}
+
+func (f *flakyStorage) PutObject(node string, data []byte, opts ...WriteOption) error {
+	if err := f.fail(); err != nil {
+		// the object is saved although the response is lost.
+		f.Storage.PutObject(node, data, opts...)
+		return err
+	}
+	return f.Storage.PutObject(node, data, opts...)
+}
+
+func (f *flakyStorage) CopyObject(from, to string, opts ...WriteOption) error {
+	if err := f.fail(); err != nil {
+		// the object is copied although the response is lost.
+		f.Storage.CopyObject(from, to, opts...)
+		return err
+	}
+	return f.Storage.CopyObject(from, to, opts...)
+}
+
+func (f *flakyStorage) Upload(from, to string, opts ...WriteOption) error {
+	if err := f.fail(); err != nil {
+		// the file is uploaded although the response is lost.
+		f.Storage.Upload(from, to, opts...)
+		return err
+	}
+	return f.Storage.Upload(from, to, opts...)
+}
+
+func (f *flakyStorage) ListPage(dir, pageToken string, pageSize int) ([]*Object, string, error) {
+	if err := f.fail(); err != nil {
+		return nil, "", err
+	}
+	return f.Storage.ListPage(dir, pageToken, pageSize)
+}
+
+func (f *flakyStorage) Glob(pattern string, fn WalkFunc) error {
+	err := f.fail()
+	if err == nil {
+		return f.Storage.Glob(pattern, fn)
+	}
+	// the listing breaks after the first object.
+	if globErr := f.Storage.Glob(pattern, func(obj *Object) error {
+		if globErr := fn(obj); globErr != nil {
+			return globErr
+		}
+		return ErrStopWalk
+	}); globErr != nil {
+		return globErr
+	}
+	return err
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.False(t, IsRetryable(nil))
+	assert.False(t, IsRetryable(ErrCodeNoSuchKey))
+	assert.False(t, IsRetryable(context.Canceled))
+	assert.True(t, IsRetryable(context.DeadlineExceeded))
+	assert.True(t, IsRetryable(fmt.Errorf("read: %w", io.ErrUnexpectedEOF)))
+	assert.True(t, IsRetryable(sftp.ErrSSHFxConnectionLost))
+	assert.True(t, IsRetryable(io.EOF))
+	assert.False(t, IsRetryable(&sftp.StatusError{Code: 4}))
+	assert.True(t, IsRetryable(&googleapi.Error{Code: http.StatusTooManyRequests}))
+	assert.True(t, IsRetryable(&googleapi.Error{Code: http.StatusServiceUnavailable}))
+	assert.False(t, IsRetryable(&googleapi.Error{Code: http.StatusForbidden}))
+	assert.True(t, IsRetryable(&S3Error{StatusCode: http.StatusInternalServerError, Code: "InternalError"}))
+	assert.False(t, IsRetryable(&S3Error{StatusCode: http.StatusBadRequest}))
+	assert.True(t, IsRetryable(&AzureError{StatusCode: http.StatusServiceUnavailable, Code: "ServerBusy"}))
+	assert.True(t, IsRetryable(&AzureError{StatusCode: http.StatusTooManyRequests}))
+	assert.True(t, IsRetryable(&AzureError{StatusCode: http.StatusRequestTimeout, Code: "OperationTimedOut"}))
+	assert.False(t, IsRetryable(&AzureError{StatusCode: http.StatusBadRequest, Code: "InvalidHeaderValue"}))
+	assert.True(t, IsRetryable(&PrefixError{Failed: map[string]error{
+		"a": ErrCodeNoSuchKey, "b": &S3Error{StatusCode: http.StatusServiceUnavailable},
+	}}))
+	assert.False(t, IsRetryable(&PrefixError{Failed: map[string]error{"a": ErrCodeNoSuchKey}}))
+}
+
+func TestRetryStorage(t *testing.T) {
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	mem := NewMemStorage(nil)
+	assert.Nil(t, mem.PutObject("mem://retry/a.csv", []byte("a")))
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	flaky := &flakyStorage{Storage: mem, failures: 2, err: unavailable}
+	data, err := NewRetryStorage(flaky, "test", policy).GetObject("mem://retry/a.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(data))
+	assert.Equal(t, 3, flaky.calls)
+	assert.Equal(t, "2", retryCount.Get("test.GetObject").String())
+
+	flaky = &flakyStorage{Storage: mem, failures: 3, err: unavailable}
+	_, err = NewRetryStorage(flaky, "test", policy).GetObject("mem://retry/a.csv")
+	assert.Equal(t, unavailable, err)
+	assert.Equal(t, "1", giveUpCount.Get("test.GetObject").String())
+
+	flaky = &flakyStorage{Storage: mem, failures: 3, err: ErrCodeNoSuchKey}
+	_, err = NewRetryStorage(flaky, "test", policy).GetObject("mem://retry/a.csv")
+	assert.Equal(t, ErrCodeNoSuchKey, err)
+	assert.Equal(t, 1, flaky.calls)
+
+	flaky = &flakyStorage{Storage: mem, failures: 1, err: unavailable}
+	assert.Nil(t, NewRetryStorage(flaky, "test", policy).RemoveObject("mem://retry/a.csv"))
+	assert.False(t, mem.IsExist("mem://retry/a.csv"))
+
+	// the create committed by the failed attempt is not reported as a conflict.
+	flaky = &flakyStorage{Storage: mem, failures: 1, err: unavailable}
+	assert.Nil(t, NewRetryStorage(flaky, "test", policy).PutObject("mem://retry/b.csv", []byte("b"), IfNotExist()))
+	flaky = &flakyStorage{Storage: mem, failures: 1, err: unavailable}
+	err = NewRetryStorage(flaky, "test", policy).PutObject("mem://retry/b.csv", []byte("c"), IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+	data, err = mem.GetObject("mem://retry/b.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", string(data))
+
+	// so are the copy and the upload committed by the failed attempt.
+	flaky = &flakyStorage{Storage: mem, failures: 1, err: unavailable}
+	assert.Nil(t, NewRetryStorage(flaky, "test", policy).CopyObject("mem://retry/b.csv", "mem://retry/copied.csv", IfNotExist()))
+	assert.Nil(t, mem.PutObject("mem://retry/other.csv", []byte("other")))
+	flaky = &flakyStorage{Storage: mem, failures: 1, err: unavailable}
+	err = NewRetryStorage(flaky, "test", policy).CopyObject("mem://retry/other.csv", "mem://retry/copied.csv", IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	local := filepath.Join(t.TempDir(), "c.csv")
+	assert.Nil(t, ioutil.WriteFile(local, []byte("c"), 0640))
+	flaky = &flakyStorage{Storage: mem, failures: 1, err: unavailable}
+	assert.Nil(t, NewRetryStorage(flaky, "test", policy).Upload(local, "mem://retry/c.csv", IfNotExist()))
+	flaky = &flakyStorage{Storage: mem, failures: 1, err: unavailable}
+	err = NewRetryStorage(flaky, "test", policy).Upload(local, "mem://retry/b.csv", IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+}
+
+func TestRetryStorage_Walk(t *testing.T) {
+	mem := NewMemStorage(nil)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, mem.PutObject(fmt.Sprintf("mem://retry-walk/dir/%d.csv", i), nil))
+	}
+	pageSize := WalkPageSize
+	defer func() { WalkPageSize = pageSize }()
+	WalkPageSize = 2
+
+	// the first page fails once, the other pages are listed as usual.
+	flaky := &flakyStorage{Storage: mem, failures: 1, err: context.DeadlineExceeded}
+	client := NewRetryStorage(flaky, "test", RetryPolicy{MaxAttempts: 2})
+	count := 0
+	assert.Nil(t, client.Walk("mem://retry-walk/dir", func(obj *Object) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 5, count)
+	assert.Equal(t, 4, flaky.calls)
+}
+
+func TestRetryStorage_Glob(t *testing.T) {
+	mem := NewMemStorage(nil)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, mem.PutObject(fmt.Sprintf("mem://retry-glob/dir/%d.csv", i), nil))
+	}
+	// the retry resumes after the object handed before the failure.
+	flaky := &flakyStorage{Storage: mem, failures: 1, err: context.DeadlineExceeded}
+	client := NewRetryStorage(flaky, "test", RetryPolicy{MaxAttempts: 2})
+	var names []string
+	assert.Nil(t, client.Glob("mem://retry-glob/dir/*.csv", func(obj *Object) error {
+		names = append(names, obj.FileName)
+		return nil
+	}))
+	assert.Len(t, names, 5)
+	assert.Equal(t, "mem://retry-glob/dir/0.csv", names[0])
+	assert.Equal(t, "mem://retry-glob/dir/4.csv", names[4])
+
+	// an error of fn is returned as is.
+	flaky = &flakyStorage{Storage: mem, failures: 0, err: context.DeadlineExceeded}
+	client = NewRetryStorage(flaky, "test", RetryPolicy{MaxAttempts: 2})
+	err := client.Glob("mem://retry-glob/dir/*.csv", func(obj *Object) error {
+		return context.DeadlineExceeded
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, flaky.calls)
+}
+
+func TestRetryStorage_Budget(t *testing.T) {
+	unavailable := &S3Error{StatusCode: http.StatusServiceUnavailable}
+	flaky := &flakyStorage{Storage: NewMemStorage(nil), failures: 100, err: unavailable}
+	policy := RetryPolicy{MaxAttempts: 100, InitialBackoff: 10 * time.Millisecond, Budget: 50 * time.Millisecond}
+	start := time.Now()
+	_, err := NewRetryStorage(flaky, "test", policy).GetObject("mem://retry/budget.csv")
+	assert.Equal(t, unavailable, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, flaky.calls < 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	flaky = &flakyStorage{Storage: NewMemStorage(nil), failures: 100, err: unavailable}
+	client := NewRetryStorage(flaky, "test", RetryPolicy{MaxAttempts: 100, InitialBackoff: time.Hour}).WithContext(ctx)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err = client.GetObject("mem://retry/budget.csv")
+	assert.True(t, errors.Is(err, unavailable))
+	assert.Equal(t, 1, flaky.calls)
+}
+
+func TestNewStorage_RetryPolicies(t *testing.T) {
+	defer delete(RetryPolicies, StorageInMemory)
+	RetryPolicies[StorageInMemory] = RetryPolicy{MaxAttempts: 3}
+	_, ok := NewStorage(StorageInMemory, nil).(*RetryStorage)
+	assert.True(t, ok)
+	client, err := NewStorageClient("mem://retry/a.csv", "")
+	assert.Nil(t, err)
+	backend, err := client.WithContext(context.Background()).(*routingStorage).backend("mem://retry/a.csv")
+	assert.Nil(t, err)
+	_, ok = backend.(*RetryStorage)
+	assert.True(t, ok)
+	backend, err = client.(*routingStorage).backend("/tmp/a.csv")
+	assert.Nil(t, err)
+	_, ok = backend.(*FileStorage)
+	assert.True(t, ok)
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// routingStorage sends each call to the client of the backend of its path, the
+// clients are created on first use from the credentials given to NewStorageClient.
+// A copy or a move between two schemes streams the objects through the process
+// and verifies them, as Transfer does.
+type routingStorage struct {
+	opContext
+	credentials string
+	clients     *routes
+}
+
+// routes holds the clients of a routingStorage, shared by its copies.
+type routes struct {
+	sync.Mutex
+	clients map[StorageType]Storage
+}
+
+func newRoutingStorage(credentials string) *routingStorage {
+	return &routingStorage{credentials: credentials, clients: &routes{clients: map[StorageType]Storage{}}}
+}
+
+// WithContext return a copy of the storage whose calls are bound to ctx
+func (r *routingStorage) WithContext(ctx context.Context) Storage {
+	c := *r
+	c.ctx = ctx
+	return &c
+}
+
+// backend return the client of the backend of node.
+func (r *routingStorage) backend(node string) (Storage, error) {
+	b, err := backendFor(node)
+	if err != nil {
+		return nil, err
+	}
+	r.clients.Lock()
+	client, ok := r.clients.clients[b.Type]
+	if !ok {
+		opts, err := b.DecodeOptions(r.credentials)
+		if err != nil {
+			r.clients.Unlock()
+			return nil, err
+		}
+		client = NewStorage(b.Type, opts)
+		r.clients.clients[b.Type] = client
+	}
+	r.clients.Unlock()
+	if r.ctx != nil {
+		client = client.WithContext(r.ctx)
+	}
+	return client, nil
+}
+
+// backends return the clients of from and to, and whether they are the same backend.
+func (r *routingStorage) backends(from, to string) (Storage, Storage, bool, error) {
+	src, err := r.backend(from)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	dst, err := r.backend(to)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	fromBackend, _ := backendFor(from)
+	toBackend, _ := backendFor(to)
+	return src, dst, fromBackend == toBackend, nil
+}
+
+func (r *routingStorage) PathJoin(items ...string) string {
+	if len(items) == 0 {
+		return ""
+	}
+	s, err := r.backend(items[0])
+	if err != nil {
+		return strings.Join(items, slash)
+	}
+	return s.PathJoin(items...)
+}
+
+func (r *routingStorage) GetObject(node string) ([]byte, error) {
+	s, err := r.backend(node)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetObject(node)
+}
+
+func (r *routingStorage) PutObject(node string, data []byte, opts ...WriteOption) error {
+	s, err := r.backend(node)
+	if err != nil {
+		return err
+	}
+	return s.PutObject(node, data, opts...)
+}
+
+func (r *routingStorage) RemoveObject(node string, opts ...WriteOption) error {
+	s, err := r.backend(node)
+	if err != nil {
+		return err
+	}
+	return s.RemoveObject(node, opts...)
+}
+
+func (r *routingStorage) RemoveDir(node string) error {
+	s, err := r.backend(node)
+	if err != nil {
+		return err
+	}
+	return s.RemoveDir(node)
+}
+
+func (r *routingStorage) RemoveAll(node string) error {
+	s, err := r.backend(node)
+	if err != nil {
+		return err
+	}
+	return s.RemoveAll(node)
+}
+
+// CopyObject copies from to to, between two schemes the content type and the
+// metadata of from are kept and the copy is verified.
+func (r *routingStorage) CopyObject(from, to string, opts ...WriteOption) error {
+	src, dst, same, err := r.backends(from, to)
+	if err != nil {
+		return err
+	}
+	if same {
+		return src.CopyObject(from, to, opts...)
+	}
+	return transferObject(src, dst, from, to, opts)
+}
+
+func (r *routingStorage) MoveObject(from, to string) error {
+	src, dst, same, err := r.backends(from, to)
+	if err != nil {
+		return err
+	}
+	if same {
+		return src.MoveObject(from, to)
+	}
+	if err := transferObject(src, dst, from, to, nil); err != nil {
+		return err
+	}
+	return src.RemoveObject(from)
+}
+
+func (r *routingStorage) CopyPrefix(from, to string) error {
+	src, dst, same, err := r.backends(from, to)
+	if err != nil {
+		return err
+	}
+	if same {
+		return src.CopyPrefix(from, to)
+	}
+	return r.prefixAcross(src, dst, "copy", from, to, func(src, dst Storage, from, to string) error {
+		return transferObject(src, dst, from, to, nil)
+	})
+}
+
+func (r *routingStorage) MovePrefix(from, to string) error {
+	src, dst, same, err := r.backends(from, to)
+	if err != nil {
+		return err
+	}
+	if same {
+		return src.MovePrefix(from, to)
+	}
+	return r.prefixAcross(src, dst, "move", from, to, func(src, dst Storage, from, to string) error {
+		if err := transferObject(src, dst, from, to, nil); err != nil {
+			return err
+		}
+		return src.RemoveObject(from)
+	})
+}
+
+// prefixAcross applies op to every object under the folder from and its
+// counterpart under the folder to, in another backend.
+func (r *routingStorage) prefixAcross(src, dst Storage, name, from, to string, op func(src, dst Storage, from, to string) error) error {
+	objs, _, err := src.ListObjects(from)
+	if err != nil {
+		return err
+	}
+	prefix := appendPathSuffix(from)
+	return forEachObject(r.context(), name, from, objs, func(obj *Object) error {
+		if !strings.HasPrefix(obj.FileName, prefix) {
+			return fmt.Errorf("%v %s is not under %s", IllegalPath, obj.FileName, from)
+		}
+		key := strings.TrimPrefix(obj.FileName, prefix)
+		if key == "" {
+			// the placeholder object of the folder itself.
+			return nil
+		}
+		return op(src, dst, obj.FileName, dst.PathJoin(to, key))
+	})
+}
+
+func (r *routingStorage) IsExist(node string) bool {
+	s, err := r.backend(node)
+	if err != nil {
+		return false
+	}
+	return s.IsExist(node)
+}
+
+func (r *routingStorage) Stat(node string) (*Object, error) {
+	s, err := r.backend(node)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stat(node)
+}
+
+func (r *routingStorage) ListObjects(dir string) ([]*Object, int64, error) {
+	s, err := r.backend(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.ListObjects(dir)
+}
+
+func (r *routingStorage) ListChildObjects(dir string) ([]*Object, int64, error) {
+	s, err := r.backend(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.ListChildObjects(dir)
+}
+
+func (r *routingStorage) ListDirs(dir string) ([]string, error) {
+	s, err := r.backend(dir)
+	if err != nil {
+		return nil, err
+	}
+	return s.ListDirs(dir)
+}
+
+func (r *routingStorage) ListPage(dir, pageToken string, pageSize int) ([]*Object, string, error) {
+	s, err := r.backend(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.ListPage(dir, pageToken, pageSize)
+}
+
+func (r *routingStorage) Walk(dir string, fn WalkFunc) error {
+	s, err := r.backend(dir)
+	if err != nil {
+		return err
+	}
+	return s.Walk(dir, fn)
+}
+
+func (r *routingStorage) Glob(pattern string, fn WalkFunc) error {
+	s, err := r.backend(pattern)
+	if err != nil {
+		return err
+	}
+	return s.Glob(pattern, fn)
+}
+
+func (r *routingStorage) ListVersions(node string) ([]*Object, error) {
+	s, err := r.backend(node)
+	if err != nil {
+		return nil, err
+	}
+	return s.ListVersions(node)
+}
+
+func (r *routingStorage) RestoreVersion(node string, generation int64) error {
+	s, err := r.backend(node)
+	if err != nil {
+		return err
+	}
+	return s.RestoreVersion(node, generation)
+}
+
+func (r *routingStorage) SignURL(node, method string, expiry time.Duration) (string, error) {
+	s, err := r.backend(node)
+	if err != nil {
+		return "", err
+	}
+	return s.SignURL(node, method, expiry)
+}
+
+func (r *routingStorage) Download(from, to string, opts ...WriteOption) error {
+	s, err := r.backend(from)
+	if err != nil {
+		return err
+	}
+	return s.Download(from, to, opts...)
+}
+
+func (r *routingStorage) Upload(from, to string, opts ...WriteOption) error {
+	s, err := r.backend(to)
+	if err != nil {
+		return err
+	}
+	return s.Upload(from, to, opts...)
+}
+
+func (r *routingStorage) OpenReader(node string) (io.ReadCloser, error) {
+	s, err := r.backend(node)
+	if err != nil {
+		return nil, err
+	}
+	return s.OpenReader(node)
+}
+
+func (r *routingStorage) OpenWriter(node string, opts ...WriteOption) (io.WriteCloser, error) {
+	s, err := r.backend(node)
+	if err != nil {
+		return nil, err
+	}
+	return s.OpenWriter(node, opts...)
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	assert.Equal(t, []StorageType{StorageInLocal, StorageOnAWS, StorageOnGCP, StorageInMemory, StorageOnAzure, StorageOnSFTP}, StorageTypes())
+	assert.Equal(t, "gcp", StorageOnGCP.ToString())
+	assert.Equal(t, "mem://", StorageInMemory.Protocol())
+	assert.Panics(t, func() {
+		Register(Backend{Type: StorageType(100), Name: "other", Scheme: "gs://"})
+	})
+
+	b, err := backendFor("gs://bucket/a.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, StorageOnGCP, b.Type)
+	b, err = backendFor("/tmp/a.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, StorageInLocal, b.Type)
+}
+
+func TestNewStorageClient_Credentials(t *testing.T) {
+	_, err := NewStorageClient("gs://bucket/a.csv", "{")
+	assert.NotNil(t, err)
+	_, err = NewStorageClient("gs://bucket/a.csv", `{"ProjectID":["p"]}`)
+	assert.NotNil(t, err)
+	_, err = NewStorageClient("gs://bucket/a.csv", "")
+	assert.Nil(t, err)
+
+	// the credentials of another scheme are decoded on its first call.
+	client, err := NewStorageClient("mem://router/a.csv", "{")
+	assert.Nil(t, err)
+	assert.Nil(t, client.PutObject("mem://router/a.csv", []byte("a")))
+	_, err = client.GetObject("gs://bucket/a.csv")
+	assert.NotNil(t, err)
+}
+
+func TestRoutingStorage(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	client, err := NewStorageClient("mem://router", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "mem://router/in/a.csv", client.PathJoin("mem://router", "in", "a.csv"))
+	assert.Equal(t, filepath.Join(tempDir, "in", "a.csv"), client.PathJoin(tempDir, "in", "a.csv"))
+
+	assert.Nil(t, client.PutObject("mem://router/in/a.csv", []byte("a"), WithMetadata(map[string]string{"source": "a"})))
+	assert.Nil(t, client.PutObject("mem://router/in/deep/b.csv", []byte("b")))
+
+	local := filepath.Join(tempDir, "in", "a.csv")
+	assert.Nil(t, client.CopyObject("mem://router/in/a.csv", local))
+	data, err := ioutil.ReadFile(local)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(data))
+	obj, err := client.Stat(local)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", obj.Metadata["source"])
+
+	assert.Nil(t, client.MoveObject(local, "mem://router/out/a.csv"))
+	assert.False(t, client.IsExist(local))
+	data, err = client.GetObject("mem://router/out/a.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(data))
+
+	assert.Nil(t, client.CopyPrefix("mem://router/in", filepath.Join(tempDir, "copy")))
+	data, err = ioutil.ReadFile(filepath.Join(tempDir, "copy", "deep", "b.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, "b", string(data))
+	assert.Nil(t, client.MovePrefix(filepath.Join(tempDir, "copy"), "mem://router/moved"))
+	assert.True(t, client.IsExist("mem://router/moved/a.csv"))
+	assert.True(t, client.IsExist("mem://router/moved/deep/b.csv"))
+	assert.False(t, client.IsExist(filepath.Join(tempDir, "copy", "a.csv")))
+}
@@ -0,0 +1,859 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// defaultS3Region signs the lookups of the region of a bucket, and the calls
+// to a custom endpoint.
+const defaultS3Region = "us-east-1"
+
+// s3PartSize is the buffered part size of OpenWriter and Upload, s3 rejects
+// parts smaller than 5MB except the last one.
+var s3PartSize = 5 * 1024 * 1024
+
+// s3ResponseHeaderTimeout bounds the wait for the response of a request once
+// it has been sent, the whole call is bounded by the deadlines of the context.
+var s3ResponseHeaderTimeout = time.Minute
+
+// s3MD5Metadata is the custom metadata recording the md5 of an object written
+// by multipart upload, whose etag is not the md5 of the data.
+const s3MD5Metadata = "content-md5"
+
+// S3Storage is remote storage by aws s3, or any s3 compatible service.
+type S3Storage struct {
+	opContext
+	// Region of the buckets, the region of each bucket is looked up if empty.
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint overrides the aws endpoint, e.g. http://127.0.0.1:9000 for minio.
+	// Requests to a custom endpoint use path-style addressing.
+	Endpoint string
+	protocol string
+	client   *s3.Client
+	// regions caches the looked up region of each bucket.
+	regions *sync.Map
+}
+
+func init() {
+	Register(Backend{
+		Type:          StorageOnAWS,
+		Name:          "aws",
+		Scheme:        "s3://",
+		DecodeOptions: JSONOptions("Region", "AccessKeyID", "SecretAccessKey", "SessionToken", "Endpoint"),
+		New:           func(opts map[string]interface{}) Storage { return NewS3Storage(opts) },
+	})
+}
+
+// NewS3Storage return a new S3 storage client
+func NewS3Storage(opts map[string]interface{}) *S3Storage {
+	s3Storage := new(S3Storage)
+	if Region, ok := opts["Region"]; ok {
+		s3Storage.Region = Region.(string)
+	}
+	if AccessKeyID, ok := opts["AccessKeyID"]; ok {
+		s3Storage.AccessKeyID = AccessKeyID.(string)
+	}
+	if SecretAccessKey, ok := opts["SecretAccessKey"]; ok {
+		s3Storage.SecretAccessKey = SecretAccessKey.(string)
+	}
+	if SessionToken, ok := opts["SessionToken"]; ok {
+		s3Storage.SessionToken = SessionToken.(string)
+	}
+	if Endpoint, ok := opts["Endpoint"]; ok {
+		s3Storage.Endpoint = strings.TrimSuffix(Endpoint.(string), slash)
+	}
+	if s3Storage.AccessKeyID == "" && s3Storage.SecretAccessKey == "" {
+		s3Storage.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s3Storage.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s3Storage.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if s3Storage.Region == "" {
+		s3Storage.Region = os.Getenv("AWS_REGION")
+	}
+	if s3Storage.Region == "" && s3Storage.Endpoint != "" {
+		s3Storage.Region = defaultS3Region
+	}
+	s3Storage.protocol = StorageOnAWS.Protocol()
+	s3Storage.regions = new(sync.Map)
+
+	config := aws.Config{
+		Region: s3Storage.Region,
+		HTTPClient: awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			tr.ResponseHeaderTimeout = s3ResponseHeaderTimeout
+		}),
+		Credentials: aws.AnonymousCredentials{},
+	}
+	if s3Storage.Region == "" {
+		config.Region = defaultS3Region
+	}
+	if s3Storage.AccessKeyID != "" || s3Storage.SecretAccessKey != "" {
+		config.Credentials = credentials.NewStaticCredentialsProvider(s3Storage.AccessKeyID, s3Storage.SecretAccessKey, s3Storage.SessionToken)
+	}
+	s3Storage.client = s3.NewFromConfig(config, func(o *s3.Options) {
+		if s3Storage.Endpoint != "" {
+			o.BaseEndpoint = aws.String(s3Storage.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return s3Storage
+}
+
+// WithContext return a copy of the client whose calls are bound to ctx
+func (s *S3Storage) WithContext(ctx context.Context) Storage {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+func (s *S3Storage) PathJoin(items ...string) string {
+	if len(items) <= 0 {
+		return ""
+	}
+	items[0] = strings.Replace(items[0], s.protocol, "", 1)
+	return s.protocol + path.Join(items...)
+}
+
+// bucketOptions return the options of the calls on bucket, they set the region
+// of the bucket when no region is configured. The region is in the headers of
+// any answer to a HeadBucket, even a redirect or a denial.
+func (s *S3Storage) bucketOptions(ctx context.Context, bucket string) ([]func(*s3.Options), error) {
+	if s.Region != "" {
+		return nil, nil
+	}
+	region, ok := s.regions.Load(bucket)
+	if !ok {
+		out, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		var header http.Header
+		if err == nil {
+			if resp, ok := awsmiddleware.GetRawResponse(out.ResultMetadata).(*smithyhttp.Response); ok {
+				header = resp.Header
+			}
+		} else {
+			var respErr *smithyhttp.ResponseError
+			if !errors.As(err, &respErr) {
+				return nil, err
+			}
+			header = respErr.Response.Header
+		}
+		if header.Get("X-Amz-Bucket-Region") == "" {
+			if err == nil {
+				return nil, fmt.Errorf("s3: no region of bucket %s", bucket)
+			}
+			return nil, s3Error(err)
+		}
+		region, _ = s.regions.LoadOrStore(bucket, header.Get("X-Amz-Bucket-Region"))
+	}
+	return []func(*s3.Options){func(o *s3.Options) { o.Region = region.(string) }}, nil
+}
+
+// GetObject return a data object by node.
+func (s *S3Storage) GetObject(node string) ([]byte, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	out, err := s.getObject(ctx, opts.Bucket, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+	if want := s3ObjectMD5(out.ETag, out.Metadata, out.ServerSideEncryption); want != nil {
+		sum := md5.Sum(data)
+		if !bytes.Equal(sum[:], want) {
+			return nil, fmt.Errorf("%w: %s md5 is %x, want %x", ErrChecksumMismatch, node, sum, want)
+		}
+	}
+	return data, nil
+}
+
+// PutObject save a data object via node, the md5 of the data is sent along so
+// that s3 rejects corrupted data, see ErrChecksumMismatch.
+func (s *S3Storage) PutObject(node string, data []byte, options ...WriteOption) error {
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	o := newWriteOptions(options)
+	if err := o.s3Condition(); err != nil {
+		return err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	return s.putObject(ctx, opts.Bucket, opts.Key, s3PutInput(node, o), data, o.ifNotExist)
+}
+
+// RemoveObject remove a data object via node, s3 supports no precondition on removal.
+func (s *S3Storage) RemoveObject(node string, options ...WriteOption) error {
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	if newWriteOptions(options).hasPrecondition() {
+		return fmt.Errorf("%w: s3 removal with a precondition", ErrNotSupported)
+	}
+	return s.delete(opts.Bucket, opts.Key)
+}
+
+// RemoveDir remove a folder.
+func (s *S3Storage) RemoveDir(node string) error {
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	return s.delete(opts.Bucket, opts.Prefix)
+}
+
+// RemoveAll remove every object under the folder, failures are reported as a *PrefixError
+func (s *S3Storage) RemoveAll(dir string) error {
+	objs, _, err := s.ListObjects(dir)
+	if err != nil {
+		return err
+	}
+	return forEachObject(s.context(), "remove", dir, objs, func(obj *Object) error {
+		return s.RemoveObject(obj.FileName)
+	})
+}
+
+// CopyPrefix copy every object under the folder from into the folder to
+func (s *S3Storage) CopyPrefix(from, to string) error {
+	return bucketPrefixOp(s.context(), s, "copy", from, to, func(src, dst string) error {
+		return s.CopyObject(src, dst)
+	})
+}
+
+// MovePrefix move every object under the folder from into the folder to
+func (s *S3Storage) MovePrefix(from, to string) error {
+	return bucketPrefixOp(s.context(), s, "move", from, to, s.MoveObject)
+}
+
+// CopyObject backup this object, s3 supports no precondition on copies.
+func (s *S3Storage) CopyObject(src, dst string, options ...WriteOption) error {
+	srcOpts, err := parseObj(src)
+	if err != nil {
+		return err
+	}
+	if newWriteOptions(options).hasPrecondition() {
+		return fmt.Errorf("%w: s3 copy with a precondition", ErrNotSupported)
+	}
+	dstOpts, err := parseObj(dst)
+	if err != nil {
+		return err
+	}
+	return s.copyToBucket(srcOpts.Bucket, srcOpts.Key, dstOpts.Bucket, dstOpts.Key)
+}
+
+// MoveObject rename this object
+func (s *S3Storage) MoveObject(src, dst string) error {
+	srcOpts, err := parseObj(src)
+	if err != nil {
+		return err
+	}
+	dstOpts, err := parseObj(dst)
+	if err != nil {
+		return err
+	}
+	if err := s.copyToBucket(srcOpts.Bucket, srcOpts.Key, dstOpts.Bucket, dstOpts.Key); err != nil {
+		return err
+	}
+	return s.delete(srcOpts.Bucket, srcOpts.Key)
+}
+
+// IsExist return false if node doesn't exist
+func (s *S3Storage) IsExist(node string) bool {
+	_, err := s.Stat(node)
+	return err == nil
+}
+
+// Stat return the attributes and the custom metadata of the object, s3 keeps
+// no creation time nor generation. Sum is the md5 of the data when it is known,
+// see s3ObjectMD5.
+func (s *S3Storage) Stat(node string) (*Object, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	callOpts, err := s.bucketOptions(ctx, opts.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(opts.Bucket), Key: aws.String(opts.Key)}, callOpts...)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	updated := aws.ToTime(out.LastModified)
+	metadata := map[string]string{}
+	for k, v := range out.Metadata {
+		if name := strings.ToLower(k); name != s3MD5Metadata {
+			metadata[name] = v
+		}
+	}
+	return &Object{
+		FileName:        node,
+		Size:            aws.ToInt64(out.ContentLength),
+		ModTime:         updated.Unix(),
+		Sum:             hex.EncodeToString(s3ObjectMD5(out.ETag, out.Metadata, out.ServerSideEncryption)),
+		Created:         updated,
+		Updated:         updated,
+		ContentType:     aws.ToString(out.ContentType),
+		ContentEncoding: aws.ToString(out.ContentEncoding),
+		Metadata:        metadata,
+	}, nil
+}
+
+// ListObjects return all files via prefix dir
+func (s *S3Storage) ListObjects(dir string) ([]*Object, int64, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "", ObjectTypeIsObject)
+}
+
+func (s *S3Storage) ListChildObjects(dir string) ([]*Object, int64, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", ObjectTypeIsObject)
+}
+
+// ListDirs return all dirs via prefix dir
+func (s *S3Storage) ListDirs(dir string) ([]string, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, err
+	}
+	objs, _, err := s.listByPrefix(opts.Bucket, appendPathSuffix(opts.Key), "/", ObjectTypeIsDir)
+	return ObjectsToStrings(objs), err
+}
+
+// Glob calls fn for the objects matching pattern, under the folder of the pattern.
+func (s *S3Storage) Glob(pattern string, fn WalkFunc) error {
+	return globWalk(s, pattern, fn)
+}
+
+// ListVersions fails with ErrNotSupported, the versions of s3 are not numbered by generations.
+func (s *S3Storage) ListVersions(node string) ([]*Object, error) {
+	return nil, fmt.Errorf("%w: versions of %s", ErrNotSupported, node)
+}
+
+// RestoreVersion fails with ErrNotSupported, see ListVersions.
+func (s *S3Storage) RestoreVersion(node string, generation int64) error {
+	return fmt.Errorf("%w: versions of %s", ErrNotSupported, node)
+}
+
+// SignURL fails with ErrNotSupported.
+func (s *S3Storage) SignURL(node, method string, expiry time.Duration) (string, error) {
+	return "", fmt.Errorf("%w: signed url of %s", ErrNotSupported, node)
+}
+
+// Download download file to local, the data is checked against the md5 of the
+// object when it is known.
+func (s *S3Storage) Download(from, to string, options ...WriteOption) error {
+	opts, err := parseObj(from)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.transfer()
+	defer cancel()
+	out, err := s.getObject(ctx, opts.Bucket, opts.Key)
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+
+	file, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sum := newChecksum()
+	p := newProgress(newWriteOptions(options).progress, aws.ToInt64(out.ContentLength))
+	buf := make([]byte, 5*1024*1024) //5MB
+	if _, err = io.CopyBuffer(&progressWriter{w: io.MultiWriter(file, sum), progress: p}, out.Body, buf); err == nil {
+		err = sum.verifyMD5(from, s3ObjectMD5(out.ETag, out.Metadata, out.ServerSideEncryption))
+	}
+	if errors.Is(err, ErrChecksumMismatch) {
+		file.Close()
+		os.Remove(to)
+	}
+	if err == nil {
+		p.done()
+	}
+	return err
+}
+
+// Upload put file to remote, the md5 of each part is sent along so that s3
+// rejects corrupted data, see ErrChecksumMismatch. The md5 of the file is
+// recorded on the object when it takes several parts.
+func (s *S3Storage) Upload(from, to string, options ...WriteOption) error {
+	o := newWriteOptions(options)
+	if err := o.s3Condition(); err != nil {
+		return err
+	}
+	sum, err := fileChecksum(from)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := newCustomReader(file, o.progress)
+	if err != nil {
+		return err
+	}
+	opts, err := parseObj(to)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.transfer()
+	w := s.newWriter(ctx, cancel, to, opts, o)
+	w.sum = sum.MD5()
+	if _, err := io.Copy(w, reader); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	reader.done()
+	return nil
+}
+
+// OpenReader return a stream of the object, the last read fails with
+// ErrChecksumMismatch if the data does not match the md5 of the object.
+func (s *S3Storage) OpenReader(node string) (io.ReadCloser, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.transfer()
+	out, err := s.getObject(ctx, opts.Bucket, opts.Key)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	want := s3ObjectMD5(out.ETag, out.Metadata, out.ServerSideEncryption)
+	body := newVerifyingReader(out.Body, func(sum *checksum) error {
+		return sum.verifyMD5(node, want)
+	})
+	return &cancelReadCloser{ReadCloser: body, cancel: cancel}, nil
+}
+
+// OpenWriter return a stream writing into the object, data is sent as a
+// multipart upload so that only one part is held in memory.
+func (s *S3Storage) OpenWriter(node string, options ...WriteOption) (io.WriteCloser, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, err
+	}
+	o := newWriteOptions(options)
+	if err := o.s3Condition(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.transfer()
+	return s.newWriter(ctx, cancel, node, opts, o), nil
+}
+
+func (s *S3Storage) newWriter(ctx context.Context, cancel context.CancelFunc, node string, opts *objOpt, o *writeOptions) *s3Writer {
+	return &s3Writer{storage: s, node: node, bucket: opts.Bucket, key: opts.Key, input: s3PutInput(node, o), ifNotExist: o.ifNotExist,
+		sum: o.md5, written: newChecksum(), ctx: ctx, cancel: cancel}
+}
+
+type s3Writer struct {
+	storage *S3Storage
+	ctx     context.Context
+	cancel  context.CancelFunc
+	node    string
+	bucket  string
+	key     string
+	// input holds the content type and the metadata of the object.
+	input *s3.PutObjectInput
+	// ifNotExist is sent on the request creating the object, the single put or the completion.
+	ifNotExist bool
+	// sum is the md5 of the whole data, if known before the first part is sent,
+	// it is checked against the md5 of the data written before the object is created.
+	sum      []byte
+	written  *checksum
+	buf      bytes.Buffer
+	uploadID string
+	parts    []s3types.CompletedPart
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n, _ := w.buf.Write(p)
+	w.written.Write(p)
+	for w.buf.Len() >= s3PartSize {
+		if err := w.flushPart(w.buf.Next(s3PartSize)); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (w *s3Writer) flushPart(data []byte) error {
+	callOpts, err := w.storage.bucketOptions(w.ctx, w.bucket)
+	if err != nil {
+		return err
+	}
+	if w.uploadID == "" {
+		metadata := w.input.Metadata
+		if w.sum != nil {
+			metadata = map[string]string{s3MD5Metadata: hex.EncodeToString(w.sum)}
+			for k, v := range w.input.Metadata {
+				metadata[k] = v
+			}
+		}
+		out, err := w.storage.client.CreateMultipartUpload(w.ctx, &s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(w.bucket),
+			Key:         aws.String(w.key),
+			ContentType: w.input.ContentType,
+			Metadata:    metadata,
+		}, callOpts...)
+		if err != nil {
+			return s3Error(err)
+		}
+		w.uploadID = aws.ToString(out.UploadId)
+	}
+	number := int32(len(w.parts) + 1)
+	sum := md5.Sum(data)
+	out, err := w.storage.client.UploadPart(w.ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(w.bucket),
+		Key:           aws.String(w.key),
+		UploadId:      aws.String(w.uploadID),
+		PartNumber:    aws.Int32(number),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+	}, append(callOpts, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))...)
+	if err != nil {
+		return s3Error(err)
+	}
+	w.parts = append(w.parts, s3types.CompletedPart{PartNumber: aws.Int32(number), ETag: out.ETag})
+	return nil
+}
+
+func (w *s3Writer) Close() error {
+	defer w.cancel()
+	if w.sum != nil {
+		if err := w.written.verifyMD5(w.node, w.sum); err != nil {
+			w.CloseWithError(err)
+			return err
+		}
+	}
+	if w.uploadID == "" {
+		return w.storage.putObject(w.ctx, w.bucket, w.key, w.input, w.buf.Bytes(), w.ifNotExist)
+	}
+	if w.buf.Len() > 0 {
+		if err := w.flushPart(w.buf.Bytes()); err != nil {
+			w.CloseWithError(err)
+			return err
+		}
+	}
+	callOpts, err := w.storage.bucketOptions(w.ctx, w.bucket)
+	if err != nil {
+		return err
+	}
+	if w.ifNotExist {
+		callOpts = append(callOpts, s3.WithAPIOptions(smithyhttp.AddHeaderValue("If-None-Match", "*")))
+	}
+	// the sdk reports a completion failing after the 200 status line as an error.
+	_, err = w.storage.client.CompleteMultipartUpload(w.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.bucket),
+		Key:             aws.String(w.key),
+		UploadId:        aws.String(w.uploadID),
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: w.parts},
+	}, callOpts...)
+	return s3Error(err)
+}
+
+// CloseWithError aborts the multipart upload, nothing is written to the object.
+func (w *s3Writer) CloseWithError(err error) error {
+	defer w.cancel()
+	if w.uploadID == "" {
+		return nil
+	}
+	callOpts, err := w.storage.bucketOptions(w.ctx, w.bucket)
+	if err != nil {
+		return err
+	}
+	_, err = w.storage.client.AbortMultipartUpload(w.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.bucket),
+		Key:      aws.String(w.key),
+		UploadId: aws.String(w.uploadID),
+	}, callOpts...)
+	return s3Error(err)
+}
+
+func (s *S3Storage) getObject(ctx context.Context, bucket, key string) (*s3.GetObjectOutput, error) {
+	callOpts, err := s.bucketOptions(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}, callOpts...)
+	return out, s3Error(err)
+}
+
+// putObject writes data in a single request, input holds the content type and
+// the metadata of the object.
+func (s *S3Storage) putObject(ctx context.Context, bucket, key string, input *s3.PutObjectInput, data []byte, ifNotExist bool) error {
+	callOpts, err := s.bucketOptions(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	callOpts = append(callOpts, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+	if ifNotExist {
+		callOpts = append(callOpts, s3.WithAPIOptions(smithyhttp.AddHeaderValue("If-None-Match", "*")))
+	}
+	sum := md5.Sum(data)
+	put := *input
+	put.Bucket = aws.String(bucket)
+	put.Key = aws.String(key)
+	put.Body = bytes.NewReader(data)
+	put.ContentLength = aws.Int64(int64(len(data)))
+	put.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	_, err = s.client.PutObject(ctx, &put, callOpts...)
+	return s3Error(err)
+}
+
+func (s *S3Storage) copyToBucket(srcBucket, srcObject, dstBucket, dstObject string) error {
+	ctx, cancel := s.operation()
+	defer cancel()
+	callOpts, err := s.bucketOptions(ctx, dstBucket)
+	if err != nil {
+		return err
+	}
+	source := (&url.URL{Path: srcBucket + slash + srcObject}).EscapedPath()
+	// the sdk reports a copy failing after the 200 status line as an error.
+	_, err = s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstObject),
+		CopySource: aws.String(source),
+	}, callOpts...)
+	return s3Error(err)
+}
+
+func (s *S3Storage) delete(bucket, object string) error {
+	ctx, cancel := s.operation()
+	defer cancel()
+	callOpts, err := s.bucketOptions(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(object)}, callOpts...)
+	return s3Error(err)
+}
+
+// listPage return a page of ListObjectsV2, maxKeys 0 leaves the page size to s3.
+func (s *S3Storage) listPage(ctx context.Context, bucket, prefix, delim, token string, maxKeys int) (*s3.ListObjectsV2Output, error) {
+	callOpts, err := s.bucketOptions(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)}
+	if delim != "" {
+		input.Delimiter = aws.String(delim)
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
+	if maxKeys > 0 {
+		input.MaxKeys = aws.Int32(int32(maxKeys))
+	}
+	out, err := s.client.ListObjectsV2(ctx, input, callOpts...)
+	return out, s3Error(err)
+}
+
+// listedObject return the object of an entry of a listing. A listing tells not
+// whether an etag is the md5 of the data, so that its Sum is left empty.
+func listedObject(bucket string, c s3types.Object) *Object {
+	updated := aws.ToTime(c.LastModified)
+	return &Object{
+		FileName: fmt.Sprintf("s3://%s/%s", bucket, aws.ToString(c.Key)),
+		Size:     aws.ToInt64(c.Size),
+		ModTime:  updated.Unix(),
+		Created:  updated,
+		Updated:  updated,
+	}
+}
+
+func (s *S3Storage) listByPrefix(bucket, prefix, delim string, types ...ObjectType) ([]*Object, int64, error) {
+	var dirType, objectType bool
+	for _, v := range types {
+		if v == ObjectTypeIsDir {
+			dirType = true
+		}
+		if v == ObjectTypeIsObject {
+			objectType = true
+		}
+	}
+
+	ctx, cancel := s.operation()
+	defer cancel()
+	m := make([]*Object, 0)
+	var size int64
+	var token string
+	for {
+		result, err := s.listPage(ctx, bucket, prefix, delim, token, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		if dirType {
+			for _, p := range result.CommonPrefixes {
+				m = append(m, &Object{
+					FileName: fmt.Sprintf("s3://%s/%s", bucket, aws.ToString(p.Prefix)),
+				})
+			}
+		}
+		for _, c := range result.Contents {
+			size += aws.ToInt64(c.Size)
+			if objectType {
+				m = append(m, listedObject(bucket, c))
+			}
+		}
+		if !aws.ToBool(result.IsTruncated) || aws.ToString(result.NextContinuationToken) == "" {
+			break
+		}
+		token = aws.ToString(result.NextContinuationToken)
+	}
+	return m, size, nil
+}
+
+// ListPage return a page of the objects under the folder dir, the page token is
+// the continuation token of ListObjectsV2.
+func (s *S3Storage) ListPage(dir, pageToken string, pageSize int) ([]*Object, string, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	result, err := s.listPage(ctx, opts.Bucket, appendPathSuffix(opts.Key), "", pageToken, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+	objs := make([]*Object, 0, len(result.Contents))
+	for _, c := range result.Contents {
+		objs = append(objs, listedObject(opts.Bucket, c))
+	}
+	if !aws.ToBool(result.IsTruncated) {
+		return objs, "", nil
+	}
+	return objs, aws.ToString(result.NextContinuationToken), nil
+}
+
+// Walk calls fn for every object under the folder dir
+func (s *S3Storage) Walk(dir string, fn WalkFunc) error {
+	return walk(s.context(), s, dir, fn)
+}
+
+// S3Error is an error response returned by s3.
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is reports a BadDigest error, a Content-MD5 not matching the data, as
+// ErrChecksumMismatch, and a failed If-None-Match as ErrPreconditionFailed.
+func (e *S3Error) Is(target error) bool {
+	switch target {
+	case ErrChecksumMismatch:
+		return e.Code == "BadDigest"
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// s3Error return the error of an sdk call as an *S3Error, ErrCodeNoSuchKey for
+// a missing object, the other errors unchanged.
+func s3Error(err error) error {
+	var respErr *smithyhttp.ResponseError
+	if err == nil || !errors.As(err, &respErr) {
+		return err
+	}
+	e := &S3Error{StatusCode: respErr.HTTPStatusCode()}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		e.Code, e.Message = apiErr.ErrorCode(), apiErr.ErrorMessage()
+	}
+	if e.StatusCode == http.StatusNotFound && (e.Code == "" || e.Code == "NoSuchKey" || e.Code == "NotFound") {
+		return ErrCodeNoSuchKey
+	}
+	return e
+}
+
+// s3PutInput return the content type and the custom metadata of a write.
+func s3PutInput(node string, o *writeOptions) *s3.PutObjectInput {
+	return &s3.PutObjectInput{ContentType: aws.String(o.contentTypeOf(node)), Metadata: o.metadata}
+}
+
+// s3Condition fails for the preconditions s3 lacks, s3 has no generation so
+// that only IfNotExist is supported.
+func (o *writeOptions) s3Condition() error {
+	if o.ifGeneration != nil {
+		return fmt.Errorf("%w: s3 generation precondition", ErrNotSupported)
+	}
+	return nil
+}
+
+// s3ObjectMD5 return the md5 of an object: its etag for a single part upload,
+// the md5 recorded by Upload for a multipart one. It is nil when unknown, e.g.
+// for the multipart uploads of OpenWriter or kms encrypted objects.
+func s3ObjectMD5(etag *string, metadata map[string]string, encryption s3types.ServerSideEncryption) []byte {
+	for k, v := range metadata {
+		if recorded, err := hex.DecodeString(v); strings.ToLower(k) == s3MD5Metadata && err == nil && len(recorded) == md5.Size {
+			return recorded
+		}
+	}
+	if encryption == s3types.ServerSideEncryptionAwsKms || encryption == s3types.ServerSideEncryptionAwsKmsDsse {
+		return nil
+	}
+	sum, err := hex.DecodeString(strings.Trim(aws.ToString(etag), `"`))
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return sum
+}
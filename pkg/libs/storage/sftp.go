@@ -0,0 +1,894 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// StorageOnSFTP is the type of the sftp backend, the drop zones of the customers.
+const StorageOnSFTP StorageType = StorageOnAzure + 1
+
+// SFTPStorage is remote storage on sftp servers, its paths are
+// sftp://[user@]host[:port]/path, the path being absolute on the server. The
+// connections are kept open and shared by the copies of WithContext.
+type SFTPStorage struct {
+	opContext
+	User     string
+	Password string
+	// PrivateKey is a PEM private key, tried before Password.
+	PrivateKey string
+	// HostKey is the public key of the servers, in the authorized_keys format,
+	// the connections to a server presenting another key fail.
+	HostKey  string
+	protocol string
+	conns    *sftpConns
+}
+
+// sftpConns are the connections of a client, by host.
+type sftpConns struct {
+	sync.Mutex
+	clients map[string]*sftpConn
+}
+
+// sftpPosixRename is the OpenSSH extension renaming over an existing file.
+const sftpPosixRename = "posix-rename@openssh.com"
+
+// sftpConn is an sftp session on an ssh connection, its requests are pipelined
+// so that the reads and writes of a file are concurrent.
+type sftpConn struct {
+	*sftp.Client
+	conn *ssh.Client
+	// closed is set once the connection is unusable.
+	closed atomic.Bool
+}
+
+// dialSFTP opens an sftp session on the ssh server addr. The writes of a file
+// are concurrent, a failed write may leave a hole which is harmless as the
+// writers remove their temporary file on failure.
+func dialSFTP(addr string, config *ssh.ClientConfig) (*sftpConn, error) {
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &sftpConn{Client: client, conn: conn}
+	go func() {
+		client.Wait()
+		c.closed.Store(true)
+	}()
+	return c, nil
+}
+
+// Close closes the connection, requests in flight fail.
+func (c *sftpConn) Close() error {
+	c.closed.Store(true)
+	c.Client.Close()
+	return c.conn.Close()
+}
+
+// watch closes the connection once ctx is done until stop is called, so that
+// the requests blocked on it fail.
+func (c *sftpConn) watch(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// do runs the requests of fn bound to ctx, see watch.
+func (c *sftpConn) do(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer c.watch(ctx)()
+	return sftpError(ctx, c.check(fn()))
+}
+
+// check marks the connection closed on the errors other than the status
+// replied by the server, they break the session. The end of a file is handled
+// by the readers, an io.EOF here is the end of the session.
+func (c *sftpConn) check(err error) error {
+	var status *sftp.StatusError
+	if err != nil && !errors.As(err, &status) &&
+		!errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrPermission) {
+		c.closed.Store(true)
+	}
+	return err
+}
+
+// stat return the attributes of the file name.
+func (c *sftpConn) stat(ctx context.Context, name string) (fi os.FileInfo, err error) {
+	err = c.do(ctx, func() error {
+		fi, err = c.Stat(name)
+		return err
+	})
+	return fi, err
+}
+
+// readDir return the entries of the folder name.
+func (c *sftpConn) readDir(ctx context.Context, name string) (entries []os.FileInfo, err error) {
+	err = c.do(ctx, func() error {
+		entries, err = c.ReadDir(name)
+		return err
+	})
+	return entries, err
+}
+
+// replace renames from to to, over to if it exists.
+func (c *sftpConn) replace(ctx context.Context, from, to string) error {
+	if _, ok := c.HasExtension(sftpPosixRename); ok {
+		return c.do(ctx, func() error { return c.PosixRename(from, to) })
+	}
+	// without the extension the file is replaced in two steps.
+	if err := c.do(ctx, func() error { return c.Remove(to) }); err != nil && err != ErrCodeNoSuchKey {
+		return err
+	}
+	return c.do(ctx, func() error { return c.Rename(from, to) })
+}
+
+// sftpError return the error of a request, ErrCodeNoSuchKey for a missing
+// file and the error of ctx once the connection is closed by it.
+func sftpError(ctx context.Context, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, os.ErrNotExist):
+		return ErrCodeNoSuchKey
+	}
+	return err
+}
+
+func init() {
+	Register(Backend{
+		Type:          StorageOnSFTP,
+		Name:          "sftp",
+		Scheme:        "sftp://",
+		DecodeOptions: JSONOptions("SFTPUser", "SFTPPassword", "SFTPPrivateKey", "SFTPHostKey"),
+		New:           func(opts map[string]interface{}) Storage { return NewSFTPStorage(opts) },
+	})
+}
+
+// NewSFTPStorage return a new sftp storage client
+func NewSFTPStorage(opts map[string]interface{}) *SFTPStorage {
+	sftpStorage := new(SFTPStorage)
+	if User, ok := opts["SFTPUser"]; ok {
+		sftpStorage.User = User.(string)
+	}
+	if Password, ok := opts["SFTPPassword"]; ok {
+		sftpStorage.Password = Password.(string)
+	}
+	if PrivateKey, ok := opts["SFTPPrivateKey"]; ok {
+		sftpStorage.PrivateKey = PrivateKey.(string)
+	}
+	if HostKey, ok := opts["SFTPHostKey"]; ok {
+		sftpStorage.HostKey = HostKey.(string)
+	}
+	sftpStorage.protocol = StorageOnSFTP.Protocol()
+	sftpStorage.conns = &sftpConns{clients: map[string]*sftpConn{}}
+	return sftpStorage
+}
+
+// WithContext return a copy of the client whose calls are bound to ctx
+func (s *SFTPStorage) WithContext(ctx context.Context) Storage {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+func (s *SFTPStorage) PathJoin(items ...string) string {
+	if len(items) <= 0 {
+		return ""
+	}
+	items[0] = strings.Replace(items[0], s.protocol, "", 1)
+	return s.protocol + path.Join(items...)
+}
+
+// client return the connection to host, dialed on first use and again once broken.
+func (s *SFTPStorage) client(host string) (*sftpConn, error) {
+	s.conns.Lock()
+	defer s.conns.Unlock()
+	if c, ok := s.conns.clients[host]; ok {
+		if !c.closed.Load() {
+			return c, nil
+		}
+		c.Close()
+	}
+	user, addr := s.User, host
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		user, addr = host[:i], host[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	config, err := s.clientConfig(user, addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dialSFTP(addr, config)
+	if err != nil {
+		return nil, err
+	}
+	s.conns.clients[host] = c
+	return c, nil
+}
+
+func (s *SFTPStorage) clientConfig(user, addr string) (*ssh.ClientConfig, error) {
+	if s.HostKey == "" {
+		return nil, fmt.Errorf("storage: sftp: no host key to check %s", addr)
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("storage: malformed credentials: SFTPHostKey: %w", err)
+	}
+	auth := make([]ssh.AuthMethod, 0, 2)
+	if s.PrivateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(s.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("storage: malformed credentials: SFTPPrivateKey: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if s.Password != "" {
+		auth = append(auth, ssh.Password(s.Password))
+	}
+	return &ssh.ClientConfig{User: user, Auth: auth, HostKeyCallback: ssh.FixedHostKey(hostKey), Timeout: OperationTimeout}, nil
+}
+
+// target return the connection to the server of node and the path of node on it.
+func (s *SFTPStorage) target(node string) (*sftpConn, string, error) {
+	opts, err := parseObj(node)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := s.client(opts.Bucket)
+	if err != nil {
+		return nil, "", err
+	}
+	return c, slash + opts.Key, nil
+}
+
+// GetObject return a data object by node.
+func (s *SFTPStorage) GetObject(node string) ([]byte, error) {
+	c, name, err := s.target(node)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	r, err := openSFTPReader(ctx, c, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PutObject save a data object via node, see OpenWriter.
+func (s *SFTPStorage) PutObject(node string, data []byte, options ...WriteOption) error {
+	c, name, err := s.target(node)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	w, err := createSFTPFile(ctx, c, name, newWriteOptions(options))
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	return w.Close()
+}
+
+// RemoveObject remove a data object via node, removals support no precondition.
+func (s *SFTPStorage) RemoveObject(node string, options ...WriteOption) error {
+	if newWriteOptions(options).hasPrecondition() {
+		return fmt.Errorf("%w: sftp removal with a precondition", ErrNotSupported)
+	}
+	c, name, err := s.target(node)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	return c.do(ctx, func() error { return c.Remove(name) })
+}
+
+// RemoveDir remove a folder, it must be empty.
+func (s *SFTPStorage) RemoveDir(node string) error {
+	opts, err := parseObj(node)
+	if err != nil {
+		return err
+	}
+	c, err := s.client(opts.Bucket)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	return c.do(ctx, func() error { return c.RemoveDirectory(slash + strings.TrimSuffix(opts.Prefix, slash)) })
+}
+
+// RemoveAll remove every object under the folder, then the folders emptied.
+// Failures are reported as a *PrefixError
+func (s *SFTPStorage) RemoveAll(dir string) error {
+	objs, _, err := s.ListObjects(dir)
+	if err != nil {
+		return err
+	}
+	err = forEachObject(s.context(), "remove", dir, objs, func(obj *Object) error {
+		return s.RemoveObject(obj.FileName)
+	})
+	if err != nil {
+		return err
+	}
+	c, name, err := s.target(dir)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	removeSFTPDirs(ctx, c, name)
+	return nil
+}
+
+// removeSFTPDirs removes the empty folders under name and name, deepest first.
+func removeSFTPDirs(ctx context.Context, c *sftpConn, name string) {
+	entries, err := c.readDir(ctx, name)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			removeSFTPDirs(ctx, c, path.Join(name, entry.Name()))
+		}
+	}
+	c.do(ctx, func() error { return c.RemoveDirectory(name) })
+}
+
+// CopyPrefix copy every object under the folder from into the folder to
+func (s *SFTPStorage) CopyPrefix(from, to string) error {
+	return bucketPrefixOp(s.context(), s, "copy", from, to, func(src, dst string) error {
+		return s.CopyObject(src, dst)
+	})
+}
+
+// MovePrefix move every object under the folder from into the folder to
+func (s *SFTPStorage) MovePrefix(from, to string) error {
+	return bucketPrefixOp(s.context(), s, "move", from, to, s.MoveObject)
+}
+
+// CopyObject backup this object, the data goes through the client as sftp
+// copies no file on the server. dst must satisfy the preconditions of options.
+func (s *SFTPStorage) CopyObject(src, dst string, options ...WriteOption) error {
+	srcClient, srcName, err := s.target(src)
+	if err != nil {
+		return err
+	}
+	dstClient, dstName, err := s.target(dst)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.transfer()
+	defer cancel()
+	r, err := openSFTPReader(ctx, srcClient, srcName)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := createSFTPFile(ctx, dstClient, dstName, newWriteOptions(options))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	return w.Close()
+}
+
+// MoveObject rename this object, over dst if it exists.
+func (s *SFTPStorage) MoveObject(src, dst string) error {
+	srcOpts, err := parseObj(src)
+	if err != nil {
+		return err
+	}
+	dstOpts, err := parseObj(dst)
+	if err != nil {
+		return err
+	}
+	if srcOpts.Bucket != dstOpts.Bucket {
+		if err := s.CopyObject(src, dst); err != nil {
+			return err
+		}
+		return s.RemoveObject(src)
+	}
+	c, err := s.client(srcOpts.Bucket)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	if err := c.do(ctx, func() error { return c.MkdirAll(path.Dir(slash + dstOpts.Key)) }); err != nil {
+		return err
+	}
+	return c.replace(ctx, slash+srcOpts.Key, slash+dstOpts.Key)
+}
+
+// IsExist return false if node doesn't exist
+func (s *SFTPStorage) IsExist(node string) bool {
+	_, err := s.Stat(node)
+	return err == nil
+}
+
+// Stat return the size and the modification time of the file, sftp keeps no
+// checksum, creation time nor metadata. A folder is no object.
+func (s *SFTPStorage) Stat(node string) (*Object, error) {
+	c, name, err := s.target(node)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	fi, err := c.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, ErrCodeNoSuchKey
+	}
+	return &Object{
+		FileName: node,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime().Unix(),
+		Created:  fi.ModTime(),
+		Updated:  fi.ModTime(),
+	}, nil
+}
+
+// sftpObject return the object of the file key of host.
+func sftpObject(host, key string, fi os.FileInfo) *Object {
+	return &Object{
+		FileName: fmt.Sprintf("sftp://%s/%s", host, strings.TrimPrefix(key, slash)),
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime().Unix(),
+		Created:  fi.ModTime(),
+		Updated:  fi.ModTime(),
+	}
+}
+
+// ListObjects return all files via prefix dir
+func (s *SFTPStorage) ListObjects(dir string) ([]*Object, int64, error) {
+	objs, _, err := s.listPage(dir, "", 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int64
+	for _, obj := range objs {
+		size += obj.Size
+	}
+	return objs, size, nil
+}
+
+func (s *SFTPStorage) ListChildObjects(dir string) ([]*Object, int64, error) {
+	entries, host, key, err := s.readDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	objs := make([]*Object, 0, len(entries))
+	var size int64
+	for _, entry := range entries {
+		if !entry.IsDir() && !isTempName(entry.Name()) {
+			objs = append(objs, sftpObject(host, path.Join(key, entry.Name()), entry))
+			size += entry.Size()
+		}
+	}
+	return objs, size, nil
+}
+
+// ListDirs return all dirs via prefix dir
+func (s *SFTPStorage) ListDirs(dir string) ([]string, error) {
+	entries, host, key, err := s.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, fmt.Sprintf("sftp://%s/%s/", host, strings.TrimPrefix(path.Join(key, entry.Name()), slash)))
+		}
+	}
+	return dirs, nil
+}
+
+// readDir return the entries of the folder dir sorted by name, none if it does
+// not exist, as a bucket lists no object under a missing prefix.
+func (s *SFTPStorage) readDir(dir string) ([]os.FileInfo, string, string, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, "", "", err
+	}
+	c, err := s.client(opts.Bucket)
+	if err != nil {
+		return nil, "", "", err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	key := slash + opts.Key
+	entries, err := c.readDir(ctx, key)
+	if err == ErrCodeNoSuchKey {
+		return nil, opts.Bucket, key, nil
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, opts.Bucket, key, nil
+}
+
+// Glob calls fn for the objects matching pattern, under the folder of the pattern.
+func (s *SFTPStorage) Glob(pattern string, fn WalkFunc) error {
+	return globWalk(s, pattern, fn)
+}
+
+// ListVersions fails with ErrNotSupported, sftp keeps no version.
+func (s *SFTPStorage) ListVersions(node string) ([]*Object, error) {
+	return nil, fmt.Errorf("%w: versions of %s", ErrNotSupported, node)
+}
+
+// RestoreVersion fails with ErrNotSupported, see ListVersions.
+func (s *SFTPStorage) RestoreVersion(node string, generation int64) error {
+	return fmt.Errorf("%w: versions of %s", ErrNotSupported, node)
+}
+
+// SignURL fails with ErrNotSupported.
+func (s *SFTPStorage) SignURL(node, method string, expiry time.Duration) (string, error) {
+	return "", fmt.Errorf("%w: signed url of %s", ErrNotSupported, node)
+}
+
+// ListPage return a page of the objects under the folder dir, the page token is
+// the key of the last object of the page, relative to dir.
+func (s *SFTPStorage) ListPage(dir, pageToken string, pageSize int) ([]*Object, string, error) {
+	return s.listPage(dir, pageToken, pageSize)
+}
+
+// listPage lists the files under dir after the key after, all of them if pageSize is 0.
+func (s *SFTPStorage) listPage(dir, after string, pageSize int) ([]*Object, string, error) {
+	opts, err := parseObj(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := s.client(opts.Bucket)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := s.operation()
+	defer cancel()
+	root := slash + opts.Key
+	objs := make([]*Object, 0)
+	var next, last string
+	err = walkSFTPSorted(ctx, c, root, "", after, func(key string, fi os.FileInfo) error {
+		if pageSize > 0 && len(objs) == pageSize {
+			next = last
+			return ErrStopWalk
+		}
+		objs = append(objs, sftpObject(opts.Bucket, path.Join(root, key), fi))
+		last = key
+		return nil
+	})
+	if err == ErrCodeNoSuchKey {
+		return objs, "", nil
+	}
+	if err != nil && err != ErrStopWalk {
+		return nil, "", err
+	}
+	return objs, next, nil
+}
+
+// walkSFTPSorted calls fn for the files below root/rel whose key sorts after
+// the key after, in lexical order of the keys, as FileStorage.walkSorted.
+func walkSFTPSorted(ctx context.Context, c *sftpConn, root, rel, after string, fn func(key string, fi os.FileInfo) error) error {
+	entries, err := c.readDir(ctx, path.Join(root, rel))
+	if err != nil {
+		return err
+	}
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = path.Join(rel, entry.Name())
+		if entry.IsDir() {
+			keys[i] += slash
+		}
+	}
+	sort.Sort(sftpEntries{entries, keys})
+	for i, entry := range entries {
+		key := keys[i]
+		if !entry.IsDir() {
+			if key > after && !isTempName(entry.Name()) {
+				if err := fn(key, entry); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if key < after && !strings.HasPrefix(after, key) {
+			continue
+		}
+		if err := walkSFTPSorted(ctx, c, root, strings.TrimSuffix(key, slash), after, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sftpEntries sorts the entries of a folder by their keys.
+type sftpEntries struct {
+	entries []os.FileInfo
+	keys    []string
+}
+
+func (e sftpEntries) Len() int           { return len(e.keys) }
+func (e sftpEntries) Less(i, j int) bool { return e.keys[i] < e.keys[j] }
+func (e sftpEntries) Swap(i, j int) {
+	e.entries[i], e.entries[j] = e.entries[j], e.entries[i]
+	e.keys[i], e.keys[j] = e.keys[j], e.keys[i]
+}
+
+// Walk calls fn for every object under the folder dir
+func (s *SFTPStorage) Walk(dir string, fn WalkFunc) error {
+	return walk(s.context(), s, dir, fn)
+}
+
+// Download download file to local
+func (s *SFTPStorage) Download(from, to string, options ...WriteOption) error {
+	c, name, err := s.target(from)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.transfer()
+	defer cancel()
+	fi, err := c.stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	r, err := openSFTPReader(ctx, c, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	file, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	p := newProgress(newWriteOptions(options).progress, fi.Size())
+	if _, err := io.Copy(&progressWriter{w: file, progress: p}, r); err != nil {
+		return err
+	}
+	p.done()
+	return nil
+}
+
+// Upload put file to remote, see OpenWriter.
+func (s *SFTPStorage) Upload(from, to string, options ...WriteOption) error {
+	o := newWriteOptions(options)
+	file, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := newCustomReader(file, o.progress)
+	if err != nil {
+		return err
+	}
+	c, name, err := s.target(to)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.transfer()
+	defer cancel()
+	w, err := createSFTPFile(ctx, c, name, o)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	reader.done()
+	return nil
+}
+
+// OpenReader return a stream of the object
+func (s *SFTPStorage) OpenReader(node string) (io.ReadCloser, error) {
+	c, name, err := s.target(node)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.transfer()
+	r, err := openSFTPReader(ctx, c, name)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r.cancel = cancel
+	return r, nil
+}
+
+// OpenWriter return a stream writing into a temporary file next to node,
+// renamed to node on Close so that the file is never seen half written. The
+// options support IfNotExist only, the content type and the metadata are not kept.
+func (s *SFTPStorage) OpenWriter(node string, options ...WriteOption) (io.WriteCloser, error) {
+	c, name, err := s.target(node)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.transfer()
+	w, err := createSFTPFile(ctx, c, name, newWriteOptions(options))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	w.cancel = cancel
+	return w, nil
+}
+
+// sftpReader reads a file from its start, WriteTo reads it by concurrent requests.
+type sftpReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	c      *sftpConn
+	file   *sftp.File
+	stop   func()
+}
+
+func openSFTPReader(ctx context.Context, c *sftpConn, name string) (*sftpReader, error) {
+	var file *sftp.File
+	err := c.do(ctx, func() (err error) {
+		file, err = c.Open(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sftpReader{ctx: ctx, cancel: func() {}, c: c, file: file, stop: c.watch(ctx)}, nil
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	if err == io.EOF {
+		return n, err
+	}
+	return n, sftpError(r.ctx, r.c.check(err))
+}
+
+func (r *sftpReader) WriteTo(w io.Writer) (int64, error) {
+	n, err := r.file.WriteTo(w)
+	return n, sftpError(r.ctx, r.c.check(err))
+}
+
+func (r *sftpReader) Close() error {
+	defer r.cancel()
+	defer r.stop()
+	return sftpError(r.ctx, r.c.check(r.file.Close()))
+}
+
+// sftpWriter writes a temporary file renamed to its name on Close, ReadFrom
+// writes it by concurrent requests.
+type sftpWriter struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	stop       func()
+	c          *sftpConn
+	file       *sftp.File
+	name       string
+	temp       string
+	ifNotExist bool
+}
+
+// createSFTPFile return a writer of the file name, its folder is created if needed.
+func createSFTPFile(ctx context.Context, c *sftpConn, name string, o *writeOptions) (*sftpWriter, error) {
+	if o.ifGeneration != nil {
+		return nil, fmt.Errorf("%w: sftp generation precondition", ErrNotSupported)
+	}
+	if o.ifNotExist {
+		if _, err := c.stat(ctx, name); err == nil {
+			return nil, fmt.Errorf("%w: %s exists", ErrPreconditionFailed, name)
+		}
+	}
+	if err := c.do(ctx, func() error { return c.MkdirAll(path.Dir(name)) }); err != nil {
+		return nil, err
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	temp := path.Join(path.Dir(name), "."+path.Base(name)+".tmp-"+hex.EncodeToString(suffix))
+	var file *sftp.File
+	err := c.do(ctx, func() (err error) {
+		file, err = c.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_EXCL)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sftpWriter{ctx: ctx, cancel: func() {}, stop: c.watch(ctx), c: c, file: file, name: name, temp: temp, ifNotExist: o.ifNotExist}, nil
+}
+
+func (w *sftpWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	return n, sftpError(w.ctx, w.c.check(err))
+}
+
+func (w *sftpWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := w.file.ReadFrom(r)
+	return n, sftpError(w.ctx, w.c.check(err))
+}
+
+// Close renames the file to its name, a plain rename failing if the name
+// exists when the writer was opened with IfNotExist.
+func (w *sftpWriter) Close() error {
+	defer w.cancel()
+	w.stop()
+	err := w.c.do(w.ctx, w.file.Close)
+	if err == nil && w.ifNotExist {
+		if err = w.c.do(w.ctx, func() error { return w.c.Rename(w.temp, w.name) }); err != nil {
+			if _, statErr := w.c.stat(w.ctx, w.name); statErr == nil {
+				err = fmt.Errorf("%w: %s exists", ErrPreconditionFailed, w.name)
+			}
+		}
+	} else if err == nil {
+		err = w.c.replace(w.ctx, w.temp, w.name)
+	}
+	if err != nil {
+		w.c.do(w.ctx, func() error { return w.c.Remove(w.temp) })
+	}
+	return err
+}
+
+// CloseWithError removes the temporary file, nothing is written to the file.
+func (w *sftpWriter) CloseWithError(err error) error {
+	defer w.cancel()
+	w.stop()
+	w.file.Close()
+	return w.c.do(w.ctx, func() error { return w.c.Remove(w.temp) })
+}
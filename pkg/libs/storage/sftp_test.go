@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSFTPStorage(f *fakeSFTP, opts map[string]interface{}) *SFTPStorage {
+	if opts == nil {
+		opts = map[string]interface{}{"SFTPUser": "copilot", "SFTPPassword": f.password}
+	}
+	opts["SFTPHostKey"] = f.hostKey
+	return NewSFTPStorage(opts)
+}
+
+func TestSFTPStorage(t *testing.T) {
+	fake := newFakeSFTP(t)
+	client := newTestSFTPStorage(fake, nil)
+	bucket := "sftp://" + fake.addr + "/drop"
+
+	prefix := bucket + "/audience_1_tenant"
+	for _, v := range mockFiles {
+		assert.Nil(t, client.PutObject(fmt.Sprintf(v, bucket, 1), []byte(mockContent)))
+	}
+	bitmap := client.PathJoin(prefix, "bitmap")
+
+	data, err := client.GetObject(bitmap)
+	assert.Nil(t, err)
+	assert.Equal(t, mockContent, string(data))
+
+	_, err = client.GetObject(client.PathJoin(prefix, "missing"))
+	assert.Equal(t, ErrCodeNoSuchKey, err)
+
+	obj, err := client.Stat(bitmap)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(mockContent)), obj.Size)
+	_, err = client.Stat(client.PathJoin(prefix, "attrs"))
+	assert.Equal(t, ErrCodeNoSuchKey, err)
+
+	objs, size, err := client.ListObjects(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, 12, len(objs))
+	assert.Equal(t, int64(12*len(mockContent)), size)
+
+	objs, _, err = client.ListChildObjects(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, 9, len(objs))
+
+	dirs, err := client.ListDirs(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{prefix + "/attrs/"}, dirs)
+
+	objs, err = globAll(client, bucket+"/*/attrs/*-00?")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{prefix + "/attrs/PART-001", prefix + "/attrs/PART-002", prefix + "/attrs/PART-003"}, ObjectsToStrings(objs))
+
+	// pages in the order of the keys, attrs/ sorting before bitmap.
+	page, next, err := client.ListPage(prefix, "", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{prefix + "/PART-001", prefix + "/PART-002", prefix + "/PART-003", prefix + "/PART-004", prefix + "/PART-005"}, ObjectsToStrings(page))
+	page, next, err = client.ListPage(prefix, next, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{prefix + "/_SUCCESS", prefix + "/attrs/PART-001", prefix + "/attrs/PART-002", prefix + "/attrs/PART-003", prefix + "/bitmap"}, ObjectsToStrings(page))
+	page, next, err = client.ListPage(prefix, next, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(page))
+	assert.Empty(t, next)
+
+	objs, _, err = client.ListObjects(bucket + "/missing")
+	assert.Nil(t, err)
+	assert.Empty(t, objs)
+
+	err = client.PutObject(bitmap, []byte("other"), IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	copied := client.PathJoin(prefix, "copy with space")
+	assert.Nil(t, client.CopyObject(bitmap, copied))
+	assert.True(t, client.IsExist(copied))
+	err = client.CopyObject(bitmap, copied, IfNotExist())
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+
+	moved := client.PathJoin(bucket, "REJECT/moved")
+	assert.Nil(t, client.MoveObject(copied, moved))
+	assert.False(t, client.IsExist(copied))
+	assert.True(t, client.IsExist(moved))
+	assert.Nil(t, client.MoveObject(moved, bitmap))
+	assert.False(t, client.IsExist(moved))
+
+	tempDir, err := ioutil.TempDir("", "sftpStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	local := filepath.Join(tempDir, "bitmap")
+	assert.Nil(t, client.Download(bitmap, local))
+	assert.Nil(t, client.Upload(local, client.PathJoin(prefix, "uploaded")))
+	data, err = client.GetObject(client.PathJoin(prefix, "uploaded"))
+	assert.Nil(t, err)
+	assert.Equal(t, mockContent, string(data))
+
+	assert.Nil(t, client.RemoveAll(prefix))
+	objs, _, err = client.ListObjects(prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(objs))
+	// no temporary file is left behind.
+	assert.Equal(t, []string{}, fake.files())
+}
+
+func TestSFTPStorage_Streams(t *testing.T) {
+	fake := newFakeSFTP(t)
+	client := newTestSFTPStorage(fake, nil)
+	node := "sftp://" + fake.addr + "/in/streamed.csv"
+
+	large := strings.Repeat("0123456789", 10000)
+	for i := 0; i < 2; i++ {
+		w, err := client.OpenWriter(node)
+		assert.Nil(t, err)
+		fmt.Fprint(w, large[:len(large)-i])
+		// the file staged by the writer is listed by no listing.
+		objs, _, err := client.ListChildObjects("sftp://" + fake.addr + "/in")
+		assert.Nil(t, err)
+		assert.Equal(t, i, len(objs))
+		objs, _, err = client.ListObjects("sftp://" + fake.addr + "/in")
+		assert.Nil(t, err)
+		assert.Equal(t, i, len(objs))
+		assert.Nil(t, w.Close())
+	}
+	r, err := client.OpenReader(node)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, large[:len(large)-1], string(data))
+
+	// large files are read and written by concurrent requests.
+	tempDir, err := ioutil.TempDir("", "sftpStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	largeFile := strings.Repeat(large, 20)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "large.csv"), []byte(largeFile), 0640))
+	uploaded := "sftp://" + fake.addr + "/in/uploaded.csv"
+	assert.Nil(t, client.Upload(filepath.Join(tempDir, "large.csv"), uploaded))
+	assert.Nil(t, client.Download(uploaded, filepath.Join(tempDir, "large.out")))
+	data, err = ioutil.ReadFile(filepath.Join(tempDir, "large.out"))
+	assert.Nil(t, err)
+	assert.Equal(t, largeFile, string(data))
+	assert.Nil(t, client.RemoveObject(uploaded))
+
+	w, err := client.OpenWriter("sftp://" + fake.addr + "/in/aborted.csv")
+	assert.Nil(t, err)
+	fmt.Fprint(w, "partial data")
+	assert.Nil(t, AbortWriter(w, io.ErrUnexpectedEOF))
+	assert.False(t, client.IsExist("sftp://"+fake.addr+"/in/aborted.csv"))
+	assert.Equal(t, []string{"in/streamed.csv"}, fake.files())
+
+	// a dropped connection is dialed again.
+	fake.dropConns()
+	assert.False(t, client.IsExist(node))
+	assert.True(t, client.IsExist(node))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.WithContext(ctx).GetObject(node)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestSFTPStorage_Retry(t *testing.T) {
+	fake := newFakeSFTP(t)
+	client := newTestSFTPStorage(fake, nil)
+	node := "sftp://" + fake.addr + "/in/a.csv"
+	assert.Nil(t, client.PutObject(node, []byte(mockContent)))
+
+	// the session lost in the middle of the call is dialed again by the retry.
+	fake.dropReads.Store(1)
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	data, err := NewRetryStorage(client, "sftp", policy).GetObject(node)
+	assert.Nil(t, err)
+	assert.Equal(t, mockContent, string(data))
+	assert.Equal(t, int32(-1), fake.dropReads.Load())
+
+	fake.dropReads.Store(1)
+	_, err = client.GetObject(node)
+	assert.True(t, IsRetryable(err), err)
+}
+
+func TestSFTPStorage_Auth(t *testing.T) {
+	fake := newFakeSFTP(t)
+	node := "sftp://" + fake.addr + "/in/a.csv"
+	assert.Nil(t, newTestSFTPStorage(fake, nil).PutObject(node, []byte("a")))
+
+	byKey := newTestSFTPStorage(fake, map[string]interface{}{"SFTPUser": "copilot", "SFTPPrivateKey": fake.userKey})
+	assert.True(t, byKey.IsExist(node))
+	// the user of the path wins over SFTPUser.
+	assert.True(t, byKey.IsExist("sftp://copilot@"+fake.addr+"/in/a.csv"))
+	_, err := byKey.GetObject("sftp://other@" + fake.addr + "/in/a.csv")
+	assert.NotNil(t, err)
+
+	wrongPassword := newTestSFTPStorage(fake, map[string]interface{}{"SFTPUser": "copilot", "SFTPPassword": "wrong"})
+	_, err = wrongPassword.GetObject(node)
+	assert.NotNil(t, err)
+
+	otherHost := newTestSFTPStorage(newFakeSFTP(t), nil)
+	_, err = otherHost.GetObject(node)
+	assert.NotNil(t, err)
+
+	noHostKey := NewSFTPStorage(map[string]interface{}{"SFTPUser": "copilot", "SFTPPassword": fake.password})
+	_, err = noHostKey.GetObject(node)
+	assert.NotNil(t, err)
+
+	credentials, err := json.Marshal(map[string]string{"SFTPUser": "copilot", "SFTPPassword": fake.password, "SFTPHostKey": fake.hostKey})
+	assert.Nil(t, err)
+	routed, err := NewStorageClient(node, string(credentials))
+	assert.Nil(t, err)
+	data, err := routed.GetObject(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(data))
+	assert.Equal(t, "sftp", StorageOnSFTP.ToString())
+}
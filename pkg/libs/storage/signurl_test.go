@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setFileURLKey makes FileStorage sign urls during the test.
+func setFileURLKey(t *testing.T, key, base string) {
+	oldKey, oldBase := FileURLKey, FileURLBase
+	FileURLKey, FileURLBase = []byte(key), base
+	t.Cleanup(func() {
+		FileURLKey, FileURLBase = oldKey, oldBase
+	})
+}
+
+func TestFileStorage_SignURL(t *testing.T) {
+	f := NewFileStorage(nil)
+	setFileURLKey(t, "", "")
+	_, err := f.SignURL("/data/REJECT/a.csv", http.MethodGet, time.Hour)
+	assert.True(t, errors.Is(err, ErrNotSupported), err)
+
+	setFileURLKey(t, "secret", "http://copilot:8080/v1/files")
+	_, err = f.SignURL("/data/REJECT/a.csv", http.MethodPut, time.Hour)
+	assert.True(t, errors.Is(err, ErrNotSupported), err)
+	signed, err := f.SignURL("/data/REJECT/a.csv", http.MethodGet, time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(signed, "http://copilot:8080/v1/files?"), signed)
+	u, err := url.Parse(signed)
+	assert.Nil(t, err)
+
+	node, err := VerifyFileURL(http.MethodGet, u.Query())
+	assert.Nil(t, err)
+	assert.Equal(t, "/data/REJECT/a.csv", node)
+	node, err = VerifyFileURL(http.MethodHead, u.Query())
+	assert.Nil(t, err)
+	assert.Equal(t, "/data/REJECT/a.csv", node)
+	_, err = VerifyFileURL(http.MethodDelete, u.Query())
+	assert.True(t, errors.Is(err, ErrURLSignature), err)
+
+	for key, value := range map[string]string{"node": "/etc/passwd", "expires": "4102444800", "signature": "zz"} {
+		query := u.Query()
+		query.Set(key, value)
+		_, err := VerifyFileURL(http.MethodGet, query)
+		assert.True(t, errors.Is(err, ErrURLSignature), key)
+	}
+
+	expired, err := f.SignURL("/data/REJECT/a.csv", http.MethodGet, -time.Minute)
+	assert.Nil(t, err)
+	u, err = url.Parse(expired)
+	assert.Nil(t, err)
+	_, err = VerifyFileURL(http.MethodGet, u.Query())
+	assert.True(t, errors.Is(err, ErrURLSignature), err)
+
+	// a url of another key.
+	setFileURLKey(t, "rotated", "http://copilot:8080/v1/files")
+	u, err = url.Parse(signed)
+	assert.Nil(t, err)
+	_, err = VerifyFileURL(http.MethodGet, u.Query())
+	assert.True(t, errors.Is(err, ErrURLSignature), err)
+}
+
+func TestGCSStorage_SignURL(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	token, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "copilot@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	})
+	assert.Nil(t, err)
+	g := NewGCSStorage(map[string]interface{}{"SecretAccessKey": string(token)})
+
+	signed, err := g.SignURL("gs://bucket/721211/REJECT/a.csv", http.MethodGet, 15*time.Minute)
+	assert.Nil(t, err)
+	u, err := url.Parse(signed)
+	assert.Nil(t, err)
+	assert.Equal(t, "/bucket/721211/REJECT/a.csv", u.Path)
+	assert.Equal(t, "GOOG4-RSA-SHA256", u.Query().Get("X-Goog-Algorithm"))
+	// counted from the signing, a moment after the call.
+	assert.Contains(t, []string{"899", "900"}, u.Query().Get("X-Goog-Expires"))
+	assert.True(t, strings.HasPrefix(u.Query().Get("X-Goog-Credential"), "copilot@project.iam.gserviceaccount.com/"))
+	assert.NotEmpty(t, u.Query().Get("X-Goog-Signature"))
+
+	_, err = g.SignURL("gs://bucket/721211/REJECT/a.csv", http.MethodGet, 8*24*time.Hour)
+	assert.NotNil(t, err)
+
+	encrypted := NewGCSStorage(map[string]interface{}{"SecretAccessKey": string(token), "EncryptionKey": strings.Repeat("A", 44)})
+	_, err = encrypted.SignURL("gs://bucket/721211/REJECT/a.csv", http.MethodGet, time.Minute)
+	assert.True(t, errors.Is(err, ErrNotSupported), err)
+
+	_, err = NewMemStorage(nil).SignURL("mem://bucket/a.csv", http.MethodGet, time.Minute)
+	assert.True(t, errors.Is(err, ErrNotSupported), err)
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func doStatTestCases(t *testing.T, client Storage, root string) {
+	dir := client.PathJoin(root, "721211", "stat")
+	node := client.PathJoin(dir, "a.csv")
+	metadata := map[string]string{"source": "gs://bucket/a.csv", "rule-version": "1"}
+
+	_, err := client.Stat(node)
+	assert.Equal(t, ErrCodeNoSuchKey, err)
+
+	data := []byte("a,b,c\n")
+	assert.Nil(t, client.PutObject(node, data, WithMetadata(metadata)))
+	obj, err := client.Stat(node)
+	assert.Nil(t, err)
+	assert.Equal(t, node, obj.FileName)
+	assert.Equal(t, int64(len(data)), obj.Size)
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum(data)), obj.Sum)
+	assert.Equal(t, "text/csv; charset=utf-8", obj.ContentType)
+	assert.Equal(t, metadata, obj.Metadata)
+	assert.False(t, obj.Updated.IsZero())
+
+	// a write without options drops the metadata of the previous one.
+	assert.Nil(t, client.PutObject(node, data))
+	obj, err = client.Stat(node)
+	assert.Nil(t, err)
+	assert.Empty(t, obj.Metadata)
+
+	w, err := client.OpenWriter(node, WithMetadata(metadata), WithContentType("application/x-ae"))
+	assert.Nil(t, err)
+	w.Write(data)
+	assert.Nil(t, w.Close())
+	obj, err = client.Stat(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "application/x-ae", obj.ContentType)
+	assert.Equal(t, metadata, obj.Metadata)
+
+	// copies keep the metadata.
+	copied := client.PathJoin(dir, "b.csv")
+	assert.Nil(t, client.CopyObject(node, copied))
+	obj, err = client.Stat(copied)
+	assert.Nil(t, err)
+	assert.Equal(t, metadata, obj.Metadata)
+
+	local, err := ioutil.TempFile("", "stat")
+	assert.Nil(t, err)
+	defer os.Remove(local.Name())
+	local.Write(data)
+	local.Close()
+	uploaded := client.PathJoin(dir, "c.csv")
+	assert.Nil(t, client.Upload(local.Name(), uploaded, WithMetadata(map[string]string{"source": local.Name()})))
+	obj, err = client.Stat(uploaded)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"source": local.Name()}, obj.Metadata)
+
+	// the metadata is not listed as objects.
+	objs, _, err := client.ListObjects(dir)
+	assert.Nil(t, err)
+	assert.Len(t, objs, 3)
+}
+
+func TestStat(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	t.Run("file", func(t *testing.T) {
+		doStatTestCases(t, NewFileStorage(nil), tempDir)
+	})
+	t.Run("gcs", func(t *testing.T) {
+		newFakeGCS(t)
+		doStatTestCases(t, NewGCSStorage(nil), "gs://bucket")
+	})
+	t.Run("s3", func(t *testing.T) {
+		_, srv := newFakeS3(t)
+		doStatTestCases(t, newTestS3Storage(srv.URL), "s3://bucket")
+	})
+	t.Run("mem", func(t *testing.T) {
+		doStatTestCases(t, NewMemStorage(nil), "mem://stat")
+	})
+}
+
+func TestFileStorage_StatSumCached(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	client := NewFileStorage(nil)
+	node := filepath.Join(tempDir, "a.csv")
+	assert.NoError(t, client.PutObject(node, []byte("a,b\n")))
+	obj, err := client.Stat(node)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte("a,b\n"))), obj.Sum)
+
+	// same size and modification time, the file is not hashed again.
+	assert.NoError(t, ioutil.WriteFile(node, []byte("c,d\n"), 0640))
+	assert.NoError(t, os.Chtimes(node, obj.Updated, obj.Updated))
+	cached, err := client.Stat(node)
+	assert.NoError(t, err)
+	assert.Equal(t, obj.Sum, cached.Sum)
+
+	later := obj.Updated.Add(time.Second)
+	assert.NoError(t, os.Chtimes(node, later, later))
+	changed, err := client.Stat(node)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte("c,d\n"))), changed.Sum)
+}
+
+func TestFileStorage_MetaSidecar(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	client := NewFileStorage(nil)
+	from, to := filepath.Join(tempDir, "from"), filepath.Join(tempDir, "to")
+	metadata := map[string]string{"source": "a"}
+	assert.Nil(t, client.PutObject(filepath.Join(from, "x", "a.csv"), nil, WithMetadata(metadata)))
+
+	assert.Nil(t, client.MovePrefix(from, to))
+	obj, err := client.Stat(filepath.Join(to, "x", "a.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, metadata, obj.Metadata)
+	assert.False(t, client.IsExist(fileMetaPath(filepath.Join(from, "x", "a.csv"))))
+
+	dirs, err := client.ListDirs(filepath.Join(to, "x"))
+	assert.Nil(t, err)
+	assert.Empty(t, dirs)
+
+	assert.Nil(t, client.RemoveObject(filepath.Join(to, "x", "a.csv")))
+	assert.False(t, client.IsExist(fileMetaPath(filepath.Join(to, "x", "a.csv"))))
+}
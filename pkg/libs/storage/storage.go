@@ -1,8 +1,8 @@
 package storage
 
 import (
-	"encoding/json"
-	"strings"
+	"context"
+	"io"
 	"time"
 )
 
@@ -18,26 +18,18 @@ const (
 
 type StorageType int
 
+// ToString return the name of the backend registered for s.
 func (s StorageType) ToString() string {
-	switch s {
-	case StorageInLocal:
-		return "local"
-	case StorageOnAWS:
-		return "aws"
-	case StorageOnGCP:
-		return "gcp"
+	if b, ok := backendOf(s); ok {
+		return b.Name
 	}
 	return ""
 }
 
+// Protocol return the scheme of the backend registered for s.
 func (s StorageType) Protocol() string {
-	switch s {
-	case StorageInLocal:
-		return ""
-	case StorageOnAWS:
-		return "s3://"
-	case StorageOnGCP:
-		return "gs://"
+	if b, ok := backendOf(s); ok {
+		return b.Scheme
 	}
 	return ""
 }
@@ -46,6 +38,7 @@ const (
 	StorageInLocal StorageType = iota
 	StorageOnAWS
 	StorageOnGCP
+	StorageInMemory
 )
 
 type Object struct {
@@ -55,6 +48,16 @@ type Object struct {
 	Sum      string
 	Created  time.Time
 	Updated  time.Time
+	// Filled by Stat only, Generation by ListVersions too.
+	ContentType string
+	// ContentEncoding is "gzip" for an object served decompressed, whose Size
+	// and Sum are the ones of the stored bytes.
+	ContentEncoding string
+	Generation      int64
+	Metadata        map[string]string
+	// Deleted is when a version listed by ListVersions stopped being the live
+	// content of the object, zero for the live version.
+	Deleted time.Time
 }
 
 // 100 ... 10000 => 100M ... 10000M
@@ -65,43 +68,89 @@ type Object struct {
 
 type Storage interface {
 	GetObject(node string) ([]byte, error)
-	PutObject(node string, data []byte) error
-	RemoveObject(node string) error
+	PutObject(node string, data []byte, opts ...WriteOption) error
+	// RemoveObject removes node, the options of a remove are its preconditions.
+	RemoveObject(node string, opts ...WriteOption) error
 	RemoveDir(node string) error
+	// RemoveAll removes every object under the folder node.
 	RemoveAll(node string) error
-	CopyObject(from, to string) error
+	// CopyObject copies from to to, the options of a copy are the preconditions of to.
+	CopyObject(from, to string, opts ...WriteOption) error
 	MoveObject(from, to string) error
+	// CopyPrefix and MovePrefix process every object under the folder from in
+	// parallel, failed objects are reported by a *PrefixError.
+	CopyPrefix(from, to string) error
+	MovePrefix(from, to string) error
 	IsExist(node string) bool
+	// Stat return the attributes and the custom metadata of node, ErrCodeNoSuchKey if it does not exist.
+	Stat(node string) (*Object, error)
 	ListObjects(dir string) ([]*Object, int64, error)
 	ListChildObjects(dir string) ([]*Object, int64, error)
 	ListDirs(dir string) ([]string, error)
-	Download(from, to string) error
-	Upload(from, to string) error
+	// ListPage return at most pageSize objects under the folder dir, in lexical
+	// order of their names, starting after pageToken, and the token of the next
+	// page. An empty token starts the listing and an empty next token ends it.
+	ListPage(dir, pageToken string, pageSize int) ([]*Object, string, error)
+	// Walk calls fn for every object under the folder dir page by page, in the
+	// order of ListPage. It stops at the first error of fn, see ErrStopWalk.
+	Walk(dir string, fn WalkFunc) error
+	// ListVersions return the versions of node kept by the storage, oldest first,
+	// see Object.Deleted. The storages keeping none fail with ErrNotSupported.
+	ListVersions(node string) ([]*Object, error)
+	// RestoreVersion makes the version generation of node, as listed by
+	// ListVersions, its live content again, the live content is kept as a version.
+	RestoreVersion(node string, generation int64) error
+	// Glob calls fn for every object matching pattern in lexical order, as the
+	// objects are listed, see Walk. * matches the characters of a name, ? one of
+	// them and ** any number of folders, e.g. gs://bucket/*/REJECT/**/*.csv.
+	// The bucket of a pattern is not matched.
+	Glob(pattern string, fn WalkFunc) error
+	// SignURL return a url granting its holder the http method, e.g. GET, on
+	// node until expiry has passed, without credentials of their own.
+	SignURL(node, method string, expiry time.Duration) (string, error)
+	// Download copies the object from to the local file to, see WithProgress.
+	Download(from, to string, opts ...WriteOption) error
+	Upload(from, to string, opts ...WriteOption) error
+	// OpenReader streams the content of node, the caller must close it.
+	OpenReader(node string) (io.ReadCloser, error)
+	// OpenWriter streams data into node, the object is committed on Close.
+	// Writers also implement CloseWithError, see AbortWriter.
+	OpenWriter(node string, opts ...WriteOption) (io.WriteCloser, error)
+	// WithContext return a copy of the storage whose calls are bound to ctx,
+	// each call is further limited by OperationTimeout or TransferTimeout.
+	WithContext(ctx context.Context) Storage
 
 	PathJoin(items ...string) string
 }
 
-// NewStorage return a new Storage
+// NewStorage return a new Storage, retrying its calls if RetryPolicies has a policy for t.
+// The downloads of a remote backend go through Cache, if set.
 func NewStorage(t StorageType, opts map[string]interface{}) Storage {
-	switch t {
-	case StorageOnGCP:
-		return NewGCSStorage(opts)
-	default:
-		return NewFileStorage(nil)
+	s := newStorage(t, opts)
+	if policy, ok := RetryPolicies[t]; ok && policy.MaxAttempts > 1 {
+		s = NewRetryStorage(s, t.ToString(), policy)
 	}
+	if Cache != nil && t != StorageInLocal {
+		s = NewCachingStorage(s, Cache)
+	}
+	return s
 }
 
-func NewStorageClient(ossPath, credentials string) Storage {
-	if strings.HasPrefix(ossPath, StorageOnGCP.Protocol()) {
-		return NewStorage(StorageOnGCP, generateOpts(credentials))
+// newStorage return a client of the backend registered for t, local files if there is none.
+func newStorage(t StorageType, opts map[string]interface{}) Storage {
+	if b, ok := backendOf(t); ok {
+		return b.New(opts)
 	}
-	return NewStorage(StorageInLocal, nil)
+	return NewFileStorage(nil)
 }
 
-func generateOpts(credentials string) map[string]interface{} {
-	var opts map[string]interface{}
-	if err := json.Unmarshal([]byte(credentials), &opts); err != nil {
-		panic(err)
+// NewStorageClient return a Storage routing each call to the backend of the
+// scheme of its path. The credentials of ossPath are checked here, the client of
+// another scheme is created on its first call, with the same credentials.
+func NewStorageClient(ossPath, credentials string) (Storage, error) {
+	client := newRoutingStorage(credentials)
+	if _, err := client.backend(ossPath); err != nil {
+		return nil, err
 	}
-	return opts
+	return client, nil
 }
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -44,11 +46,7 @@ func mockTestFiles(storageType StorageType, bucket string, t *testing.T) (Storag
 }
 
 func TestStorage(t *testing.T) {
-	tempDir, err := ioutil.TempDir("./", "fileStorage")
-	if err != nil {
-		t.Fail()
-	}
-	defer os.RemoveAll(tempDir)
+	tempDir := t.TempDir()
 
 	client, files := mockTestFiles(StorageInLocal, tempDir, t)
 	doStorageTestCases(client, files, tempDir, t)
@@ -91,3 +89,31 @@ func doStorageTestCases(client Storage, files [12]string, tempDir string, t *tes
 
 	//assert.Equal(t,false,client.IsExist(files[0]))
 }
+
+func TestWithContext(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	_, srv := newFakeS3(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	clients := map[string]Storage{
+		tempDir + "/a":  NewFileStorage(nil),
+		"s3://bucket/a": newTestS3Storage(srv.URL),
+	}
+	for node, client := range clients {
+		assert.Nil(t, client.PutObject(node, []byte(mockContent)))
+
+		err := client.WithContext(ctx).PutObject(node, []byte(mockContent))
+		assert.True(t, errors.Is(err, context.Canceled), node)
+		_, err = client.WithContext(ctx).GetObject(node)
+		assert.True(t, errors.Is(err, context.Canceled), node)
+
+		r, err := client.OpenReader(node)
+		assert.Nil(t, err)
+		assert.Nil(t, r.Close())
+	}
+}
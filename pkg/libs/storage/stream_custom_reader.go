@@ -5,33 +5,47 @@ import (
 	"sync/atomic"
 )
 
+// CustomReader reads a local file being uploaded and reports the bytes read to
+// the progress of the upload.
 type CustomReader struct {
-	fp   *os.File
-	size int64
-	read int64
+	fp       *os.File
+	size     int64
+	read     int64
+	progress *progress
+}
+
+// newCustomReader return a reader of fp reporting to fn, fn may be nil.
+func newCustomReader(fp *os.File, fn ProgressFunc) (*CustomReader, error) {
+	info, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &CustomReader{fp: fp, size: info.Size(), progress: newProgress(fn, info.Size())}, nil
 }
 
 func (r *CustomReader) Read(p []byte) (int, error) {
-	return r.fp.Read(p)
+	n, err := r.fp.Read(p)
+	r.count(n)
+	return n, err
 }
 
 func (r *CustomReader) ReadAt(p []byte, off int64) (int, error) {
 	n, err := r.fp.ReadAt(p, off)
-	if err != nil {
-		return n, err
-	}
-
-	// Got the length have read( or means has uploaded), and you can construct your message
-	atomic.AddInt64(&r.read, int64(n))
-
-	// I have no idea why the read length need to be div 2,
-	// maybe the request read once when Sign and actually send call ReadAt again
-	// It works for me
-	//log.Printf("total read:%d    progress:%d%%\n", r.read/2, int(float32(r.read*100/2)/float32(r.size)))
-
+	r.count(n)
 	return n, err
 }
 
 func (r *CustomReader) Seek(offset int64, whence int) (int64, error) {
 	return r.fp.Seek(offset, whence)
 }
+
+// count adds n bytes read, reads may run concurrently.
+func (r *CustomReader) count(n int) {
+	atomic.AddInt64(&r.read, int64(n))
+	r.progress.add(n)
+}
+
+// done reports the end of the upload.
+func (r *CustomReader) done() {
+	r.progress.done()
+}
@@ -3,9 +3,11 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"io"
 	usr "os/user"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -13,6 +15,9 @@ import (
 var IllegalPath = errors.New("illegal file path")
 var ErrCodeNoSuchKey = errors.New("no such key")
 
+// ErrNotSupported is returned, wrapped, for an option or a call a storage cannot honour.
+var ErrNotSupported = errors.New("not supported")
+
 const protocolFlag = "://"
 const slash = "/"
 
@@ -72,6 +77,26 @@ func FilterFilesByPrefix(objs []*Object, prefix string) ([]*Object, int64) {
 	return files, size
 }
 
+// sortVersions return the versions of node among objs, oldest first.
+func sortVersions(node string, objs []*Object) []*Object {
+	versions := make([]*Object, 0, len(objs))
+	for _, obj := range objs {
+		if obj.FileName == node {
+			versions = append(versions, obj)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Generation < versions[j].Generation })
+	return versions
+}
+
+// AbortWriter discards a writer returned by OpenWriter without committing the object.
+func AbortWriter(w io.WriteCloser, err error) error {
+	if aborter, ok := w.(interface{ CloseWithError(error) error }); ok {
+		return aborter.CloseWithError(err)
+	}
+	return w.Close()
+}
+
 // ExpandUserDir returns the argument with an initial component of ~
 func ExpandUserDir(path string) string {
 	usr, err := usr.Current()
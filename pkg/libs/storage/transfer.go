@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// TransferOption sets an option of Transfer.
+type TransferOption func(*transferOptions)
+
+type transferOptions struct {
+	move        bool
+	credentials string
+	write       []WriteOption
+}
+
+// Move makes Transfer remove the source once the destination is verified.
+func Move() TransferOption {
+	return func(o *transferOptions) {
+		o.move = true
+	}
+}
+
+// WithCredentials sets the credentials of the backends, as given to NewStorageClient.
+func WithCredentials(credentials string) TransferOption {
+	return func(o *transferOptions) {
+		o.credentials = credentials
+	}
+}
+
+// WithWriteOptions sets the options of the write of the destination, e.g. a
+// precondition. The content type and the metadata of the source are kept otherwise.
+func WithWriteOptions(opts ...WriteOption) TransferOption {
+	return func(o *transferOptions) {
+		o.write = append(o.write, opts...)
+	}
+}
+
+// Transfer copies the object src to dst, in any two registered backends, e.g.
+// gs:// to a local path or gs:// to s3://. The data is streamed through the
+// process and checked against the source, and the destination is checked once
+// written, a mismatch fails with ErrChecksumMismatch and leaves no destination.
+// With Move, src is removed only after dst is verified.
+func Transfer(ctx context.Context, src, dst string, opts ...TransferOption) error {
+	o := new(transferOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	if src == dst {
+		return fmt.Errorf("%v transfer of %s onto itself", IllegalPath, src)
+	}
+	client, err := NewStorageClient(src, o.credentials)
+	if err != nil {
+		return err
+	}
+	router := client.WithContext(ctx).(*routingStorage)
+	from, to, _, err := router.backends(src, dst)
+	if err != nil {
+		return err
+	}
+	if err := transferObject(from, to, src, dst, o.write); err != nil {
+		return err
+	}
+	if o.move {
+		return from.RemoveObject(src)
+	}
+	return nil
+}
+
+// transferObject streams src in from into dst in to and verifies both ends.
+func transferObject(from, to Storage, src, dst string, opts []WriteOption) error {
+	obj, err := from.Stat(src)
+	if err != nil {
+		return err
+	}
+	reader, err := from.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	opts = append([]WriteOption{WithContentType(obj.ContentType), WithMetadata(obj.Metadata)}, opts...)
+	if want, ok := md5Of(obj); ok && obj.ContentEncoding != "gzip" {
+		opts = append(opts, WithMD5(want))
+	}
+	writer, err := to.OpenWriter(dst, opts...)
+	if err != nil {
+		return err
+	}
+	sum := newChecksum()
+	size, err := io.Copy(io.MultiWriter(writer, sum), reader)
+	if err == nil {
+		err = verifyTransferred(src, obj, size, sum)
+	}
+	if err != nil {
+		AbortWriter(writer, err)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := verifyDestination(to, dst, size, sum); err != nil {
+		to.RemoveObject(dst)
+		return err
+	}
+	return nil
+}
+
+// verifyTransferred compares the data read, size bytes hashed into sum, with
+// the attributes of the object node.
+func verifyTransferred(node string, obj *Object, size int64, sum *checksum) error {
+	if obj.ContentEncoding == "gzip" {
+		// read decompressed, the reader of the backend checks what it can.
+		return nil
+	}
+	if size != obj.Size {
+		return fmt.Errorf("%w: %s size is %d, want %d", ErrChecksumMismatch, node, size, obj.Size)
+	}
+	if want, ok := md5Of(obj); ok {
+		return sum.verifyMD5(node, want)
+	}
+	return nil
+}
+
+// verifyDestination checks the object node written in s against the data
+// transferred, by its md5 if s keeps one or by reading it back otherwise.
+func verifyDestination(s Storage, node string, size int64, sum *checksum) error {
+	obj, err := s.Stat(node)
+	if err != nil {
+		return err
+	}
+	if _, ok := md5Of(obj); ok {
+		return verifyTransferred(node, obj, size, sum)
+	}
+	reader, err := s.OpenReader(node)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	written := newChecksum()
+	n, err := io.Copy(written, reader)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%w: %s size is %d, want %d", ErrChecksumMismatch, node, n, size)
+	}
+	return written.verifyMD5(node, sum.MD5())
+}
+
+// md5Of return the md5 of obj, if its Sum is one: the etag of an s3 multipart
+// upload and the empty md5 of a gcs composite object are not.
+func md5Of(obj *Object) ([]byte, bool) {
+	if len(obj.Sum) != 32 {
+		return nil, false
+	}
+	sum, err := hex.DecodeString(obj.Sum)
+	return sum, err == nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func doVersionsTestCases(t *testing.T, client Storage, root string) {
+	node := client.PathJoin(root, "721211", "in", "a.csv")
+	assert.Nil(t, client.PutObject(node, []byte("1"), WithMetadata(map[string]string{"rule-version": "1"})))
+	assert.Nil(t, client.PutObject(node, []byte("22"), WithMetadata(map[string]string{"rule-version": "2"})))
+
+	versions, err := client.ListVersions(node)
+	assert.Nil(t, err)
+	if !assert.Len(t, versions, 2) {
+		return
+	}
+	assert.Equal(t, int64(1), versions[0].Size)
+	assert.False(t, versions[0].Deleted.IsZero())
+	assert.Equal(t, int64(2), versions[1].Size)
+	assert.True(t, versions[1].Deleted.IsZero())
+	assert.True(t, versions[0].Generation < versions[1].Generation)
+
+	assert.Nil(t, client.RestoreVersion(node, versions[0].Generation))
+	data, err := client.GetObject(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(data))
+	obj, err := client.Stat(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", obj.Metadata["rule-version"])
+	versions, err = client.ListVersions(node)
+	assert.Nil(t, err)
+	assert.Len(t, versions, 3)
+
+	// a removed object is restored too.
+	assert.Nil(t, client.RemoveObject(node))
+	versions, err = client.ListVersions(node)
+	assert.Nil(t, err)
+	assert.Len(t, versions, 3)
+	assert.Nil(t, client.RestoreVersion(node, versions[1].Generation))
+	data, err = client.GetObject(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "22", string(data))
+
+	versions, err = client.ListVersions(node)
+	assert.Nil(t, err)
+	err = client.RestoreVersion(node, versions[len(versions)-1].Generation+1000)
+	assert.True(t, errors.Is(err, ErrCodeNoSuchKey), err)
+	versions, err = client.ListVersions(client.PathJoin(root, "721211", "in", "missing.csv"))
+	assert.Nil(t, err)
+	assert.Empty(t, versions)
+}
+
+func TestVersions(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	t.Run("file", func(t *testing.T) {
+		defer func(versions int) { FileVersions = versions }(FileVersions)
+		FileVersions = 10
+		doVersionsTestCases(t, NewFileStorage(nil), tempDir)
+	})
+	t.Run("gcs", func(t *testing.T) {
+		newFakeGCS(t)
+		doVersionsTestCases(t, NewGCSStorage(nil), "gs://bucket")
+	})
+	_, err = NewMemStorage(nil).ListVersions("mem://versions/a.csv")
+	assert.True(t, errors.Is(err, ErrNotSupported), err)
+}
+
+func TestFileStorage_VersionsOff(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	client := NewFileStorage(nil)
+	node := client.PathJoin(tempDir, "a.csv")
+	assert.Nil(t, client.PutObject(node, []byte("1")))
+	assert.Nil(t, client.PutObject(node, []byte("2")))
+	versions, err := client.ListVersions(node)
+	assert.Nil(t, err)
+	assert.Len(t, versions, 1)
+	assert.False(t, isExist(fileVersionsDir(node)))
+}
+
+func TestFileStorage_VersionsPruned(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(versions int) { FileVersions = versions }(FileVersions)
+	FileVersions = 2
+	client := NewFileStorage(nil)
+	node := client.PathJoin(tempDir, "a.csv")
+	for _, data := range []string{"1", "2", "3", "4"} {
+		assert.Nil(t, client.PutObject(node, []byte(data)))
+	}
+	versions, err := client.ListVersions(node)
+	assert.Nil(t, err)
+	assert.Len(t, versions, 3)
+
+	// the oldest backup is restored although the backup of the live file prunes it.
+	assert.Nil(t, client.RestoreVersion(node, versions[0].Generation))
+	data, err := client.GetObject(node)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(data))
+	objs, _, err := client.ListObjects(tempDir)
+	assert.Nil(t, err)
+	assert.Len(t, objs, 1, "the backups are left out of listings")
+}
+
+func TestFileStorage_DownloadUnversioned(t *testing.T) {
+	tempDir := t.TempDir()
+	defer func(versions int) { FileVersions = versions }(FileVersions)
+	FileVersions = 10
+	client := NewFileStorage(nil)
+	node := client.PathJoin(tempDir, "remote", "a.csv")
+	assert.Nil(t, client.PutObject(node, []byte("1"), WithContentType("text/csv"), WithMetadata(map[string]string{"source": "test"})))
+	local := client.PathJoin(tempDir, "local", "a.csv")
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, client.Download(node, local))
+	}
+	data, err := ioutil.ReadFile(local)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(data))
+	// only the content is downloaded, no sidecar nor backup is left locally.
+	assert.False(t, isExist(client.PathJoin(tempDir, "local", fileMetaDir)))
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// WalkPageSize is the number of objects Walk asks for per page.
+var WalkPageSize = 1000
+
+// ErrStopWalk can be returned by a WalkFunc to stop the walk, Walk then returns nil.
+var ErrStopWalk = errors.New("stop walk")
+
+// WalkFunc is called by Walk for every object, a non-nil error stops the walk.
+type WalkFunc func(obj *Object) error
+
+// walk lists the pages of dir one at a time and hands their objects to fn,
+// so only one page is held in memory and fn sees the first objects early.
+func walk(ctx context.Context, s Storage, dir string, fn WalkFunc) error {
+	var token string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		objs, next, err := s.ListPage(dir, token, WalkPageSize)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if err := fn(obj); err != nil {
+				if err == ErrStopWalk {
+					return nil
+				}
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
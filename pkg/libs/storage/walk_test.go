@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func doWalkTestCases(t *testing.T, client Storage, root string) {
+	dir := client.PathJoin(root, "721211", "REJECT")
+	// "-" < "." < "/", a folder sorts by its name with the trailing slash.
+	names := []string{"a-b.csv", "a.csv", "a.csv.scan", "a/b.csv", "a/c/d.csv", "b.csv"}
+	for _, name := range names {
+		assert.Nil(t, client.PutObject(client.PathJoin(dir, name), []byte(name)))
+	}
+	assert.Nil(t, client.PutObject(client.PathJoin(root, "721211", "REJECT2", "e.csv"), nil))
+	relative := func(objs []*Object) []string {
+		files := make([]string, 0, len(objs))
+		for _, v := range objs {
+			files = append(files, v.FileName[len(dir)+1:])
+		}
+		return files
+	}
+
+	var token string
+	pages := make([][]string, 0)
+	for {
+		objs, next, err := client.ListPage(dir, token, 4)
+		assert.Nil(t, err)
+		pages = append(pages, relative(objs))
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, [][]string{names[:4], names[4:]}, pages)
+
+	pageSize := WalkPageSize
+	defer func() { WalkPageSize = pageSize }()
+	WalkPageSize = 2
+	walked := make([]*Object, 0)
+	assert.Nil(t, client.Walk(dir, func(obj *Object) error {
+		walked = append(walked, obj)
+		return nil
+	}))
+	assert.Equal(t, names, relative(walked))
+	assert.Equal(t, int64(len("a/c/d.csv")), walked[4].Size)
+
+	walked = walked[:0]
+	assert.Nil(t, client.Walk(dir, func(obj *Object) error {
+		walked = append(walked, obj)
+		if len(walked) == 3 {
+			return ErrStopWalk
+		}
+		return nil
+	}))
+	assert.Equal(t, names[:3], relative(walked))
+
+	failure := errors.New("failure")
+	assert.Equal(t, failure, client.Walk(dir, func(obj *Object) error { return failure }))
+}
+
+func TestWalk(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fileStorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	t.Run("file", func(t *testing.T) {
+		doWalkTestCases(t, NewFileStorage(nil), tempDir)
+	})
+	t.Run("gcs", func(t *testing.T) {
+		newFakeGCS(t)
+		doWalkTestCases(t, NewGCSStorage(nil), "gs://bucket")
+	})
+	t.Run("s3", func(t *testing.T) {
+		_, srv := newFakeS3(t)
+		doWalkTestCases(t, newTestS3Storage(srv.URL), "s3://bucket")
+	})
+	t.Run("mem", func(t *testing.T) {
+		doWalkTestCases(t, NewMemStorage(nil), "mem://walk")
+	})
+}
@@ -18,9 +18,12 @@ type Route struct {
 }
 
 var heartbeatController = &controllers.HeartbeatController{}
+var fileController = &controllers.FileController{}
 
 var routes = []Route{
 	{"HeartbeatGet", http.MethodGet, "/heartbeat", heartbeatController.Get},
+	{"FileGet", http.MethodGet, "/files", fileController.Get},
+	{"FileHead", http.MethodHead, "/files", fileController.Get},
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -2,8 +2,12 @@ package scan
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/LiveRamp/ae-copilot/config"
@@ -21,11 +25,17 @@ type Scanner interface {
 	Close()
 }
 
-func AsyncRunning() context.CancelFunc {
+// AsyncRunning starts the scanner, the returned func cancels it and waits at
+// most grace for the scan in flight, and the hygiene task it runs, to return.
+// It returns false if they did not return in time.
+func AsyncRunning() func(grace time.Duration) bool {
 	ctx, cancel := context.WithCancel(context.TODO())
 	ingestionJob := NewRejectedFileScanner(time.Second * time.Duration(config.Agent.ScanIntervalTime))
 	ingestionJob.AsyncRunning(ctx)
-	return cancel
+	return func(grace time.Duration) bool {
+		cancel()
+		return ingestionJob.wait(grace)
+	}
 }
 
 func NewRejectedFileScanner(duration time.Duration) *rejectedFileScanner {
@@ -42,16 +52,23 @@ type rejectedFileScanner struct {
 	duration time.Duration
 	stopped  bool
 	skip     map[string]bool
+	// feeds of the rejected folders, by folder.
+	feeds map[string]*storage.ChangeFeed
+	// running counts the scanning goroutine, restarted after a panic.
+	running sync.WaitGroup
 }
 
 func (s *rejectedFileScanner) AsyncRunning(ctx context.Context) {
 	logs.Info("rejected file scanner starts running.")
 	logs.Info(s.duration)
+	s.running.Add(1)
 	go func() {
+		defer s.running.Done()
 		defer func() {
 			if r := recover(); r != nil {
 				logs.Info("rejectedFileScanner.AsyncRunning", r)
-				go s.AsyncRunning(ctx)
+				// restarted before Done so that wait keeps waiting.
+				s.AsyncRunning(ctx)
 			}
 		}()
 		t1 := time.NewTimer(s.duration)
@@ -61,7 +78,7 @@ func (s *rejectedFileScanner) AsyncRunning(ctx context.Context) {
 			}
 			select {
 			case <-t1.C:
-				s.scanning()
+				s.scanning(ctx)
 				t1.Reset(s.duration)
 			case <-ctx.Done():
 				logs.Info("rejected file scanner stopped.")
@@ -71,60 +88,142 @@ func (s *rejectedFileScanner) AsyncRunning(ctx context.Context) {
 	}()
 }
 
-func (s *rejectedFileScanner) scanning() {
+// wait return true once the scanning goroutine returned, false if it did not
+// within timeout.
+func (s *rejectedFileScanner) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (s *rejectedFileScanner) scanning(ctx context.Context) {
 	for _, tenant := range config.Agent.Tenants {
-		files := s.listObjects(fmt.Sprintf(config.Agent.RejectPath, tenant, constant.REJECT_PATH_PREFIX))
-		for _, file := range files {
-			if strings.HasSuffix(file, constant.CSV_SUFFIX) && !s.isExist(file+constant.SCANED_SUFFIX, files) {
-				task := &models.RejectedFileRemediationTask{
-					TaskName:       file,
-					RejectedPrefix: file,
-					InPrefix:       strings.Replace(file, constant.REJECT_PATH_PREFIX, constant.IN_PATH_PREFIX, 1),
-				}
-				s.tryToDoTheTask(task)
+		if ctx.Err() != nil {
+			return
+		}
+		dir := fmt.Sprintf(config.Agent.RejectPath, tenant, constant.REJECT_PATH_PREFIX)
+		if err := s.walkFiles(ctx, dir, func(file string) {
+			task := &models.RejectedFileRemediationTask{
+				TaskName:       file,
+				RejectedPrefix: file,
+				InPrefix:       strings.Replace(file, constant.REJECT_PATH_PREFIX, constant.IN_PATH_PREFIX, 1),
+				Tenant:         tenant,
 			}
+			s.tryToDoTheTask(ctx, task)
+		}); err != nil {
+			logs.Error("scan rejected files error:" + err.Error())
 		}
 	}
 }
 
-func (s *rejectedFileScanner) tryToDoTheTask(task *models.RejectedFileRemediationTask) {
-	logs.Info("try to do the task %s.", task.TaskName)
-	s.skip[task.TaskName] = true
-	if err := s.putObject(task.RejectedPrefix+constant.SCANED_SUFFIX, []byte{}); err != nil {
-		logs.Error("put scanned file error:" + err.Error())
+// walkFiles calls fn for every csv file of the folders under dir added or
+// modified since the last walk and that has no scanned marker, as the changes
+// are listed. The changes are in lexical order and a marker sorts after its
+// csv file, so a csv file is settled once the walk has passed the name of its
+// marker. The walk is committed once fn returned for every file, the files of
+// an interrupted walk are reported again.
+func (s *rejectedFileScanner) walkFiles(ctx context.Context, dir string, fn func(file string)) error {
+	dir = strings.TrimSuffix(dir, "/")
+	feed, err := s.changeFeed(dir)
+	if err != nil {
+		return err
 	}
-	ctx, cancel := context.WithCancel(context.TODO())
-	defer cancel()
-	services.Processing(ctx, task)
-}
-
-func (s *rejectedFileScanner) listObjects(path string) []string {
-	files := []string{}
-	fs := storage.NewStorageClient(path, config.Agent.GCSCredentials)
-	if folders, err := fs.ListDirs(path); err == nil {
-		for _, folder := range folders {
-			if ls, _, err := fs.ListChildObjects(folder); err == nil {
-				for _, v := range ls {
-					files = append(files, v.FileName)
+	pending := []string{}
+	settle := func(name string) {
+		kept := pending[:0]
+		for _, file := range pending {
+			if marker := file + constant.SCANED_SUFFIX; name == "" || marker <= name {
+				if !feed.Has(marker) {
+					fn(file)
 				}
+				continue
 			}
+			kept = append(kept, file)
 		}
+		pending = kept
 	}
-	return files
+	err = feed.Walk(ctx, func(change *storage.Change) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		file := change.Object.FileName
+		settle(file)
+		switch {
+		case change.Type == storage.ObjectRemoved:
+			delete(s.skip, file)
+		case isCSVFile(file):
+			pending = append(pending, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	settle("")
+	return feed.Commit()
 }
 
-func (s *rejectedFileScanner) isExist(file string, files []string) bool {
-	exist := false
-	for _, f := range files {
-		if f == file {
-			exist = true
-			break
+// changeFeed return the feed of the files of the folders right under dir, as
+// ListDirs and ListChildObjects did, whose snapshot is kept in ScanSnapshotDir.
+func (s *rejectedFileScanner) changeFeed(dir string) (*storage.ChangeFeed, error) {
+	if feed, ok := s.feeds[dir]; ok {
+		return feed, nil
+	}
+	client, err := storage.NewStorageClient(dir, config.Agent.GCSCredentials)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := ""
+	if config.Agent.ScanSnapshotDir != "" {
+		sum := sha256.Sum256([]byte(dir))
+		snapshot = filepath.Join(config.Agent.ScanSnapshotDir, hex.EncodeToString(sum[:])+".json")
+	}
+	feed, err := storage.NewChangeFeed(client, dir+"/*/*", snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if s.feeds == nil {
+		s.feeds = map[string]*storage.ChangeFeed{}
+	}
+	s.feeds[dir] = feed
+	return feed, nil
+}
+
+// isCSVFile return true for a csv file, plain or compressed.
+func isCSVFile(name string) bool {
+	for _, suffix := range []string{constant.CSV_SUFFIX, constant.CSV_GZ_SUFFIX, constant.CSV_ZIP_SUFFIX} {
+		if strings.HasSuffix(name, suffix) {
+			return true
 		}
 	}
-	return exist
+	return false
 }
 
-func (s *rejectedFileScanner) putObject(path string, data []byte) error {
-	fs := storage.NewStorageClient(path, config.Agent.GCSCredentials)
-	return fs.PutObject(path, data)
+func (s *rejectedFileScanner) tryToDoTheTask(ctx context.Context, task *models.RejectedFileRemediationTask) {
+	logs.Info("try to do the task %s.", task.TaskName)
+	s.skip[task.TaskName] = true
+	if err := s.putObject(ctx, task.RejectedPrefix+constant.SCANED_SUFFIX, []byte{}); err != nil {
+		logs.Error("put scanned file error:" + err.Error())
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	services.Processing(ctx, task)
+}
+
+func (s *rejectedFileScanner) putObject(ctx context.Context, path string, data []byte) error {
+	client, err := storage.NewStorageClient(path, config.Agent.GCSCredentials)
+	if err != nil {
+		return err
+	}
+	return client.WithContext(ctx).PutObject(path, data)
 }
@@ -0,0 +1,120 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LiveRamp/ae-copilot/config"
+	"github.com/LiveRamp/ae-copilot/pkg/libs/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanning(t *testing.T) {
+	rejectPath, tenants := config.Agent.RejectPath, config.Agent.Tenants
+	defer func() {
+		config.Agent.RejectPath, config.Agent.Tenants = rejectPath, tenants
+	}()
+	config.Agent.RejectPath = "mem://scanning/%s/%s"
+	config.Agent.Tenants = []string{"721211"}
+
+	fs := storage.NewMemStorage(nil)
+	assert.Nil(t, fs.PutObject("mem://scanning/721211/REJECT/folder/a.csv", []byte("id,\"name\"\n1,\"a\"\n")))
+	assert.Nil(t, fs.PutObject("mem://scanning/721211/REJECT/folder/b.csv", []byte("id\n2\n")))
+	assert.Nil(t, fs.PutObject("mem://scanning/721211/REJECT/folder/b.csv.scan", nil))
+	assert.Nil(t, fs.PutObject("mem://scanning/721211/REJECT/folder/deep/c.csv", []byte("id\n3\n")))
+	assert.Nil(t, fs.PutObject("mem://scanning/721211/REJECT/d.csv", []byte("id\n4\n")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := &rejectedFileScanner{duration: time.Second, skip: map[string]bool{}}
+	s.scanning(ctx)
+	assert.False(t, fs.IsExist("mem://scanning/721211/REJECT/folder/a.csv.scan"))
+
+	s.scanning(context.Background())
+
+	data, err := fs.GetObject("mem://scanning/721211/in/folder/a.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "id,name\n1,a\n", string(data))
+	assert.True(t, fs.IsExist("mem://scanning/721211/REJECT/folder/a.csv.scan"))
+	assert.False(t, fs.IsExist("mem://scanning/721211/in/folder/b.csv"))
+	assert.False(t, fs.IsExist("mem://scanning/721211/in/folder/deep/c.csv"))
+	assert.False(t, fs.IsExist("mem://scanning/721211/in/d.csv"))
+}
+
+func TestAsyncRunning_Wait(t *testing.T) {
+	rejectPath, tenants := config.Agent.RejectPath, config.Agent.Tenants
+	defer func() {
+		config.Agent.RejectPath, config.Agent.Tenants = rejectPath, tenants
+	}()
+	config.Agent.RejectPath = "mem://async-running/%s/%s"
+	config.Agent.Tenants = []string{"721211"}
+
+	s := &rejectedFileScanner{duration: time.Millisecond, skip: map[string]bool{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.AsyncRunning(ctx)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.True(t, s.wait(time.Second))
+
+	// a scan in flight is waited for until the grace period is over.
+	s.running.Add(1)
+	assert.False(t, s.wait(10*time.Millisecond))
+	s.running.Done()
+	assert.True(t, s.wait(time.Second))
+}
+
+func TestWalkFiles(t *testing.T) {
+	fs := storage.NewMemStorage(nil)
+	for _, name := range []string{"a-b.csv", "a.csv", "a.csv.bak", "a.csv.scan", "b.csv", "b.csv.scan", "c.csv", "c.csv.gz", "c.csv.gz.scan", "c.txt", "d.csv.zip", "e.gz"} {
+		assert.Nil(t, fs.PutObject("mem://walk-files/721211/REJECT/folder/"+name, nil))
+	}
+	s := &rejectedFileScanner{duration: time.Second, skip: map[string]bool{}}
+	files := []string{}
+	assert.Nil(t, s.walkFiles(context.Background(), "mem://walk-files/721211/REJECT/", func(file string) {
+		files = append(files, strings.TrimPrefix(file, "mem://walk-files/721211/REJECT/folder/"))
+	}))
+	assert.Equal(t, []string{"a-b.csv", "c.csv", "d.csv.zip"}, files)
+}
+
+func TestWalkFiles_Changes(t *testing.T) {
+	snapshotDir := config.Agent.ScanSnapshotDir
+	defer func() {
+		config.Agent.ScanSnapshotDir = snapshotDir
+	}()
+	tempDir, err := ioutil.TempDir("", "snapshots")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	config.Agent.ScanSnapshotDir = tempDir
+
+	fs := storage.NewMemStorage(nil)
+	assert.Nil(t, fs.PutObject("mem://walk-changes/721211/REJECT/folder/a.csv", nil))
+	walk := func(s *rejectedFileScanner) []string {
+		files := []string{}
+		assert.Nil(t, s.walkFiles(context.Background(), "mem://walk-changes/721211/REJECT/", func(file string) {
+			files = append(files, strings.TrimPrefix(file, "mem://walk-changes/721211/REJECT/folder/"))
+			s.skip[file] = true
+		}))
+		return files
+	}
+	s := &rejectedFileScanner{duration: time.Second, skip: map[string]bool{}}
+	assert.Equal(t, []string{"a.csv"}, walk(s))
+	assert.Empty(t, walk(s))
+
+	assert.Nil(t, fs.PutObject("mem://walk-changes/721211/REJECT/folder/b.csv", nil))
+	assert.Nil(t, fs.PutObject("mem://walk-changes/721211/REJECT/folder/c.csv", nil))
+	assert.Nil(t, fs.PutObject("mem://walk-changes/721211/REJECT/folder/c.csv.scan", nil))
+	assert.Equal(t, []string{"b.csv"}, walk(s))
+
+	assert.Nil(t, fs.RemoveObject("mem://walk-changes/721211/REJECT/folder/a.csv"))
+	assert.Empty(t, walk(s))
+	assert.False(t, s.skip["mem://walk-changes/721211/REJECT/folder/a.csv"])
+
+	// a restarted scanner starts from the snapshot.
+	assert.Nil(t, fs.PutObject("mem://walk-changes/721211/REJECT/folder/d.csv", nil))
+	restarted := &rejectedFileScanner{duration: time.Second, skip: map[string]bool{}}
+	assert.Equal(t, []string{"d.csv"}, walk(restarted))
+}
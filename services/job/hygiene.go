@@ -2,22 +2,33 @@ package job
 
 import (
 	"bufio"
+	"context"
 	"encoding/csv"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-	"path"
 	"strings"
+	"time"
 
 	"github.com/LiveRamp/ae-copilot/config"
 	"github.com/LiveRamp/ae-copilot/models"
 	"github.com/LiveRamp/ae-copilot/pkg/libs/storage"
-	constant "github.com/LiveRamp/ae-copilot/utils"
 	"github.com/astaxie/beego/logs"
 )
 
 const (
-	batchSize = 1000 // 每批次的记录数
+	batchSize        = 1000 // 每批次的记录数
+	checksumAttempts = 3    // runs of a task failing on corrupted data
+	// ruleVersion is recorded on the remediated files, bump it when processCSV changes.
+	ruleVersion = "1"
 )
 
+// taskProgress exposes the progress of the running tasks on /debug/vars, keyed by task name.
+var taskProgress = expvar.NewMap("hygiene_progress")
+
 type Hygiene struct {
 }
 
@@ -25,58 +36,140 @@ func NewHygiene() *Hygiene {
 	return &Hygiene{}
 }
 
-func (h *Hygiene) Running(task *models.RejectedFileRemediationTask) error {
+func (h *Hygiene) Running(ctx context.Context, task *models.RejectedFileRemediationTask) error {
 	logs.Info("Hygiene: start to running.")
+	defer taskProgress.Delete(task.TaskName)
 
-	if err := h.doing(task); err != nil {
+	if err := retryOnChecksumMismatch(task.TaskName, func() error {
+		return h.doing(ctx, task)
+	}); err != nil {
 		return err
 	}
 	logs.Info("Hygiene: finished task", task.TaskName)
 	return nil
 }
-func (h *Hygiene) doing(task *models.RejectedFileRemediationTask) error {
-	fs := storage.NewStorageClient(task.RejectedPrefix, config.Agent.GCSCredentials)
-	fileName := path.Base(task.RejectedPrefix)
-
-	sourceFile := constant.TEMP_DIR + fileName + constant.DOWNLOAD_SUFFIX
-	temFile := constant.TEMP_DIR + fileName
-	logs.Info("Hygiene: start to download.", sourceFile)
-	if err := fs.Download(task.RejectedPrefix, sourceFile); err != nil {
-		logs.Error("Hygiene: download file failed.", err)
+func (h *Hygiene) doing(ctx context.Context, task *models.RejectedFileRemediationTask) error {
+	client, err := storage.NewStorageClient(task.RejectedPrefix, config.Agent.GCSCredentialsOf(task.Tenant))
+	if err != nil {
+		logs.Error("Hygiene: create storage client failed.", err)
+		return err
+	}
+	// the client routes the in file to its own backend, it may differ from the rejected one.
+	fs := client.WithContext(ctx)
+	logs.Info("Hygiene: start to read.", task.RejectedPrefix)
+	reader, err := openRejected(fs, task.RejectedPrefix)
+	if err != nil {
+		logs.Error("Hygiene: open rejected file failed.", err)
+		return err
+	}
+	defer reader.Close()
+	total := rejectedSize(fs, task.RejectedPrefix)
+	// the progress is the one of the rejected file as stored, compressed or not.
+	compression := storage.CompressionOf(task.RejectedPrefix)
+	input, err := storage.NewDecompressingReader(ioutil.NopCloser(storage.NewProgressReader(reader, total, reportProgress(task.TaskName))), compression)
+	if err != nil {
+		logs.Error("Hygiene: decompress rejected file failed.", err)
+		return err
+	}
+	defer input.Close()
+
+	// the in file is republished in the compression of the rejected file.
+	opts := []storage.WriteOption{storage.WithMetadata(map[string]string{
+		"source":       task.RejectedPrefix,
+		"rule-version": ruleVersion,
+		"processed-at": time.Now().UTC().Format(time.RFC3339),
+	})}
+	if contentType := compression.ContentType(); contentType != "" {
+		opts = append(opts, storage.WithContentType(contentType))
+	}
+	stored, err := fs.OpenWriter(task.InPrefix, opts...)
+	if err != nil {
+		logs.Error("Hygiene: open in file failed.", err)
 		return err
 	}
-	defer os.Remove(sourceFile)
-	err := processCSVFile(sourceFile, temFile, task.InPrefix)
+	writer, err := storage.NewCompressingWriter(stored, compression, compression.TrimExtension(task.InPrefix))
 	if err != nil {
+		logs.Error("Hygiene: open in file failed.", err)
+		return err
+	}
+	if err := processCSV(input, writer); err != nil {
 		logs.Error("Hygiene: remove quotes failed.", err)
-		return nil
+		storage.AbortWriter(writer, err)
+		return err
 	}
-	return nil
+	logs.Info("Hygiene: start to commit csv file.", task.InPrefix)
+	return writer.Close()
 }
 
-func processCSVFile(inputPath, outputPath, inPrefix string) error {
-	logs.Info("Hygiene: start to process csv file.")
-	// 打开原始文件
-	file, err := os.Open(inputPath)
-	if err != nil {
-		return err
+// rejectedSize return the size of the rejected file, -1 if unknown. A local file
+// is sized by os.Stat, Stat would checksum it too.
+func rejectedSize(fs storage.Storage, node string) int64 {
+	if storage.IsLocal(node) {
+		if info, err := os.Stat(node); err == nil {
+			return info.Size()
+		}
+		return -1
 	}
-	defer file.Close()
-	defer os.Remove(inputPath)
+	if obj, err := fs.Stat(node); err == nil {
+		return obj.Size
+	}
+	return -1
+}
 
-	// 打开输出文件
-	outputFile, err := os.Create(outputPath)
+// openRejected return a stream of the rejected file. With a download cache the
+// file is downloaded first, so a task retried or rerun reads it from local disk.
+func openRejected(fs storage.Storage, node string) (io.ReadCloser, error) {
+	if storage.Cache == nil {
+		return fs.OpenReader(node)
+	}
+	file, err := ioutil.TempFile("", "hygiene-*")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	file.Close()
+	if err := fs.Download(node, file.Name()); err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	downloaded, err := os.Open(file.Name())
+	// removed once closed, or right away on linux.
+	os.Remove(file.Name())
+	if err != nil {
+		return nil, err
 	}
-	defer outputFile.Close()
-	defer os.Remove(outputPath)
+	return downloaded, nil
+}
 
+// reportProgress return the observer of the read of the rejected file of a task,
+// it logs the progress and publishes it in taskProgress.
+func reportProgress(taskName string) storage.ProgressFunc {
+	return func(p storage.Progress) {
+		taskProgress.Set(taskName, expvar.Func(func() interface{} { return p }))
+		// formatted first, beego does not format a message holding %%.
+		logs.Info(fmt.Sprintf("Hygiene: %s read %d of %d bytes (%.1f%%) at %.0f B/s.", taskName, p.Transferred, p.Total, p.Percent(), p.Rate))
+	}
+}
+
+// retryOnChecksumMismatch runs fn again while it fails on corrupted data, the
+// output of a failed run is aborted so nothing corrupt is published.
+func retryOnChecksumMismatch(taskName string, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= checksumAttempts; attempt++ {
+		if err = fn(); !errors.Is(err, storage.ErrChecksumMismatch) {
+			return err
+		}
+		logs.Warn("Hygiene: checksum mismatch, retry the task.", taskName, attempt, err)
+	}
+	return err
+}
+
+// processCSV streams the records of input through the remediation rules into output.
+func processCSV(input io.Reader, output io.Writer) error {
+	logs.Info("Hygiene: start to process csv file.")
 	// 创建 CSV 读取器和写入器
 	// reader := csv.NewReader(file)
-	reader := bufio.NewReader(file)
-	writer := csv.NewWriter(outputFile)
-	defer writer.Flush()
+	reader := bufio.NewReader(input)
+	writer := csv.NewWriter(output)
 
 	scanner := bufio.NewScanner(reader)
 	scanner.Split(bufio.ScanLines)
@@ -85,7 +178,7 @@ func processCSVFile(inputPath, outputPath, inPrefix string) error {
 		records, err := readBatch(scanner)
 		if err != nil {
 			logs.Error("Hygiene: read batch failed.", err)
-			break // 文件读取出错，跳出循环
+			return err
 		}
 		if len(records) == 0 {
 			break // 文件读取完毕,跳出循环
@@ -104,9 +197,8 @@ func processCSVFile(inputPath, outputPath, inPrefix string) error {
 			}
 		}
 	}
-	logs.Info("Hygiene: start to upload csv file.")
-	fs := storage.NewStorageClient(inPrefix, config.Agent.GCSCredentials)
-	return fs.Upload(outputPath, inPrefix)
+	writer.Flush()
+	return writer.Error()
 }
 
 func readBatch(scanner *bufio.Scanner) ([][]string, error) {
@@ -2,15 +2,178 @@ package job
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/csv"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/LiveRamp/ae-copilot/models"
+	"github.com/LiveRamp/ae-copilot/pkg/libs/storage"
+	"github.com/astaxie/beego/logs"
+	"github.com/stretchr/testify/assert"
 )
 
+func TestProcessCSV(t *testing.T) {
+	input := strings.NewReader("id,\"name\"\n1,\"a \"\"quoted\"\" value\"\n")
+	output := new(bytes.Buffer)
+	assert.Nil(t, processCSV(input, output))
+	assert.Equal(t, "id,name\n1,a quoted value\n", output.String())
+}
+
+func TestHygieneRunning(t *testing.T) {
+	fs := storage.NewMemStorage(nil)
+	task := &models.RejectedFileRemediationTask{
+		TaskName:       "mem://hygiene/721211/REJECT/folder/a.csv",
+		RejectedPrefix: "mem://hygiene/721211/REJECT/folder/a.csv",
+		InPrefix:       "mem://hygiene/721211/in/folder/a.csv",
+	}
+	assert.Nil(t, fs.PutObject(task.RejectedPrefix, []byte("id,\"name\"\n1,\"a\"\n")))
+	assert.Nil(t, NewHygiene().Running(context.Background(), task))
+	data, err := fs.GetObject(task.InPrefix)
+	assert.Nil(t, err)
+	assert.Equal(t, "id,name\n1,a\n", string(data))
+	obj, err := fs.Stat(task.InPrefix)
+	assert.Nil(t, err)
+	assert.Equal(t, task.RejectedPrefix, obj.Metadata["source"])
+	assert.Equal(t, ruleVersion, obj.Metadata["rule-version"])
+	assert.NotEmpty(t, obj.Metadata["processed-at"])
+
+	task.RejectedPrefix = "mem://hygiene/721211/REJECT/folder/missing.csv"
+	task.InPrefix = "mem://hygiene/721211/in/folder/missing.csv"
+	assert.NotNil(t, NewHygiene().Running(context.Background(), task))
+	assert.False(t, fs.IsExist(task.InPrefix))
+}
+
+func TestHygieneRunning_Compressed(t *testing.T) {
+	fs := storage.NewMemStorage(nil)
+	for _, name := range []string{"a.csv.gz", "a.csv.zip"} {
+		task := &models.RejectedFileRemediationTask{
+			TaskName:       "mem://hygiene-compressed/721211/REJECT/folder/" + name,
+			RejectedPrefix: "mem://hygiene-compressed/721211/REJECT/folder/" + name,
+			InPrefix:       "mem://hygiene-compressed/721211/in/folder/" + name,
+		}
+		compression := storage.CompressionOf(name)
+		w, err := fs.OpenWriter(task.RejectedPrefix)
+		assert.Nil(t, err)
+		w, err = storage.NewCompressingWriter(w, compression, "a.csv")
+		assert.Nil(t, err)
+		w.Write([]byte("id,\"name\"\n1,\"a\"\n"))
+		assert.Nil(t, w.Close())
+
+		assert.Nil(t, NewHygiene().Running(context.Background(), task))
+		obj, err := fs.Stat(task.InPrefix)
+		assert.Nil(t, err)
+		assert.Equal(t, compression.ContentType(), obj.ContentType)
+		r, err := fs.OpenReader(task.InPrefix)
+		assert.Nil(t, err)
+		r, err = storage.NewDecompressingReader(r, compression)
+		assert.Nil(t, err, name)
+		data, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		r.Close()
+		assert.Equal(t, "id,name\n1,a\n", string(data), name)
+	}
+}
+
+func TestHygieneRunning_Cached(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "hygieneCache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	storage.Cache, err = storage.NewDownloadCache(tempDir, 1<<20)
+	assert.Nil(t, err)
+	defer func() { storage.Cache = nil }()
+
+	fs := storage.NewMemStorage(nil)
+	task := &models.RejectedFileRemediationTask{
+		TaskName:       "mem://hygiene-cached/721211/REJECT/folder/a.csv",
+		RejectedPrefix: "mem://hygiene-cached/721211/REJECT/folder/a.csv",
+		InPrefix:       "mem://hygiene-cached/721211/in/folder/a.csv",
+	}
+	assert.Nil(t, fs.PutObject(task.RejectedPrefix, []byte("id,\"name\"\n1,\"a\"\n")))
+	for run := 0; run < 2; run++ {
+		assert.Nil(t, NewHygiene().Running(context.Background(), task))
+		data, err := fs.GetObject(task.InPrefix)
+		assert.Nil(t, err)
+		assert.Equal(t, "id,name\n1,a\n", string(data))
+	}
+	infos, err := ioutil.ReadDir(tempDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(infos))
+}
+
+// capturedLogs keeps the messages logged through beego.
+type capturedLogs struct {
+	msgs []string
+}
+
+func (c *capturedLogs) Init(config string) error { return nil }
+func (c *capturedLogs) WriteMsg(when time.Time, msg string, level int) error {
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+func (c *capturedLogs) Destroy() {}
+func (c *capturedLogs) Flush()   {}
+
+func TestReportProgress(t *testing.T) {
+	captured := new(capturedLogs)
+	logs.Register("hygiene-test", func() logs.Logger { return captured })
+	assert.Nil(t, logs.SetLogger("hygiene-test"))
+	defer logs.GetBeeLogger().DelLogger("hygiene-test")
+
+	reportProgress("task")(storage.Progress{Transferred: 5, Total: 10, Rate: 2})
+	assert.JSONEq(t, `{"Transferred":5,"Total":10,"Rate":2,"Elapsed":0}`, taskProgress.Get("task").String())
+	taskProgress.Delete("task")
+	assert.Len(t, captured.msgs, 1)
+	assert.True(t, strings.HasSuffix(captured.msgs[0], "Hygiene: task read 5 of 10 bytes (50.0%) at 2 B/s."), captured.msgs[0])
+}
+
+func TestRetryOnChecksumMismatch(t *testing.T) {
+	calls := 0
+	err := retryOnChecksumMismatch("task", func() error {
+		if calls++; calls < 2 {
+			return fmt.Errorf("%w: corrupted", storage.ErrChecksumMismatch)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = retryOnChecksumMismatch("task", func() error {
+		calls++
+		return storage.ErrChecksumMismatch
+	})
+	assert.Equal(t, storage.ErrChecksumMismatch, err)
+	assert.Equal(t, checksumAttempts, calls)
+
+	calls = 0
+	err = retryOnChecksumMismatch("task", func() error {
+		calls++
+		return storage.ErrCodeNoSuchKey
+	})
+	assert.Equal(t, storage.ErrCodeNoSuchKey, err)
+	assert.Equal(t, 1, calls)
+}
+
 func TestProcess(t *testing.T) {
 	input := "/Users/hading/Workspace/New_SafeHeaven/ae-copilot/tmp/full_20231107-030703_Imp_n_click_data.csv.source"
 	output := "/Users/hading/Workspace/New_SafeHeaven/ae-copilot/tmp/full_20231107-030703_Imp_n_click_data.csv"
-	if err := processCSVFile(input, output, "gs://lr-select-vm-us-qa-temp/721211/in/inp-clid/full_20231107-030703_Imp_n_click_data.csv"); err != nil {
+	inputFile, err := os.Open(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inputFile.Close()
+	outputFile, err := os.Create(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outputFile.Close()
+	if err := processCSV(inputFile, outputFile); err != nil {
 		t.Fatal(err)
 	}
 }
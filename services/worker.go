@@ -9,7 +9,7 @@ import (
 
 func Processing(ctx context.Context, task *models.RejectedFileRemediationTask) error {
 	hygiene := job.NewHygiene()
-	if err := hygiene.Running(task); err != nil {
+	if err := hygiene.Running(ctx, task); err != nil {
 		return err
 	}
 	return nil
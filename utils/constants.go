@@ -5,6 +5,8 @@ const (
 	NUM_GO_ROUTINES    = 4
 	GCS_PATH_DELIMITER = "/"
 	CSV_SUFFIX         = ".csv"
+	CSV_GZ_SUFFIX      = ".csv.gz"
+	CSV_ZIP_SUFFIX     = ".csv.zip"
 	SCANED_SUFFIX      = ".scan"
 	DOWNLOAD_SUFFIX    = ".download"
 	IN_PATH_PREFIX     = "in/"